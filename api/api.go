@@ -6,9 +6,8 @@
 package api
 
 import (
+	"context"
 	"net/http"
-
-	"golang.org/x/net/context"
 )
 
 // APIProvider is the interface that APIs should implement to be served from the daemon.
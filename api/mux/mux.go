@@ -6,9 +6,13 @@
 package mux
 
 import (
+	"context"
 	"github.com/syncato/lib/api"
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/authz"
+	"github.com/syncato/lib/config"
 	"github.com/syncato/lib/logger"
-	"golang.org/x/net/context"
+	"github.com/syncato/lib/requestid"
 	"net/http"
 	"strings"
 )
@@ -16,13 +20,21 @@ import (
 // APIMux is the multiplexer responsible for routing request to a specific API.
 // It keeps a map with all the APIs.
 type APIMux struct {
-	apis map[string]api.APIProvider
-	log  *logger.Logger
+	apis       map[string]api.APIProvider
+	log        *logger.Logger
+	requestID  func(ctx context.Context, w http.ResponseWriter, r *http.Request, next func(ctx context.Context, w http.ResponseWriter, r *http.Request))
+	authorizer *authz.Authorizer
 }
 
-// NewAPIMux creates a new APIMux object or return an error
-func NewAPIMux(logger *logger.Logger) (*APIMux, error) {
-	apimux := &APIMux{map[string]api.APIProvider{}, logger}
+// NewAPIMux creates a new APIMux object or return an error.
+// The APIs are only used by the users allowed by the authorization policies of the configuration,
+// see authz.Authorizer.
+func NewAPIMux(cfg *config.Config, logger *logger.Logger) (*APIMux, error) {
+	authorizer, err := authz.NewAuthorizer(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	apimux := &APIMux{map[string]api.APIProvider{}, logger, requestid.NewMiddleware(logger), authorizer}
 	return apimux, nil
 }
 
@@ -38,7 +50,16 @@ func (apimux *APIMux) GetAPI(apiID string) api.APIProvider {
 
 // HandleRequest routes a general request to the specific API or returns 404 if the API
 // asked is not registered.
+// The context passed to the API carries the request ID and a logger logging it, see requestid.NewMiddleware.
+// The requests to an API that requires some roles return 401 (Unauthorized) if they have not been authenticated
+// and 403 (Forbidden) if the user has none of the roles, see authz.Authorizer.Middleware. The other
+// unauthenticated requests are passed to the API, so the public APIs can be served by the APIMux too.
 func (apimux *APIMux) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	apimux.requestID(ctx, w, r, apimux.route)
+}
+
+// route routes the request to the API of the ID in its path.
+func (apimux *APIMux) route(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	urlParts := strings.Split(path, "/")
 	// a correct url will be /api/files/something, so the len of the urlParts should be at least 3
@@ -52,5 +73,9 @@ func (apimux *APIMux) HandleRequest(ctx context.Context, w http.ResponseWriter,
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
+	if _, ok := auth.FromContext(ctx); ok || apimux.authorizer.RequiresRole(apiID) {
+		apimux.authorizer.Middleware(ctx, w, r, api.HandleRequest)
+		return
+	}
 	api.HandleRequest(ctx, w, r)
 }
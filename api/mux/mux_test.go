@@ -0,0 +1,66 @@
+package mux
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	apimux *APIMux
+}
+
+var _ = Suite(&TestSuite{})
+
+type testAPI string
+
+func (a testAPI) GetID() string { return string(a) }
+
+func (a testAPI) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *TestSuite) SetUpTest(c *C) {
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(`{"authz_api_roles": {"admin": ["admin"]}}`), 0600), IsNil)
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	s.apimux, err = NewAPIMux(cfg, log)
+	c.Assert(err, IsNil)
+	s.apimux.RegisterApi(testAPI("admin"))
+	s.apimux.RegisterApi(testAPI("public"))
+}
+
+func (s *TestSuite) TestAuthorization(c *C) {
+	serve := func(authRes *auth.AuthResource, path string) int {
+		ctx := context.Background()
+		if authRes != nil {
+			ctx = auth.NewContext(ctx, authRes)
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.apimux.HandleRequest(ctx, w, r)
+		return w.Code
+	}
+
+	alice := &auth.AuthResource{Username: "alice", Roles: []string{"admin"}}
+	bob := &auth.AuthResource{Username: "bob"}
+	c.Assert(serve(nil, "/api/admin/users"), Equals, http.StatusUnauthorized)
+	c.Assert(serve(bob, "/api/admin/users"), Equals, http.StatusForbidden)
+	c.Assert(serve(alice, "/api/admin/users"), Equals, http.StatusOK)
+	c.Assert(serve(nil, "/api/public/links"), Equals, http.StatusOK)
+	c.Assert(serve(bob, "/api/public/links"), Equals, http.StatusOK)
+	c.Assert(serve(nil, "/api/missing"), Equals, http.StatusNotFound)
+}
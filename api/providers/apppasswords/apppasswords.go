@@ -0,0 +1,122 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package apppasswords implements the APIProvider interface to let users manage their app passwords.
+package apppasswords
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	"github.com/syncato/lib/auth/token"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// CreateResponse is the response of the create endpoint.
+type CreateResponse struct {
+	Password    string             `json:"password"`     // the app password, it is only shown once.
+	AppPassword *token.AppPassword `json:"app_password"` // the details of the app password.
+}
+
+// APIAppPasswords is the implementation of the APIProvider interface to manage the app passwords
+// of the authenticated user. It must be served behind the AuthMiddleware of the auth multiplexer.
+//
+// It serves the following endpoints:
+//
+// 1. GET /api/<id>/ lists the app passwords of the user.
+//
+// 2. POST /api/<id>/ creates an app password with the label, read_only and schemes (comma separated)
+// form values and responds with a CreateResponse.
+//
+// 3. DELETE /api/<id>/<password id> revokes an app password.
+//
+// Requests authenticated with an app password are rejected, so a leaked app password cannot be used
+// to create new ones.
+type APIAppPasswords struct {
+	id      string
+	authMux *authmux.AuthMux
+	cfg     *config.Config
+	log     *logger.Logger
+}
+
+// NewAPIAppPasswords returns an APIAppPasswords object or an error.
+func NewAPIAppPasswords(id string, authMux *authmux.AuthMux, cfg *config.Config, log *logger.Logger) (*APIAppPasswords, error) {
+	return &APIAppPasswords{id: id, authMux: authMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the app passwords API.
+func (a *APIAppPasswords) GetID() string {
+	return a.id
+}
+
+// HandleRequest routes the request to the list, create or revoke endpoint.
+func (a *APIAppPasswords) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	authRes, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if authRes.Scope != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	pid := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"+a.id), "/")
+	switch {
+	case r.Method == "GET" && pid == "":
+		a.list(authRes, w, r)
+	case r.Method == "POST" && pid == "":
+		a.create(authRes, w, r)
+	case r.Method == "DELETE" && pid != "":
+		a.revoke(authRes, pid, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *APIAppPasswords) list(authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.authMux.AppPasswords().List(authRes.Username))
+}
+
+func (a *APIAppPasswords) create(authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	scope := &auth.Scope{ReadOnly: r.PostFormValue("read_only") == "true"}
+	for _, scheme := range strings.Split(r.PostFormValue("schemes"), ",") {
+		if scheme = strings.TrimSpace(scheme); scheme != "" {
+			scope.Schemes = append(scope.Schemes, scheme)
+		}
+	}
+
+	password, p, err := a.authMux.AppPasswords().Create(authRes, r.PostFormValue("label"), scope)
+	if err != nil {
+		a.log.Error("failed creating app password", map[string]interface{}{"username": authRes.Username, "err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("app password created", map[string]interface{}{"username": authRes.Username, "id": p.ID, "label": p.Label})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&CreateResponse{Password: password, AppPassword: p})
+}
+
+func (a *APIAppPasswords) revoke(authRes *auth.AuthResource, pid string, w http.ResponseWriter, r *http.Request) {
+	if err := a.authMux.AppPasswords().Revoke(authRes.Username, pid); err != nil {
+		a.log.Warn("failed revoking app password", map[string]interface{}{"username": authRes.Username, "id": pid, "err": err})
+		if token.IsInvalidAppPasswordError(err) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("app password revoked", map[string]interface{}{"username": authRes.Username, "id": pid})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package impersonate implements the APIProvider interface to let administrators act as another user.
+package impersonate
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// TokenResponse is the response of the impersonate endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"` // the JWT authentication token to be sent in the X-Auth-Key header.
+	ExpiresIn   int    `json:"expires_in"`   // the duration in seconds of the access token.
+}
+
+// APIImpersonate is the implementation of the APIProvider interface to obtain tokens to impersonate users.
+// It must be served behind the AuthMiddleware of the auth multiplexer.
+//
+// POST /api/<id>/ creates a token to act as the user of the username and auth_id form values and responds with
+// a TokenResponse. The token is read only unless the read_only form value is false, and it is restricted to
+// the storages of the schemes form value (comma separated) if present.
+// Only administrators can impersonate users, see AuthMux.Impersonate.
+type APIImpersonate struct {
+	id      string
+	authMux *authmux.AuthMux
+	cfg     *config.Config
+	log     *logger.Logger
+}
+
+// NewAPIImpersonate returns an APIImpersonate object or an error.
+func NewAPIImpersonate(id string, authMux *authmux.AuthMux, cfg *config.Config, log *logger.Logger) (*APIImpersonate, error) {
+	return &APIImpersonate{id: id, authMux: authMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the impersonate API.
+func (a *APIImpersonate) GetID() string {
+	return a.id
+}
+
+// HandleRequest creates the impersonation token.
+func (a *APIImpersonate) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	username := r.PostFormValue("username")
+	if username == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	scope := &auth.Scope{ReadOnly: r.PostFormValue("read_only") != "false"}
+	for _, scheme := range strings.Split(r.PostFormValue("schemes"), ",") {
+		if scheme = strings.TrimSpace(scheme); scheme != "" {
+			scope.Schemes = append(scope.Schemes, scheme)
+		}
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	log := logger.FromContext(ctx, a.log)
+	accessToken, err := a.authMux.Impersonate(actor, username, r.PostFormValue("auth_id"), scope, addr)
+	if err != nil {
+		log.Warn("impersonation failed", map[string]interface{}{"actor": actor.Username, "username": username, "err": err})
+		switch {
+		case auth.IsImpersonationNotAllowedError(err):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		case auth.IsUserNotFoundError(err):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		default:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Info("impersonation token created", map[string]interface{}{"actor": actor.Username, "username": username})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(&TokenResponse{AccessToken: accessToken, ExpiresIn: a.cfg.AuthImpersonationTime()})
+}
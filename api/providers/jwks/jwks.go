@@ -0,0 +1,52 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package jwks implements the APIProvider interface to publish the public keys used to verify
+// the JWT authentication tokens issued by the daemon.
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/syncato/lib/auth/token"
+	"github.com/syncato/lib/logger"
+)
+
+// APIJWKS is the implementation of the APIProvider interface to serve the public keys of the key set
+// as a JSON Web Key Set, so third parties can verify the tokens issued by the daemon.
+// The keys are served on GET /api/<id> and this API does not require authentication.
+type APIJWKS struct {
+	id     string
+	keySet *token.KeySet
+	log    *logger.Logger
+}
+
+// NewAPIJWKS returns an APIJWKS object or an error.
+func NewAPIJWKS(id string, keySet *token.KeySet, log *logger.Logger) (*APIJWKS, error) {
+	return &APIJWKS{id: id, keySet: keySet, log: log}, nil
+}
+
+// GetID returns the ID of the JWKS API.
+func (a *APIJWKS) GetID() string {
+	return a.id
+}
+
+// HandleRequest serves the JSON Web Key Set.
+func (a *APIJWKS) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	set, err := a.keySet.JWKS()
+	if err != nil {
+		a.log.Error("failed creating jwks", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(set)
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package links implements the APIProviders to manage public share links and to serve them anonymously.
+package links
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/share"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+)
+
+// APILinks is the implementation of the APIProvider interface to manage the public links of the
+// authenticated user. It must be served behind the AuthMiddleware of the auth multiplexer.
+//
+// It serves the following endpoints:
+//
+// 1. GET /api/<id>/ lists the links of the user.
+//
+// 2. POST /api/<id>/ creates a link to the resource of the uri form value, like local://photos, with the
+// permission (read, upload or read-write), password, expires (unix seconds) and max_downloads form values,
+// and responds with the share.Link.
+//
+// 3. DELETE /api/<id>/<token> removes a link.
+type APILinks struct {
+	id         string
+	links      *share.LinkStore
+	storageMux *storagemux.StorageMux
+	cfg        *config.Config
+	log        *logger.Logger
+}
+
+// NewAPILinks returns an APILinks object or an error.
+func NewAPILinks(id string, links *share.LinkStore, storageMux *storagemux.StorageMux, cfg *config.Config, log *logger.Logger) (*APILinks, error) {
+	return &APILinks{id: id, links: links, storageMux: storageMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the links API.
+func (a *APILinks) GetID() string {
+	return a.id
+}
+
+// HandleRequest routes the request to the list, create or delete endpoint.
+func (a *APILinks) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	authRes, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"+a.id), "/")
+	switch {
+	case r.Method == "GET" && token == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.links.List(authRes))
+	case r.Method == "POST" && token == "":
+		a.create(ctx, authRes, w, r)
+	case r.Method == "DELETE" && token != "":
+		a.delete(ctx, authRes, token, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *APILinks) create(ctx context.Context, authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(ctx, a.log)
+	opts := &share.LinkOptions{
+		Permission: r.PostFormValue("permission"),
+		Password:   r.PostFormValue("password"),
+	}
+	if v := r.PostFormValue("expires"); v != "" {
+		expires, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		opts.Expires = time.Unix(expires, 0)
+	}
+	if v := r.PostFormValue("max_downloads"); v != "" {
+		max, err := strconv.Atoi(v)
+		if err != nil || max < 0 {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		opts.MaxDownloads = max
+	}
+
+	// the user must be able to access the resource to share it.
+	uri := r.PostFormValue("uri")
+	meta, err := a.storageMux.Stat(ctx, authRes, uri, false)
+	if err != nil {
+		log.Warn("failed creating link", map[string]interface{}{"username": authRes.Username, "uri": uri, "err": err})
+		writeStorageError(w, err)
+		return
+	}
+	link, err := a.links.Create(authRes, uri, meta.IsCol, opts)
+	if err != nil {
+		log.Warn("failed creating link", map[string]interface{}{"username": authRes.Username, "uri": uri, "err": err})
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	log.Info("link created", map[string]interface{}{"username": authRes.Username, "uri": uri, "token": link.Token, "permission": link.Permission})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+func (a *APILinks) delete(ctx context.Context, authRes *auth.AuthResource, token string, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(ctx, a.log)
+	if err := a.links.Delete(authRes, token); err != nil {
+		log.Warn("failed deleting link", map[string]interface{}{"username": authRes.Username, "token": token, "err": err})
+		if share.IsLinkNotFoundError(err) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Info("link deleted", map[string]interface{}{"username": authRes.Username, "token": token})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeStorageError responds with the HTTP status of a storage error.
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case storage.IsNotExistError(err):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case storage.IsPermissionDeniedError(err):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case storage.IsExistError(err):
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package links
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/share"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+)
+
+// PasswordHeader is the HTTP header carrying the password of a link protected by password.
+// The password can also be sent as the password of HTTP Basic Authentication.
+const PasswordHeader = "X-Link-Password"
+
+// APIPublicLinks is the implementation of the APIProvider interface to serve the public links to anyone,
+// so it must NOT be served behind the AuthMiddleware of the auth multiplexer.
+// The resources are accessed as the owner of the link, restricted by the permission of the link.
+// The failed attempts to guess the password of a link are throttled like the failed authentications of
+// the auth multiplexer, responding with 429 (Too Many Requests) and a Retry-After header.
+//
+// It serves the following endpoints, where <path> is a path inside a link to a collection:
+//
+// 1. GET /api/<id>/<token>[/<path>] downloads a file, or responds with the metadata of a collection and its
+// children. The paths of the metadata are relative to the link. Every completed file download counts for
+// the download limit.
+//
+// 2. PUT /api/<id>/<token>/<path> uploads a file into a collection. Links with upload permission cannot
+// overwrite files.
+//
+// 3. DELETE /api/<id>/<token>/<path> removes a resource of a collection with read-write permission.
+type APIPublicLinks struct {
+	id         string
+	links      *share.LinkStore
+	authMux    *authmux.AuthMux
+	storageMux *storagemux.StorageMux
+	cfg        *config.Config
+	log        *logger.Logger
+}
+
+// NewAPIPublicLinks returns an APIPublicLinks object or an error.
+func NewAPIPublicLinks(id string, links *share.LinkStore, authMux *authmux.AuthMux, storageMux *storagemux.StorageMux, cfg *config.Config, log *logger.Logger) (*APIPublicLinks, error) {
+	return &APIPublicLinks{id: id, links: links, authMux: authMux, storageMux: storageMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the public links API.
+func (a *APIPublicLinks) GetID() string {
+	return a.id
+}
+
+// HandleRequest resolves the link and routes the request to the download, upload or remove endpoint.
+func (a *APIPublicLinks) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/"+a.id+"/"), "/", 2)
+	token := parts[0]
+	rel := "/"
+	if len(parts) == 2 {
+		rel = path.Clean("/" + parts[1])
+	}
+	if token == "" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	log := logger.FromContext(ctx, a.log)
+	password := r.Header.Get(PasswordHeader)
+	if _, p, ok := r.BasicAuth(); ok && password == "" {
+		password = p
+	}
+	throttleKey := "link:" + token
+	if err := a.authMux.CheckSecretThrottle(throttleKey, r.RemoteAddr); err != nil {
+		log.Warn("failed opening link", map[string]interface{}{"token": token, "err": err})
+		if terr, ok := err.(*auth.ThrottledError); ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(terr.RetryAfter.Seconds())+1))
+		}
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	link, err := a.links.Open(token, password)
+	if err != nil {
+		log.Warn("failed opening link", map[string]interface{}{"token": token, "err": err})
+		if share.IsInvalidLinkPasswordError(err) {
+			a.authMux.RecordSecretFailure(throttleKey, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="link"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if link.HasPassword {
+		a.authMux.ResetSecretThrottle(throttleKey)
+	}
+	if !link.IsCol && rel != "/" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	owner := linkOwner(link)
+	uri := strings.TrimSuffix(link.URI, "/") + strings.TrimSuffix(rel, "/")
+	switch r.Method {
+	case "GET", "HEAD":
+		if link.Permission == share.LinkUpload {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		a.download(ctx, owner, link, uri, rel, w, r)
+	case "PUT":
+		if link.Permission == share.LinkRead || rel == "/" {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		a.upload(ctx, owner, link, uri, w, r)
+	case "DELETE":
+		if link.Permission != share.LinkReadWrite || rel == "/" {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if err := a.storageMux.Remove(ctx, owner, uri, true); err != nil {
+			log.Warn("failed removing from link", map[string]interface{}{"token": link.Token, "uri": uri, "err": err})
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *APIPublicLinks) download(ctx context.Context, owner *auth.AuthResource, link *share.Link, uri, rel string, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(ctx, a.log)
+	meta, err := a.storageMux.Stat(ctx, owner, uri, true)
+	if err != nil {
+		log.Warn("failed stating link resource", map[string]interface{}{"token": link.Token, "uri": uri, "err": err})
+		writeStorageError(w, err)
+		return
+	}
+
+	if meta.IsCol {
+		// the storage paths of the owner are not shown.
+		meta.Id, meta.Path = rel, rel
+		for _, child := range meta.Children {
+			child.Id = path.Join(rel, path.Base(child.Path))
+			child.Path = child.Id
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+		return
+	}
+
+	w.Header().Set("Content-Type", meta.MimeType)
+	w.Header().Set("ETag", meta.ETag)
+	if r.Method == "HEAD" {
+		return
+	}
+	reader, err := a.storageMux.GetFile(ctx, owner, uri)
+	if err != nil {
+		log.Warn("failed downloading link resource", map[string]interface{}{"token": link.Token, "uri": uri, "err": err})
+		writeStorageError(w, err)
+		return
+	}
+	if c, ok := reader.(io.Closer); ok {
+		defer c.Close()
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+path.Base(meta.Path)+`"`)
+	if _, err := storage.CopyContext(ctx, w, reader); err != nil {
+		log.Warn("failed sending link resource", map[string]interface{}{"token": link.Token, "uri": uri, "err": err})
+		return
+	}
+	// only the completed downloads count for the download limit.
+	if err := a.links.RecordDownload(link.Token); err != nil {
+		log.Warn("failed recording link download", map[string]interface{}{"token": link.Token, "err": err})
+	}
+}
+
+func (a *APIPublicLinks) upload(ctx context.Context, owner *auth.AuthResource, link *share.Link, uri string, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(ctx, a.log)
+	if link.Permission == share.LinkUpload {
+		_, err := a.storageMux.Stat(ctx, owner, uri, false)
+		if err == nil {
+			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		}
+		if !storage.IsNotExistError(err) {
+			writeStorageError(w, err)
+			return
+		}
+	}
+	if err := a.storageMux.PutFile(ctx, owner, uri, r.Body, r.ContentLength); err != nil {
+		log.Warn("failed uploading to link", map[string]interface{}{"token": link.Token, "uri": uri, "err": err})
+		writeStorageError(w, err)
+		return
+	}
+	log.Info("file uploaded to link", map[string]interface{}{"token": link.Token, "uri": uri})
+	w.WriteHeader(http.StatusCreated)
+}
+
+// linkOwner returns the owner of the link restricted to the storage of the link, and to read only access
+// if the link is read only.
+func linkOwner(link *share.Link) *auth.AuthResource {
+	owner := *link.Owner
+	scope := &auth.Scope{}
+	if owner.Scope != nil {
+		*scope = *owner.Scope
+	}
+	if i := strings.Index(link.URI, "://"); i > 0 && len(scope.Schemes) == 0 {
+		scope.Schemes = []string{link.URI[:i]}
+	}
+	scope.ReadOnly = scope.ReadOnly || link.Permission == share.LinkRead
+	owner.Scope = scope
+	return &owner
+}
@@ -0,0 +1,169 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package oidc implements the APIProvider interface to log in users with the OpenID Connect
+// authorization code flow.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	authoidc "github.com/syncato/lib/auth/providers/oidc"
+	"github.com/syncato/lib/logger"
+)
+
+const (
+	stateCookie = "oidc_state"
+	nonceCookie = "oidc_nonce"
+)
+
+// APIOIDC is the implementation of the APIProvider interface to log in users against an
+// OpenID Connect issuer.
+//
+// It serves two endpoints:
+//
+// 1. /api/<id>/login redirects the user to the issuer to log in.
+//
+// 2. /api/<id>/callback is the redirect URL registered in the issuer. It exchanges the authorization
+// code for an ID token and responds with a JSON object containing the JWT authentication token
+// created by the AuthMux, like {"token": "..."}. If the user is enrolled in two-factor authentication
+// and the issuer has not verified a second factor it responds with {"second_factor_token": "..."} instead.
+type APIOIDC struct {
+	id       string
+	authOIDC *authoidc.AuthOIDC
+	authMux  *authmux.AuthMux
+	log      *logger.Logger
+}
+
+// NewAPIOIDC returns an APIOIDC object or an error.
+func NewAPIOIDC(id string, authOIDC *authoidc.AuthOIDC, authMux *authmux.AuthMux, log *logger.Logger) (*APIOIDC, error) {
+	return &APIOIDC{id: id, authOIDC: authOIDC, authMux: authMux, log: log}, nil
+}
+
+// GetID returns the ID of the OpenID Connect API.
+func (a *APIOIDC) GetID() string {
+	return a.id
+}
+
+// HandleRequest routes the request to the login or callback endpoint.
+func (a *APIOIDC) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/"+a.id)
+	switch endpoint {
+	case "/login":
+		a.login(ctx, w, r)
+	case "/callback":
+		a.callback(ctx, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+}
+
+func (a *APIOIDC) login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		a.log.Error("failed generating oidc state", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		a.log.Error("failed generating oidc nonce", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := a.authOIDC.AuthCodeURL(state, nonce)
+	if err != nil {
+		a.log.Error("failed creating oidc authorization url", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	a.setCookie(w, r, stateCookie, state, 600)
+	a.setCookie(w, r, nonceCookie, nonce, 600)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (a *APIOIDC) callback(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		a.log.Warn("oidc issuer returned an error", map[string]interface{}{"err": e, "description": q.Get("error_description")})
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	state, err := r.Cookie(stateCookie)
+	if err != nil || state.Value == "" || subtle.ConstantTimeCompare([]byte(state.Value), []byte(q.Get("state"))) != 1 {
+		a.log.Warn("oidc state does not match", nil)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	nonce, err := r.Cookie(nonceCookie)
+	if err != nil || nonce.Value == "" {
+		a.log.Warn("oidc nonce cookie not found", nil)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	authRes, err := a.authOIDC.Exchange(q.Get("code"), nonce.Value)
+	if err != nil {
+		a.log.Error("oidc code exchange failed", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	res := map[string]string{}
+	token, err := a.authMux.CreateAuthTokenFromAuthResource(authRes)
+	if auth.IsSecondFactorRequiredError(err) {
+		// the issuer has not verified a second factor but the user is enrolled locally, the
+		// code must be sent with this token to the session API.
+		res["second_factor_token"], err = a.authMux.CreateSecondFactorToken(authRes)
+	} else {
+		res["token"] = token
+	}
+	if err != nil {
+		a.log.Error("failed creating auth token", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.setCookie(w, r, stateCookie, "", -1)
+	a.setCookie(w, r, nonceCookie, "", -1)
+	a.log.Info("oidc login successful", map[string]interface{}{"username": authRes.Username, "auth_id": authRes.AuthID})
+	// the login of a user that must verify the second factor completes in the session API.
+	if token != "" {
+		a.authMux.Login(ctx, authRes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (a *APIOIDC) setCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/api/" + a.id,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
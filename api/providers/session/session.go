@@ -0,0 +1,240 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package session implements the APIProvider interface to log in and log out users with
+// short lived JWT authentication tokens and long lived refresh tokens.
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// TokenResponse is the response of the login and refresh endpoints.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`  // the JWT authentication token to be sent in the X-Auth-Key header.
+	RefreshToken string `json:"refresh_token"` // the token to obtain a new access token when it expires.
+	ExpiresIn    int    `json:"expires_in"`    // the duration in seconds of the access token.
+}
+
+// SecondFactorResponse is the response of the login endpoint when the user must verify a second factor.
+type SecondFactorResponse struct {
+	SecondFactorToken string `json:"second_factor_token"` // the token to be sent with the code to the second-factor endpoint.
+	ExpiresIn         int    `json:"expires_in"`          // the duration in seconds of the second factor token.
+}
+
+// APISession is the implementation of the APIProvider interface to manage user sessions.
+//
+// It serves the following endpoints, all of them only accept POST requests:
+//
+// 1. /api/<id>/login authenticates the user with Basic Auth or with the username, password and
+// auth_id form values and responds with a TokenResponse. If the user is enrolled in two-factor
+// authentication it responds with a SecondFactorResponse instead.
+//
+// 2. /api/<id>/second-factor verifies the code form value, a TOTP code or a recovery code, for the
+// second_factor_token form value and responds with a TokenResponse.
+//
+// 3. /api/<id>/refresh exchanges the refresh_token form value for a new TokenResponse.
+// The refresh token sent can not be used again.
+//
+// 4. /api/<id>/logout revokes the access token sent in the X-Auth-Key header and the
+// refresh_token form value if present.
+type APISession struct {
+	id      string
+	authMux *authmux.AuthMux
+	cfg     *config.Config
+	log     *logger.Logger
+}
+
+// NewAPISession returns an APISession object or an error.
+func NewAPISession(id string, authMux *authmux.AuthMux, cfg *config.Config, log *logger.Logger) (*APISession, error) {
+	return &APISession{id: id, authMux: authMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the session API.
+func (a *APISession) GetID() string {
+	return a.id
+}
+
+// HandleRequest routes the request to the login, second-factor, refresh or logout endpoint.
+func (a *APISession) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/"+a.id)
+	switch endpoint {
+	case "/login":
+		a.login(ctx, w, r)
+	case "/second-factor":
+		a.secondFactor(ctx, w, r)
+	case "/refresh":
+		a.refresh(ctx, w, r)
+	case "/logout":
+		a.logout(ctx, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+}
+
+func (a *APISession) login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		username = r.PostFormValue("username")
+		password = r.PostFormValue("password")
+	}
+	if username == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	authRes, err := a.authMux.AuthenticateFromAddr(username, password, r.PostFormValue("auth_id"), addr, nil)
+	if err != nil {
+		a.log.Warn("login failed", map[string]interface{}{"username": username, "err": err})
+		if terr, ok := err.(*auth.ThrottledError); ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(terr.RetryAfter.Seconds())+1))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		if auth.IsBackendError(err) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := a.authMux.CreateAuthTokenFromAuthResource(authRes)
+	if auth.IsSecondFactorRequiredError(err) {
+		a.askSecondFactor(w, authRes)
+		return
+	}
+	if err != nil {
+		a.log.Error("failed creating auth token", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.completeLogin(ctx, w, authRes, accessToken)
+}
+
+func (a *APISession) secondFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	secondFactorToken := r.PostFormValue("second_factor_token")
+	code := r.PostFormValue("code")
+	if secondFactorToken == "" || code == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	authRes, err := a.authMux.VerifySecondFactor(secondFactorToken, code, addr)
+	if err != nil {
+		a.log.Warn("second factor verification failed", map[string]interface{}{"err": err})
+		if terr, ok := err.(*auth.ThrottledError); ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(terr.RetryAfter.Seconds())+1))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := a.authMux.CreateAuthTokenFromAuthResource(authRes)
+	if err != nil {
+		a.log.Error("failed creating auth token", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.completeLogin(ctx, w, authRes, accessToken)
+}
+
+// askSecondFactor responds with a SecondFactorResponse for a user that must verify the second factor.
+func (a *APISession) askSecondFactor(w http.ResponseWriter, authRes *auth.AuthResource) {
+	secondFactorToken, err := a.authMux.CreateSecondFactorToken(authRes)
+	if err != nil {
+		a.log.Error("failed creating second factor token", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("login waiting for second factor", map[string]interface{}{"username": authRes.Username, "auth_id": authRes.AuthID})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(&SecondFactorResponse{SecondFactorToken: secondFactorToken, ExpiresIn: int(authmux.SecondFactorTokenTime.Seconds())})
+}
+
+// completeLogin creates the refresh token of the user, runs the login hooks and responds with the tokens.
+func (a *APISession) completeLogin(ctx context.Context, w http.ResponseWriter, authRes *auth.AuthResource, accessToken string) {
+	refreshToken, err := a.authMux.CreateRefreshToken(authRes)
+	if err != nil {
+		a.log.Error("failed creating refresh token", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	a.log.Info("login successful", map[string]interface{}{"username": authRes.Username, "auth_id": authRes.AuthID})
+	a.authMux.Login(ctx, authRes)
+	a.writeTokens(w, accessToken, refreshToken)
+}
+
+func (a *APISession) refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	accessToken, newRefreshToken, err := a.authMux.RefreshAuthToken(refreshToken)
+	if err != nil {
+		a.log.Warn("refresh failed", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	a.writeTokens(w, accessToken, newRefreshToken)
+}
+
+func (a *APISession) logout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	accessToken := r.Header.Get("X-Auth-Key")
+	if accessToken == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err := a.authMux.RevokeAuthToken(accessToken); err != nil {
+		a.log.Warn("logout failed", map[string]interface{}{"err": err})
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if refreshToken := r.PostFormValue("refresh_token"); refreshToken != "" {
+		if err := a.authMux.RevokeRefreshToken(refreshToken); err != nil {
+			a.log.Warn("failed revoking refresh token on logout", map[string]interface{}{"err": err})
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *APISession) writeTokens(w http.ResponseWriter, accessToken, refreshToken string) {
+	res := &TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    a.cfg.TokenExpirationTime(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(res)
+}
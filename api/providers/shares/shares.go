@@ -0,0 +1,136 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package shares implements the APIProvider interface to let users share their resources with other users.
+package shares
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/share"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+)
+
+// APIShares is the implementation of the APIProvider interface to manage the shares of the authenticated user.
+// It must be served behind the AuthMiddleware of the auth multiplexer.
+//
+// It serves the following endpoints:
+//
+// 1. GET /api/<id>/ lists the shares created by the user.
+//
+// 2. GET /api/<id>/received lists the shares with the user. The resources are accessed through the storage
+// multiplexer with the URIs shared://<share id>/<path>.
+//
+// 3. POST /api/<id>/ shares the resource of the uri form value, like local://photos, with the user or group
+// of the recipient and recipient_type (user or group) form values. The recipient belongs to the auth provider
+// of the recipient_auth_id form value, by default the auth provider of the user. The permissions are given by
+// the write and delete form values (true or false), read is always granted. It responds with the share.Share.
+//
+// 4. DELETE /api/<id>/<share id> removes a share.
+type APIShares struct {
+	id         string
+	shares     *share.ShareStore
+	storageMux *storagemux.StorageMux
+	cfg        *config.Config
+	log        *logger.Logger
+}
+
+// NewAPIShares returns an APIShares object or an error.
+func NewAPIShares(id string, shares *share.ShareStore, storageMux *storagemux.StorageMux, cfg *config.Config, log *logger.Logger) (*APIShares, error) {
+	return &APIShares{id: id, shares: shares, storageMux: storageMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the shares API.
+func (a *APIShares) GetID() string {
+	return a.id
+}
+
+// HandleRequest routes the request to the list, received, create or delete endpoint.
+func (a *APIShares) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	authRes, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	sid := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"+a.id), "/")
+	switch {
+	case r.Method == "GET" && sid == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.shares.List(authRes))
+	case r.Method == "GET" && sid == "received":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.shares.Received(authRes))
+	case r.Method == "POST" && sid == "":
+		a.create(ctx, authRes, w, r)
+	case r.Method == "DELETE" && sid != "":
+		a.delete(ctx, authRes, sid, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *APIShares) create(ctx context.Context, authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(ctx, a.log)
+	uri := r.PostFormValue("uri")
+	recipientType := r.PostFormValue("recipient_type")
+	if recipientType == "" {
+		recipientType = share.RecipientUser
+	}
+	recipient := r.PostFormValue("recipient")
+	recipientAuthID := r.PostFormValue("recipient_auth_id")
+	perm := &storage.Permissions{
+		Read:   true,
+		Write:  r.PostFormValue("write") == "true",
+		Delete: r.PostFormValue("delete") == "true",
+	}
+
+	// the user must be able to access the resource to share it.
+	meta, err := a.storageMux.Stat(ctx, authRes, uri, false)
+	if err != nil {
+		log.Warn("failed creating share", map[string]interface{}{"username": authRes.Username, "uri": uri, "err": err})
+		switch {
+		case storage.IsNotExistError(err):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case storage.IsPermissionDeniedError(err):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		default:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	sh, err := a.shares.Create(authRes, uri, meta.IsCol, recipientType, recipient, recipientAuthID, perm)
+	if err != nil {
+		log.Warn("failed creating share", map[string]interface{}{"username": authRes.Username, "uri": uri, "err": err})
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	log.Info("share created", map[string]interface{}{"username": authRes.Username, "uri": uri, "id": sh.ID, "recipient": recipient, "recipient_type": recipientType})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sh)
+}
+
+func (a *APIShares) delete(ctx context.Context, authRes *auth.AuthResource, sid string, w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(ctx, a.log)
+	if err := a.shares.Delete(authRes, sid); err != nil {
+		log.Warn("failed deleting share", map[string]interface{}{"username": authRes.Username, "id": sid, "err": err})
+		if share.IsShareNotFoundError(err) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Info("share deleted", map[string]interface{}{"username": authRes.Username, "id": sid})
+	w.WriteHeader(http.StatusNoContent)
+}
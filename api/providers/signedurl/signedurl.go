@@ -0,0 +1,79 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package signedurl implements the APIProvider interface to create signed URLs for download links and previews.
+package signedurl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// SignedURLResponse is the response of the signed URL endpoint.
+type SignedURLResponse struct {
+	URL       string `json:"url"`        // the signed URL.
+	ExpiresIn int    `json:"expires_in"` // the duration in seconds of the signed URL.
+}
+
+// APISignedURL is the implementation of the APIProvider interface to create signed URLs.
+// It must be served behind the AuthMiddleware of the auth multiplexer.
+//
+// POST /api/<id>/ signs the URL of the url form value for the operation of the op form value, download
+// (the default) or upload, and responds with a SignedURLResponse. See AuthMux.SignURL.
+type APISignedURL struct {
+	id      string
+	authMux *authmux.AuthMux
+	cfg     *config.Config
+	log     *logger.Logger
+}
+
+// NewAPISignedURL returns an APISignedURL object or an error.
+func NewAPISignedURL(id string, authMux *authmux.AuthMux, cfg *config.Config, log *logger.Logger) (*APISignedURL, error) {
+	return &APISignedURL{id: id, authMux: authMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the signed URL API.
+func (a *APISignedURL) GetID() string {
+	return a.id
+}
+
+// HandleRequest creates the signed URL.
+func (a *APISignedURL) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	authRes, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	rawURL := r.PostFormValue("url")
+	if rawURL == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	op := r.PostFormValue("op")
+	if op == "" {
+		op = authmux.SignedURLDownload
+	}
+
+	log := logger.FromContext(ctx, a.log)
+	signedURL, err := a.authMux.SignURL(authRes, rawURL, op)
+	if err != nil {
+		log.Warn("failed signing url", map[string]interface{}{"username": authRes.Username, "url": rawURL, "op": op, "err": err})
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(&SignedURLResponse{URL: signedURL, ExpiresIn: a.cfg.TokenSignedURLExpirationTime()})
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package totp implements the APIProvider interface to let users enroll in two-factor authentication with TOTP.
+package totp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	authmux "github.com/syncato/lib/auth/mux"
+	authtotp "github.com/syncato/lib/auth/totp"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// APITOTP is the implementation of the APIProvider interface to manage the second factor of the
+// authenticated user. It must be served behind the AuthMiddleware of the auth multiplexer.
+//
+// It serves the following endpoints, all of them only accept POST requests:
+//
+// 1. /api/<id>/enroll generates a new secret and responds with a totp.Enrollment. The secret
+// is not used until it is confirmed.
+//
+// 2. /api/<id>/confirm activates the secret with the code form value generated by the device of the user.
+//
+// 3. /api/<id>/disable removes the second factor, the code form value must be a valid code.
+//
+// Requests authenticated with an app password are rejected.
+type APITOTP struct {
+	id      string
+	authMux *authmux.AuthMux
+	cfg     *config.Config
+	log     *logger.Logger
+}
+
+// NewAPITOTP returns an APITOTP object or an error.
+func NewAPITOTP(id string, authMux *authmux.AuthMux, cfg *config.Config, log *logger.Logger) (*APITOTP, error) {
+	return &APITOTP{id: id, authMux: authMux, cfg: cfg, log: log}, nil
+}
+
+// GetID returns the ID of the TOTP API.
+func (a *APITOTP) GetID() string {
+	return a.id
+}
+
+// HandleRequest routes the request to the enroll, confirm or disable endpoint.
+func (a *APITOTP) HandleRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	authRes, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if authRes.Scope != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/"+a.id)
+	switch endpoint {
+	case "/enroll":
+		a.enroll(authRes, w, r)
+	case "/confirm":
+		a.confirm(authRes, w, r)
+	case "/disable":
+		a.disable(authRes, w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+}
+
+func (a *APITOTP) enroll(authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	enrollment, err := a.authMux.TOTP().Enroll(authRes.Username)
+	if err != nil {
+		a.log.Error("failed enrolling user in totp", map[string]interface{}{"username": authRes.Username, "err": err})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (a *APITOTP) confirm(authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	if err := a.authMux.TOTP().Confirm(authRes.Username, r.PostFormValue("code")); err != nil {
+		a.writeError(w, authRes, err)
+		return
+	}
+	a.log.Info("user enrolled in totp", map[string]interface{}{"username": authRes.Username})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *APITOTP) disable(authRes *auth.AuthResource, w http.ResponseWriter, r *http.Request) {
+	if err := a.authMux.TOTP().Verify(authRes.Username, r.PostFormValue("code")); err != nil {
+		a.writeError(w, authRes, err)
+		return
+	}
+	if err := a.authMux.TOTP().Disable(authRes.Username); err != nil {
+		a.writeError(w, authRes, err)
+		return
+	}
+	a.log.Info("user disabled totp", map[string]interface{}{"username": authRes.Username})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *APITOTP) writeError(w http.ResponseWriter, authRes *auth.AuthResource, err error) {
+	a.log.Warn("totp operation failed", map[string]interface{}{"username": authRes.Username, "err": err})
+	if authtotp.IsInvalidCodeError(err) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package atomicfile writes the files of the daemon so they are never left half written.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to the file like ioutil.WriteFile, but writing to a temporary file in the same directory
+// first and renaming it over the file, so readers and crashes see either the old or the new content.
+// The temporary file is flushed to disk before the rename and the directory after it, so the new content
+// survives a crash once WriteFile returns.
+// The name of the temporary file starts with the name of the file.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	fd, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := fd.Name()
+	if err := fd.Chmod(perm); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(filename))
+}
+
+// syncDir flushes the directory to disk, so a rename in it survives a crash.
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestWriteFile(c *C) {
+	dir := c.MkDir()
+	filename := filepath.Join(dir, "data.json")
+	c.Assert(WriteFile(filename, []byte("old"), 0600), IsNil)
+	c.Assert(WriteFile(filename, []byte("new"), 0600), IsNil)
+	data, err := ioutil.ReadFile(filename)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "new")
+
+	// no temporary files are left.
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+
+	c.Assert(WriteFile(filepath.Join(dir, "missing", "data.json"), []byte("new"), 0600), NotNil)
+}
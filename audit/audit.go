@@ -0,0 +1,67 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package audit defines the audit events emitted by the daemon and libraries for security
+// relevant actions and the interface to record them.
+package audit
+
+import (
+	"time"
+
+	"github.com/syncato/lib/logger"
+)
+
+// The types of the audit events.
+const (
+	EventLockout = "auth.lockout" // a username or an address has been locked out after too many failed logins.
+	EventUnlock  = "auth.unlock"  // an administrator has unlocked a username or an address.
+
+	EventImpersonate         = "auth.impersonate"          // an administrator has obtained a token to impersonate a user.
+	EventImpersonatedRequest = "auth.impersonated_request" // a request has been made impersonating a user.
+)
+
+// Event represents a security relevant action.
+type Event struct {
+	Time       time.Time              `json:"time"`
+	Type       string                 `json:"type"`
+	Username   string                 `json:"username,omitempty"`    // the user the action refers to.
+	Actor      string                 `json:"actor,omitempty"`       // the administrator acting on behalf of the user, if any.
+	AuthID     string                 `json:"auth_id,omitempty"`     // the auth provider of the user.
+	RemoteAddr string                 `json:"remote_addr,omitempty"` // the address the action comes from.
+	Fields     map[string]interface{} `json:"fields,omitempty"`      // extra information about the action.
+}
+
+// Auditor is the interface that audit backends must implement to record audit events.
+type Auditor interface {
+	Audit(e *Event)
+}
+
+// LogAuditor is the implementation of the Auditor interface that records the audit events in the log.
+type LogAuditor struct {
+	log *logger.Logger
+}
+
+// NewLogAuditor returns a LogAuditor object.
+func NewLogAuditor(log *logger.Logger) *LogAuditor {
+	return &LogAuditor{log}
+}
+
+// Audit writes the audit event to the log with warning level.
+func (a *LogAuditor) Audit(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	fields := map[string]interface{}{
+		"audit":       e.Type,
+		"audit_time":  e.Time.Unix(),
+		"username":    e.Username,
+		"actor":       e.Actor,
+		"auth_id":     e.AuthID,
+		"remote_addr": e.RemoteAddr,
+	}
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	a.log.Warn("audit event "+e.Type, fields)
+}
@@ -7,7 +7,11 @@
 package auth
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // AuthProvider is the interface that all the authentication providers must implement
@@ -19,15 +23,166 @@ type AuthProvider interface {
 	Authenticate(username, password string, extra interface{}) (*AuthResource, error)
 }
 
+// UserLookupProvider is the interface that authentication providers able to return the details of a user
+// without its credentials can implement. It is used to impersonate users.
+type UserLookupProvider interface {
+	LookupUser(username string) (*AuthResource, error)
+}
+
+// TokenAuthProvider is the interface that authentication providers must implement to authenticate
+// bearer tokens issued by a third party, like an OpenID Connect issuer.
+// A token authentication provider is defined by an ID.
+type TokenAuthProvider interface {
+	GetID() string
+	AuthenticateToken(token string) (*AuthResource, error)
+}
+
+// CertAuthProvider is the interface that authentication providers must implement to authenticate
+// TLS client certificates. The chain contains the certificates presented by the client, the leaf first.
+// A certificate authentication provider is defined by an ID.
+type CertAuthProvider interface {
+	GetID() string
+	AuthenticateCert(chain []*x509.Certificate) (*AuthResource, error)
+}
+
 // AuthResource represents the details of an authenticated user.
 type AuthResource struct {
 	Username    string      `json:"username"`     // the ID for the user.
 	DisplayName string      `json:"display_name"` // the user-friendly name.
 	Email       string      `json:"email"`        // the email of the user.
 	AuthID      string      `json:"auth_id"`      // the ID of the authentication provider who authenticated this user.
+	Groups      []string    `json:"groups"`       // the groups the user belongs to.
+	Roles       []string    `json:"roles"`        // the roles granted to the user by the authentication provider.
+	Scope       *Scope      `json:"scope"`        // if not nil, the restrictions of the credentials used, like an app password.
+	AMR         []string    `json:"amr"`          // the authentication methods used, like pwd and otp.
+	Actor       string      `json:"actor"`        // if not empty, the administrator impersonating the user.
 	Extra       interface{} `json:"extra"`
 }
 
+// Scope restricts what a user authenticated with limited credentials, like an app password, can do.
+type Scope struct {
+	ReadOnly bool     `json:"read_only"` // if true, the user cannot modify any resource.
+	Schemes  []string `json:"schemes"`   // if not empty, the only storages the user can access.
+
+	// If not empty, the user has been authenticated with a signed URL and can only do the operation
+	// SignedURLOp on the path SignedURLPath. These credentials cannot create other credentials or grant access
+	// to other users, like signing URLs or creating links and shares.
+	// The path is checked against the URL of the request when it is authenticated, the storage multiplexer
+	// only prevents removing, renaming and locking resources with these credentials.
+	SignedURLPath string `json:"signed_url_path"`
+	SignedURLOp   string `json:"signed_url_op"`
+}
+
+// AllowsScheme checks if the storage with the scheme can be accessed with the scope.
+// A nil scope allows everything.
+func (s *Scope) AllowsScheme(scheme string) bool {
+	if s == nil || len(s.Schemes) == 0 {
+		return true
+	}
+	for _, sc := range s.Schemes {
+		if sc == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsWrite checks if resources can be modified with the scope.
+// A nil scope allows everything.
+func (s *Scope) AllowsWrite() bool {
+	return s == nil || !s.ReadOnly
+}
+
+// IsSignedURL checks if the scope is the scope of the credentials of a signed URL.
+func (s *Scope) IsSignedURL() bool {
+	return s != nil && s.SignedURLPath != ""
+}
+
+// Authentication methods of the amr claim (RFC 8176).
+const (
+	AuthMethodPassword = "pwd" // the user has authenticated with a password.
+	AuthMethodOTP      = "otp" // the user has verified a one-time password as second factor.
+	AuthMethodMFA      = "mfa" // the user has verified several factors, like an OpenID Connect issuer can claim.
+)
+
+// HasAuthMethod checks if the user has been authenticated with the authentication method.
+func (a *AuthResource) HasAuthMethod(method string) bool {
+	for _, m := range a.AMR {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// HasGroup checks if the user belongs to the group.
+func (a *AuthResource) HasGroup(group string) bool {
+	for _, g := range a.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole checks if the role has been granted to the user by the authentication provider.
+func (a *AuthResource) HasRole(role string) bool {
+	for _, r := range a.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns the user without the restrictions of the credentials used to authenticate it, like the scope
+// or the impersonation actor, to act as the user itself in the operations not requested with the credentials,
+// like the provisioning of its home directories or the access to its public links.
+func (a *AuthResource) Identity() *AuthResource {
+	return &AuthResource{
+		Username:    a.Username,
+		DisplayName: a.DisplayName,
+		Email:       a.Email,
+		AuthID:      a.AuthID,
+		Groups:      a.Groups,
+		Roles:       a.Roles,
+	}
+}
+
+// ClaimStrings returns the strings of a list claim decoded from a JSON token.
+// Values that are not strings are ignored and a claim with a single string is a list of one element.
+func ClaimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		strs := []string{}
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// contextKey is the type of the key of the AuthResource in a context, so it does not collide with keys of other packages.
+type contextKey struct{}
+
+// NewContext returns a context carrying the authenticated user.
+func NewContext(ctx context.Context, authRes *AuthResource) context.Context {
+	return context.WithValue(ctx, contextKey{}, authRes)
+}
+
+// FromContext returns the authenticated user carried by the context, if any.
+func FromContext(ctx context.Context) (*AuthResource, bool) {
+	authRes, ok := ctx.Value(contextKey{}).(*AuthResource)
+	return authRes, ok && authRes != nil
+}
+
 // UserNotFoundError represents a missing user in the authentication provider.
 type UserNotFoundError struct {
 	Username string
@@ -37,3 +192,142 @@ type UserNotFoundError struct {
 func (e *UserNotFoundError) Error() string {
 	return fmt.Sprintf("user: %s not found in auth provider: %s", e.Username, e.AuthID)
 }
+
+// SecondFactorRequiredError is returned when the user has authenticated with a password but
+// must also verify a second factor.
+type SecondFactorRequiredError struct {
+	Username string
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return fmt.Sprintf("user: %s must verify a second factor", e.Username)
+}
+
+// IsSecondFactorRequiredError checks if the error is a SecondFactorRequiredError.
+func IsSecondFactorRequiredError(err error) bool {
+	_, ok := err.(*SecondFactorRequiredError)
+	return ok
+}
+
+// ImpersonationNotAllowedError is returned when a user is not allowed to impersonate another user.
+type ImpersonationNotAllowedError struct {
+	Actor    string
+	Username string
+	Reason   string
+}
+
+func (e *ImpersonationNotAllowedError) Error() string {
+	return fmt.Sprintf("user: %s cannot impersonate user: %s because %s", e.Actor, e.Username, e.Reason)
+}
+
+// IsImpersonationNotAllowedError checks if the error is an ImpersonationNotAllowedError.
+func IsImpersonationNotAllowedError(err error) bool {
+	_, ok := err.(*ImpersonationNotAllowedError)
+	return ok
+}
+
+// InvalidPasswordError represents a user found in the authentication provider with a wrong password.
+// This is a definitive failure: the user exists in the provider but the credentials are not valid.
+type InvalidPasswordError struct {
+	Username string
+	AuthID   string
+}
+
+func (e *InvalidPasswordError) Error() string {
+	return fmt.Sprintf("invalid password for user: %s in auth provider: %s", e.Username, e.AuthID)
+}
+
+// BackendError represents an authentication provider that could not check the credentials because
+// its backend is not available, like a missing file or an unreachable server.
+type BackendError struct {
+	AuthID string
+	Err    error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("auth provider: %s failed: %s", e.AuthID, e.Err)
+}
+
+// AuthChainError aggregates the errors of all the authentication providers tried to authenticate a user.
+type AuthChainError struct {
+	Username string
+	Errors   []error
+}
+
+func (e *AuthChainError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("no auth provider available for user: %s", e.Username)
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("authentication failed for user: %s: %s", e.Username, strings.Join(msgs, "; "))
+}
+
+// IsUserNotFoundError checks if the error is a UserNotFoundError or an AuthChainError where the user has
+// not been found in any of the authentication providers.
+func IsUserNotFoundError(err error) bool {
+	if e, ok := err.(*AuthChainError); ok {
+		for _, err := range e.Errors {
+			if !IsUserNotFoundError(err) {
+				return false
+			}
+		}
+		return true
+	}
+	_, ok := err.(*UserNotFoundError)
+	return ok
+}
+
+// IsInvalidPasswordError checks if the error is an InvalidPasswordError or an AuthChainError where an
+// authentication provider found the user but rejected the password.
+func IsInvalidPasswordError(err error) bool {
+	if e, ok := err.(*AuthChainError); ok {
+		for _, err := range e.Errors {
+			if IsInvalidPasswordError(err) {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := err.(*InvalidPasswordError)
+	return ok
+}
+
+// IsBackendError checks if the error is a BackendError or an AuthChainError where an authentication
+// provider failed and no other provider rejected the password.
+// In this case the user might have been authenticated if the backend was available.
+func IsBackendError(err error) bool {
+	if e, ok := err.(*AuthChainError); ok {
+		if IsInvalidPasswordError(e) {
+			return false
+		}
+		for _, err := range e.Errors {
+			if IsBackendError(err) {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := err.(*BackendError)
+	return ok
+}
+
+// ThrottledError is returned when a username or an address must wait before trying to authenticate
+// again because of too many failed attempts.
+type ThrottledError struct {
+	Username   string
+	RemoteAddr string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed authentication attempts for user: %s from: %s, retry after %s", e.Username, e.RemoteAddr, e.RetryAfter)
+}
+
+// IsThrottledError checks if the error is a ThrottledError.
+func IsThrottledError(err error) bool {
+	_, ok := err.(*ThrottledError)
+	return ok
+}
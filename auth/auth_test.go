@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestContext(c *C) {
+	_, ok := FromContext(context.Background())
+	c.Assert(ok, Equals, false)
+	_, ok = FromContext(NewContext(context.Background(), nil))
+	c.Assert(ok, Equals, false)
+
+	alice := &AuthResource{AuthID: "json", Username: "alice"}
+	authRes, ok := FromContext(NewContext(context.Background(), alice))
+	c.Assert(ok, Equals, true)
+	c.Assert(authRes, Equals, alice)
+}
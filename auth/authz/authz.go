@@ -0,0 +1,125 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package authz defines the authorizer that decides what authenticated users are allowed to do
+// based on their groups and roles and the policies of the configuration.
+package authz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// RoleAdmin is the role required by the administration APIs.
+const RoleAdmin = "admin"
+
+// Authorizer checks the authorization policies of the configuration:
+//
+// 1. AuthzRoleGroups grants roles to the users in some groups.
+//
+// 2. AuthzAPIRoles requires one of some roles to use an API.
+//
+// 3. AuthzSchemeGroups requires one of some groups to access a storage.
+//
+// The policies are read from the configuration on every check, so changes apply without restarting the daemon.
+type Authorizer struct {
+	cfg *config.Config
+	log *logger.Logger
+}
+
+// NewAuthorizer returns an Authorizer object or an error.
+func NewAuthorizer(cfg *config.Config, log *logger.Logger) (*Authorizer, error) {
+	return &Authorizer{cfg: cfg, log: log}, nil
+}
+
+// Roles returns the roles of the user: the roles granted by the auth provider plus the roles
+// granted to the groups of the user.
+func (a *Authorizer) Roles(authRes *auth.AuthResource) []string {
+	roles := append([]string{}, authRes.Roles...)
+	for role, groups := range a.cfg.AuthzRoleGroups() {
+		if !authRes.HasRole(role) && hasAnyGroup(authRes, groups) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// HasRole checks if the user has the role, granted by the auth provider or by one of the groups of the user.
+func (a *Authorizer) HasRole(authRes *auth.AuthResource, role string) bool {
+	if authRes.HasRole(role) {
+		return true
+	}
+	return hasAnyGroup(authRes, a.cfg.AuthzRoleGroups()[role])
+}
+
+// CanAccessScheme checks if the user can access the storage with the scheme.
+func (a *Authorizer) CanAccessScheme(authRes *auth.AuthResource, scheme string) bool {
+	groups, ok := a.cfg.AuthzSchemeGroups()[scheme]
+	if !ok {
+		return true
+	}
+	return hasAnyGroup(authRes, groups)
+}
+
+// CanAccessAPI checks if the user can use the API with the ID.
+func (a *Authorizer) CanAccessAPI(authRes *auth.AuthResource, apiID string) bool {
+	roles, ok := a.cfg.AuthzAPIRoles()[apiID]
+	if !ok {
+		return true
+	}
+	for _, role := range roles {
+		if a.HasRole(authRes, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresRole checks if the API with the ID can only be used by the users with some roles.
+func (a *Authorizer) RequiresRole(apiID string) bool {
+	_, ok := a.cfg.AuthzAPIRoles()[apiID]
+	return ok
+}
+
+// Middleware is an HTTP middleware to be chained after the AuthMiddleware of the auth multiplexer.
+// It returns 401 (Unauthorized) if the request has not been authenticated and 403 (Forbidden) if the user
+// cannot use the API of the request, else it calls the next handler.
+// The API ID is taken from request paths like /api/<id>/something.
+func (a *Authorizer) Middleware(ctx context.Context, w http.ResponseWriter, r *http.Request, next func(ctx context.Context, w http.ResponseWriter, r *http.Request)) {
+	authRes, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	apiID := getAPIID(r.URL.Path)
+	if !a.CanAccessAPI(authRes, apiID) {
+		logger.FromContext(ctx, a.log).Warn("user not allowed to use api", map[string]interface{}{"username": authRes.Username, "api": apiID})
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	next(ctx, w, r)
+}
+
+// getAPIID returns the API ID of a path like /api/files/something, or an empty string.
+func getAPIID(path string) string {
+	urlParts := strings.Split(path, "/")
+	if len(urlParts) < 3 {
+		return ""
+	}
+	return urlParts[2]
+}
+
+func hasAnyGroup(authRes *auth.AuthResource, groups []string) bool {
+	for _, group := range groups {
+		if authRes.HasGroup(group) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package authz
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	a *Authorizer
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	err := ioutil.WriteFile(cfgFile, []byte(`{
+		"authz_role_groups": {"admin": ["sysadmins"]},
+		"authz_api_roles": {"admin": ["admin"]},
+		"authz_scheme_groups": {"eos": ["physics"]}
+	}`), 0600)
+	c.Assert(err, IsNil)
+
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	s.a, err = NewAuthorizer(cfg, log)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestPolicies(c *C) {
+	alice := &auth.AuthResource{Username: "alice", Groups: []string{"sysadmins"}}
+	bob := &auth.AuthResource{Username: "bob", Groups: []string{"physics"}}
+	carol := &auth.AuthResource{Username: "carol", Roles: []string{RoleAdmin}}
+
+	c.Assert(s.a.Roles(alice), DeepEquals, []string{RoleAdmin})
+	c.Assert(s.a.CanAccessAPI(alice, "admin"), Equals, true)
+	c.Assert(s.a.CanAccessAPI(carol, "admin"), Equals, true)
+	c.Assert(s.a.CanAccessAPI(bob, "admin"), Equals, false)
+	c.Assert(s.a.CanAccessAPI(bob, "files"), Equals, true)
+	c.Assert(s.a.RequiresRole("admin"), Equals, true)
+	c.Assert(s.a.RequiresRole("files"), Equals, false)
+
+	c.Assert(s.a.CanAccessScheme(bob, "eos"), Equals, true)
+	c.Assert(s.a.CanAccessScheme(alice, "eos"), Equals, false)
+	c.Assert(s.a.CanAccessScheme(alice, "local"), Equals, true)
+}
+
+func (s *TestSuite) TestMiddleware(c *C) {
+	next := func(ctx context.Context, w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	serve := func(authRes *auth.AuthResource, path string) int {
+		ctx := context.Background()
+		if authRes != nil {
+			ctx = auth.NewContext(ctx, authRes)
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.a.Middleware(ctx, w, r, next)
+		return w.Code
+	}
+
+	bob := &auth.AuthResource{Username: "bob", Groups: []string{"physics"}}
+	c.Assert(serve(nil, "/api/files/photos"), Equals, http.StatusUnauthorized)
+	c.Assert(serve(bob, "/api/files/photos"), Equals, http.StatusOK)
+	c.Assert(serve(bob, "/api/admin/users"), Equals, http.StatusForbidden)
+}
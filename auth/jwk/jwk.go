@@ -0,0 +1,144 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package jwk defines the JSON Web Key (JWK) representation of public keys used to verify JWTs.
+// Extended documentation about JSON Web Keys can be found at https://tools.ietf.org/html/rfc7517
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Key represents a public JSON Web Key.
+type Key struct {
+	Kty string `json:"kty"`           // the key type: RSA, EC or OKP.
+	Kid string `json:"kid,omitempty"` // the key ID.
+	Use string `json:"use,omitempty"` // the intended use of the key, sig for signatures.
+	Alg string `json:"alg,omitempty"` // the algorithm the key must be used with.
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set represents a JSON Web Key Set as served by a jwks_uri endpoint.
+type Set struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Key returns the key with the given key ID or nil if it is not in the set.
+func (s *Set) Key(kid string) *Key {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// NewKey creates the JWK of a public key.
+// The public key must be an *rsa.PublicKey, an *ecdsa.PublicKey or an ed25519.PublicKey.
+func NewKey(kid, alg string, pub interface{}) (*Key, error) {
+	k := &Key{Kid: kid, Alg: alg, Use: "sig"}
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = base64.RawURLEncoding.EncodeToString(p.N.Bytes())
+		k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (p.Curve.Params().BitSize + 7) / 8
+		k.Kty = "EC"
+		k.Crv = p.Curve.Params().Name
+		k.X = base64.RawURLEncoding.EncodeToString(p.X.FillBytes(make([]byte, size)))
+		k.Y = base64.RawURLEncoding.EncodeToString(p.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = base64.RawURLEncoding.EncodeToString(p)
+	default:
+		return nil, errors.New(fmt.Sprintf("jwk: unsupported public key type %T for key '%s'", pub, kid))
+	}
+	return k, nil
+}
+
+// PublicKey returns the crypto public key represented by the JWK.
+// The returned value is an *rsa.PublicKey, an *ecdsa.PublicKey or an ed25519.PublicKey.
+func (k *Key) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New(fmt.Sprintf("jwk: invalid RSA exponent for key '%s'", k.Kid))
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New(fmt.Sprintf("jwk: unsupported curve '%s' for key '%s'", k.Crv, k.Kid))
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New(fmt.Sprintf("jwk: point is not on curve for key '%s'", k.Kid))
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.New(fmt.Sprintf("jwk: unsupported curve '%s' for key '%s'", k.Crv, k.Kid))
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New(fmt.Sprintf("jwk: invalid Ed25519 key size for key '%s'", k.Kid))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("jwk: unsupported key type '%s' for key '%s'", k.Kty, k.Kid))
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("jwk: missing key parameter")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+)
+
+// authenticateChain authenticates a user against the auth providers of the authentication chain in order.
+//
+// The first provider that authenticates the user wins. When a provider finds the user but rejects
+// the password the chain stops, unless the chain policy is continue. If no provider authenticates
+// the user an AuthChainError with the errors of all the providers tried is returned.
+func (mux *AuthMux) authenticateChain(username, password string, extra interface{}) (*auth.AuthResource, error) {
+	chainErr := &auth.AuthChainError{Username: username}
+	for _, entry := range mux.authChain() {
+		a, ok := mux.registeredAuthProviders[entry.ID]
+		if !ok {
+			mux.log.Warn("auth provider in auth chain not registered", map[string]interface{}{"auth_id": entry.ID})
+			continue
+		}
+		providerUsername, ok := mux.matchChainEntry(entry, username)
+		if !ok {
+			continue
+		}
+
+		authRes, err := a.Authenticate(providerUsername, password, extra)
+		if err == nil {
+			return authRes, nil
+		}
+		mux.log.Debug("auth provider rejected user", map[string]interface{}{"auth_id": entry.ID, "username": username, "err": err})
+		chainErr.Errors = append(chainErr.Errors, err)
+		if auth.IsInvalidPasswordError(err) && mux.cfg.AuthChainPolicy() != "continue" {
+			break
+		}
+	}
+	return nil, chainErr
+}
+
+// authChain returns the authentication chain of the configuration or, if it is not configured,
+// all the auth providers in the order they were registered.
+func (mux *AuthMux) authChain() []config.AuthChainEntry {
+	if chain := mux.cfg.AuthChain(); len(chain) > 0 {
+		return chain
+	}
+	chain := make([]config.AuthChainEntry, len(mux.authProviderOrder))
+	for i, id := range mux.authProviderOrder {
+		chain[i] = config.AuthChainEntry{ID: id}
+	}
+	return chain
+}
+
+// matchChainEntry checks if the username must be tried against the chain entry and returns the
+// username the auth provider expects, without the realm.
+func (mux *AuthMux) matchChainEntry(entry config.AuthChainEntry, username string) (string, bool) {
+	if entry.UsernamePattern != "" {
+		re, err := regexp.Compile(entry.UsernamePattern)
+		if err != nil {
+			mux.log.Error("invalid username pattern in auth chain", map[string]interface{}{"auth_id": entry.ID, "err": err})
+			return "", false
+		}
+		if !re.MatchString(username) {
+			return "", false
+		}
+	}
+	if entry.Realm != "" {
+		// realms are case insensitive, like in Kerberos principals.
+		suffix := "@" + entry.Realm
+		n := len(username) - len(suffix)
+		if n <= 0 || !strings.EqualFold(username[n:], suffix) {
+			return "", false
+		}
+		return username[:n], true
+	}
+	return username, true
+}
@@ -0,0 +1,213 @@
+package mux
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	. "gopkg.in/check.v1"
+)
+
+// fakeAuthProvider authenticates the users in its map, or fails with err if it is set.
+type fakeAuthProvider struct {
+	id    string
+	users map[string]string
+	err   error
+	tried []string
+}
+
+func (p *fakeAuthProvider) GetID() string { return p.id }
+
+func (p *fakeAuthProvider) Authenticate(username, password string, extra interface{}) (*auth.AuthResource, error) {
+	p.tried = append(p.tried, username)
+	if p.err != nil {
+		return nil, &auth.BackendError{AuthID: p.id, Err: p.err}
+	}
+	pw, ok := p.users[username]
+	if !ok {
+		return nil, &auth.UserNotFoundError{Username: username, AuthID: p.id}
+	}
+	if pw != password {
+		return nil, &auth.InvalidPasswordError{Username: username, AuthID: p.id}
+	}
+	return &auth.AuthResource{Username: username, AuthID: p.id}, nil
+}
+
+func (p *fakeAuthProvider) LookupUser(username string) (*auth.AuthResource, error) {
+	if _, ok := p.users[username]; !ok {
+		return nil, &auth.UserNotFoundError{Username: username, AuthID: p.id}
+	}
+	return &auth.AuthResource{Username: username, AuthID: p.id}, nil
+}
+
+// fakeTokenAuthProvider authenticates the tokens in its map.
+type fakeTokenAuthProvider struct {
+	id     string
+	tokens map[string]string // token => username
+}
+
+func (p *fakeTokenAuthProvider) GetID() string { return p.id }
+
+func (p *fakeTokenAuthProvider) AuthenticateToken(token string) (*auth.AuthResource, error) {
+	username, ok := p.tokens[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return &auth.AuthResource{Username: username, AuthID: p.id}, nil
+}
+
+// fakeCertAuthProvider authenticates the certificates with a common name in its map.
+type fakeCertAuthProvider struct {
+	id    string
+	names map[string]string // common name => username
+}
+
+func (p *fakeCertAuthProvider) GetID() string { return p.id }
+
+func (p *fakeCertAuthProvider) AuthenticateCert(chain []*x509.Certificate) (*auth.AuthResource, error) {
+	username, ok := p.names[chain[0].Subject.CommonName]
+	if !ok {
+		return nil, errors.New("invalid certificate")
+	}
+	return &auth.AuthResource{Username: username, AuthID: p.id}, nil
+}
+
+type ChainSuite struct {
+	cfgFile string
+	first   *fakeAuthProvider
+	second  *fakeAuthProvider
+}
+
+var _ = Suite(&ChainSuite{})
+
+func (s *ChainSuite) SetUpTest(c *C) {
+	s.cfgFile = filepath.Join(c.MkDir(), "config.json")
+	s.first = &fakeAuthProvider{id: "first", users: map[string]string{"alice": "a1", "bob": "b1"}}
+	s.second = &fakeAuthProvider{id: "second", users: map[string]string{"alice": "a2", "carol": "c2"}}
+}
+
+func (s *ChainSuite) newAuthMux(c *C, params map[string]interface{}) *AuthMux {
+	params["token_secret"] = "secret"
+	params["token_cipher_suite"] = "HS256"
+	data, err := json.Marshal(params)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(s.cfgFile, data, 0600), IsNil)
+
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(s.cfgFile, log)
+	c.Assert(err, IsNil)
+	mux, err := NewAuthMux(cfg, log)
+	c.Assert(err, IsNil)
+	c.Assert(mux.RegisterAuthProvider(s.first), IsNil)
+	c.Assert(mux.RegisterAuthProvider(s.second), IsNil)
+	return mux
+}
+
+func (s *ChainSuite) TestRegistrationOrder(c *C) {
+	mux := s.newAuthMux(c, map[string]interface{}{})
+	for i := 0; i < 10; i++ {
+		authRes, err := mux.authenticate("alice", "a1", "", nil)
+		c.Assert(err, IsNil)
+		c.Assert(authRes.AuthID, Equals, "first")
+	}
+	authRes, err := mux.authenticate("carol", "c2", "", nil)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.AuthID, Equals, "second")
+}
+
+func (s *ChainSuite) TestTokenRegistrationOrder(c *C) {
+	mux := s.newAuthMux(c, map[string]interface{}{})
+	c.Assert(mux.RegisterTokenAuthProvider(&fakeTokenAuthProvider{id: "first", tokens: map[string]string{"t1": "alice"}}), IsNil)
+	c.Assert(mux.RegisterTokenAuthProvider(&fakeTokenAuthProvider{id: "second", tokens: map[string]string{"t1": "bob", "t2": "carol"}}), IsNil)
+	for i := 0; i < 10; i++ {
+		authRes, err := mux.AuthenticateToken("t1")
+		c.Assert(err, IsNil)
+		c.Assert(authRes.AuthID, Equals, "first")
+	}
+	authRes, err := mux.AuthenticateToken("t2")
+	c.Assert(err, IsNil)
+	c.Assert(authRes.AuthID, Equals, "second")
+	_, err = mux.AuthenticateToken("t3")
+	c.Assert(err, NotNil)
+}
+
+func (s *ChainSuite) TestCertRegistrationOrder(c *C) {
+	mux := s.newAuthMux(c, map[string]interface{}{})
+	c.Assert(mux.RegisterCertAuthProvider(&fakeCertAuthProvider{id: "first", names: map[string]string{"c1": "alice"}}), IsNil)
+	c.Assert(mux.RegisterCertAuthProvider(&fakeCertAuthProvider{id: "second", names: map[string]string{"c1": "bob", "c2": "carol"}}), IsNil)
+	cert := func(name string) []*x509.Certificate {
+		return []*x509.Certificate{{Subject: pkix.Name{CommonName: name}}}
+	}
+	for i := 0; i < 10; i++ {
+		authRes, err := mux.AuthenticateCert(cert("c1"))
+		c.Assert(err, IsNil)
+		c.Assert(authRes.AuthID, Equals, "first")
+	}
+	authRes, err := mux.AuthenticateCert(cert("c2"))
+	c.Assert(err, IsNil)
+	c.Assert(authRes.AuthID, Equals, "second")
+	_, err = mux.AuthenticateCert(cert("c3"))
+	c.Assert(err, NotNil)
+}
+
+func (s *ChainSuite) TestConfiguredOrderAndPolicy(c *C) {
+	mux := s.newAuthMux(c, map[string]interface{}{
+		"auth_chain": []config.AuthChainEntry{{ID: "second"}, {ID: "first"}},
+	})
+	// the password of alice in first is rejected by second, and the chain stops.
+	_, err := mux.authenticate("alice", "a1", "", nil)
+	c.Assert(auth.IsInvalidPasswordError(err), Equals, true)
+	c.Assert(s.first.tried, HasLen, 0)
+
+	mux = s.newAuthMux(c, map[string]interface{}{
+		"auth_chain":        []config.AuthChainEntry{{ID: "second"}, {ID: "first"}},
+		"auth_chain_policy": "continue",
+	})
+	authRes, err := mux.authenticate("alice", "a1", "", nil)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.AuthID, Equals, "first")
+}
+
+func (s *ChainSuite) TestRealmAndPattern(c *C) {
+	mux := s.newAuthMux(c, map[string]interface{}{
+		"auth_chain": []config.AuthChainEntry{{ID: "first", Realm: "example.org"}, {ID: "second", UsernamePattern: "^c"}},
+	})
+	authRes, err := mux.authenticate("bob@EXAMPLE.org", "b1", "", nil)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "bob")
+
+	_, err = mux.authenticate("alice", "a2", "", nil)
+	c.Assert(auth.IsUserNotFoundError(err), Equals, true)
+	c.Assert(s.second.tried, HasLen, 0)
+
+	_, err = mux.authenticate("carol", "c2", "", nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *ChainSuite) TestTypedErrors(c *C) {
+	mux := s.newAuthMux(c, map[string]interface{}{})
+	_, err := mux.authenticate("nobody", "x", "", nil)
+	c.Assert(auth.IsUserNotFoundError(err), Equals, true)
+	c.Assert(auth.IsBackendError(err), Equals, false)
+
+	s.first.err = errors.New("connection refused")
+	_, err = mux.authenticate("bob", "b1", "", nil)
+	c.Assert(auth.IsBackendError(err), Equals, true)
+	c.Assert(auth.IsUserNotFoundError(err), Equals, false)
+	chainErr, ok := err.(*auth.AuthChainError)
+	c.Assert(ok, Equals, true)
+	c.Assert(chainErr.Errors, HasLen, 2)
+
+	// backend errors do not count as failed attempts.
+	for i := 0; i < 20; i++ {
+		_, err = mux.Authenticate("bob", "b1", "", nil)
+		c.Assert(auth.IsThrottledError(err), Equals, false)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"time"
+
+	"github.com/syncato/lib/audit"
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/authz"
+	"github.com/syncato/lib/config"
+)
+
+// Impersonate creates a JWT token to act as another user, so support staff can see what the user sees.
+//
+// The actor must have the admin role and must have authenticated with full credentials, not with an
+// app password or another impersonation token. Administrators cannot be impersonated.
+// The user is looked up in the auth provider with the id, or in the authentication chain if id is empty,
+// and the provider must implement the UserLookupProvider interface.
+//
+// The token is valid for AuthImpersonationTime seconds, it is restricted by the scope, read only if
+// scope is nil, and it records the actor in the act claim. Every impersonation is audited.
+func (mux *AuthMux) Impersonate(actor *auth.AuthResource, username, id string, scope *auth.Scope, addr string) (string, error) {
+	if actor.Scope != nil || actor.Actor != "" {
+		return "", &auth.ImpersonationNotAllowedError{Actor: actor.Username, Username: username, Reason: "the credentials of the actor are restricted"}
+	}
+	if !mux.authorizer.HasRole(actor, authz.RoleAdmin) {
+		return "", &auth.ImpersonationNotAllowedError{Actor: actor.Username, Username: username, Reason: "the actor is not an administrator"}
+	}
+
+	authRes, err := mux.lookupUser(username, id)
+	if err != nil {
+		return "", err
+	}
+	if mux.authorizer.HasRole(authRes, authz.RoleAdmin) {
+		return "", &auth.ImpersonationNotAllowedError{Actor: actor.Username, Username: username, Reason: "administrators cannot be impersonated"}
+	}
+
+	if scope == nil {
+		scope = &auth.Scope{ReadOnly: true}
+	}
+	authRes.Scope = scope
+	authRes.Actor = actor.Username
+	ttl := time.Second * time.Duration(mux.cfg.AuthImpersonationTime())
+	tokenString, err := mux.createToken(authRes, ttl, false)
+	if err != nil {
+		return "", err
+	}
+
+	mux.auditor.Audit(&audit.Event{
+		Type:       audit.EventImpersonate,
+		Username:   authRes.Username,
+		AuthID:     authRes.AuthID,
+		Actor:      actor.Username,
+		RemoteAddr: addr,
+		Fields:     map[string]interface{}{"read_only": scope.ReadOnly, "schemes": scope.Schemes, "expires": time.Now().Add(ttl).Unix()},
+	})
+	return tokenString, nil
+}
+
+// lookupUser returns the details of a user from the auth provider with the id or, if id is empty, from
+// the first auth provider of the authentication chain that knows the user.
+func (mux *AuthMux) lookupUser(username, id string) (*auth.AuthResource, error) {
+	entries := []config.AuthChainEntry{{ID: id}}
+	if id == "" {
+		entries = mux.authChain()
+	}
+
+	chainErr := &auth.AuthChainError{Username: username}
+	for _, entry := range entries {
+		a, ok := mux.registeredAuthProviders[entry.ID]
+		if !ok {
+			continue
+		}
+		lp, ok := a.(auth.UserLookupProvider)
+		if !ok {
+			continue
+		}
+		providerUsername, ok := mux.matchChainEntry(entry, username)
+		if !ok {
+			continue
+		}
+		authRes, err := lp.LookupUser(providerUsername)
+		if err == nil {
+			return authRes, nil
+		}
+		chainErr.Errors = append(chainErr.Errors, err)
+	}
+	if len(chainErr.Errors) == 0 {
+		return nil, &auth.UserNotFoundError{Username: username, AuthID: id}
+	}
+	return nil, chainErr
+}
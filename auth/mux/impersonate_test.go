@@ -0,0 +1,66 @@
+package mux
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/syncato/lib/audit"
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+// recordingAuditor keeps the audit events in memory.
+type recordingAuditor struct {
+	events []*audit.Event
+}
+
+func (a *recordingAuditor) Audit(e *audit.Event) { a.events = append(a.events, e) }
+
+// ImpersonateSuite uses the auth providers of the ChainSuite.
+type ImpersonateSuite struct {
+	chain ChainSuite
+}
+
+var _ = Suite(&ImpersonateSuite{})
+
+func (s *ImpersonateSuite) SetUpTest(c *C) {
+	s.chain.SetUpTest(c)
+}
+
+func (s *ImpersonateSuite) TestImpersonate(c *C) {
+	mux := s.chain.newAuthMux(c, map[string]interface{}{})
+	auditor := &recordingAuditor{}
+	mux.SetAuditor(auditor)
+	admin := &auth.AuthResource{Username: "root", Roles: []string{"admin"}}
+
+	_, err := mux.Impersonate(&auth.AuthResource{Username: "alice"}, "bob", "", nil, "")
+	c.Assert(auth.IsImpersonationNotAllowedError(err), Equals, true)
+	_, err = mux.Impersonate(admin, "nobody", "", nil, "")
+	c.Assert(auth.IsUserNotFoundError(err), Equals, true)
+
+	accessToken, err := mux.Impersonate(admin, "carol", "", nil, "10.0.0.1")
+	c.Assert(err, IsNil)
+	c.Assert(auditor.events, HasLen, 1)
+	c.Assert(auditor.events[0].Type, Equals, audit.EventImpersonate)
+	c.Assert(auditor.events[0].Actor, Equals, "root")
+
+	r, _ := http.NewRequest("GET", "/api/files/photos", nil)
+	r.Header.Set("X-Auth-Key", accessToken)
+	var authRes *auth.AuthResource
+	mux.AuthMiddleware(context.Background(), httptest.NewRecorder(), r, func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		authRes, _ = auth.FromContext(ctx)
+	})
+	c.Assert(authRes, NotNil)
+	c.Assert(authRes.Username, Equals, "carol")
+	c.Assert(authRes.AuthID, Equals, "second")
+	c.Assert(authRes.Actor, Equals, "root")
+	c.Assert(authRes.Scope.AllowsWrite(), Equals, false)
+	c.Assert(auditor.events, HasLen, 2)
+	c.Assert(auditor.events[1].Type, Equals, audit.EventImpersonatedRequest)
+
+	// impersonation tokens cannot be used to impersonate again.
+	_, err = mux.Impersonate(&auth.AuthResource{Username: "root", Roles: []string{"admin"}, Actor: "root"}, "bob", "", nil, "")
+	c.Assert(auth.IsImpersonationNotAllowedError(err), Equals, true)
+}
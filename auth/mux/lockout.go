@@ -0,0 +1,97 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"net"
+	"time"
+
+	"github.com/syncato/lib/audit"
+	"github.com/syncato/lib/auth"
+)
+
+// UnlockUser forgets the failed authentication attempts of a username, removing its lockout.
+// This operation must only be exposed to administrators.
+func (mux *AuthMux) UnlockUser(username string) {
+	mux.userThrottle.reset(username)
+	mux.auditor.Audit(&audit.Event{Type: audit.EventUnlock, Username: username})
+}
+
+// UnlockAddr forgets the failed authentication attempts from an address, removing its lockout.
+// This operation must only be exposed to administrators.
+func (mux *AuthMux) UnlockAddr(addr string) {
+	mux.addrThrottle.reset(addr)
+	mux.auditor.Audit(&audit.Event{Type: audit.EventUnlock, RemoteAddr: addr})
+}
+
+// CheckSecretThrottle returns a ThrottledError if the attempts to guess a secret that is not the password
+// of a user, like the password of a public link, must wait. The key identifies the secret and must not collide
+// with the usernames, and remoteAddr is the remote address of the request.
+func (mux *AuthMux) CheckSecretThrottle(key, remoteAddr string) error {
+	return mux.checkThrottle(key, hostFromAddr(remoteAddr), time.Now())
+}
+
+// RecordSecretFailure records a failed attempt to guess the secret identified by the key, see CheckSecretThrottle.
+func (mux *AuthMux) RecordSecretFailure(key, remoteAddr string) {
+	mux.recordFailure(key, hostFromAddr(remoteAddr), time.Now())
+}
+
+// ResetSecretThrottle forgets the failed attempts to guess the secret identified by the key.
+func (mux *AuthMux) ResetSecretThrottle(key string) {
+	mux.userThrottle.reset(key)
+}
+
+// checkThrottle returns a ThrottledError if the username or the address must wait before
+// trying to authenticate again.
+func (mux *AuthMux) checkThrottle(username, addr string, now time.Time) error {
+	lockout := time.Duration(mux.cfg.AuthLockoutDuration()) * time.Second
+	maxDelay := time.Duration(mux.cfg.AuthBackoffMaxDelay()) * time.Second
+
+	wait := mux.userThrottle.check(username, now, lockout, maxDelay)
+	if addr != "" {
+		if addrWait := mux.addrThrottle.check(addr, now, lockout, maxDelay); addrWait > wait {
+			wait = addrWait
+		}
+	}
+	if wait > 0 {
+		mux.log.Info("authentication attempt throttled", map[string]interface{}{"username": username, "remote_addr": addr, "retry_after": wait.String()})
+		return &auth.ThrottledError{Username: username, RemoteAddr: addr, RetryAfter: wait}
+	}
+	return nil
+}
+
+// recordFailure records a failed authentication attempt, locking out the username or the
+// address if they reach the configured thresholds.
+func (mux *AuthMux) recordFailure(username, addr string, now time.Time) {
+	lockout := time.Duration(mux.cfg.AuthLockoutDuration()) * time.Second
+
+	if mux.userThrottle.fail(username, now, mux.cfg.AuthLockoutThreshold(), lockout) {
+		mux.auditor.Audit(&audit.Event{
+			Type:       audit.EventLockout,
+			Username:   username,
+			RemoteAddr: addr,
+			Fields:     map[string]interface{}{"locked_until": now.Add(lockout).Unix()},
+		})
+	}
+	if addr == "" {
+		return
+	}
+	if mux.addrThrottle.fail(addr, now, mux.cfg.AuthLockoutAddrThreshold(), lockout) {
+		mux.auditor.Audit(&audit.Event{
+			Type:       audit.EventLockout,
+			RemoteAddr: addr,
+			Fields:     map[string]interface{}{"locked_until": now.Add(lockout).Unix()},
+		})
+	}
+}
+
+// hostFromAddr returns the host part of a remote address like 192.168.1.1:52000.
+func hostFromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
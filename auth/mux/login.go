@@ -0,0 +1,33 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"context"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/logger"
+)
+
+// LoginHook is a function called when a user logs in, like the provisioning of the user home directories.
+type LoginHook func(ctx context.Context, authRes *auth.AuthResource) error
+
+// AddLoginHook adds a hook run every time a user logs in: when the APIs logging in users call Login after
+// authenticating the user, and when a request is authenticated with credentials instead of a token created by
+// the daemon. Refreshing a token is not a login.
+// The hooks run in the order they were added. Their errors are logged but the login does not fail.
+func (mux *AuthMux) AddLoginHook(hook LoginHook) {
+	mux.loginHooks = append(mux.loginHooks, hook)
+}
+
+// Login runs the login hooks for a user that has logged in. It returns the user.
+func (mux *AuthMux) Login(ctx context.Context, authRes *auth.AuthResource) *auth.AuthResource {
+	for _, hook := range mux.loginHooks {
+		if err := hook(ctx, authRes); err != nil {
+			logger.FromContext(ctx, mux.log).Error("login hook failed", map[string]interface{}{"username": authRes.Username, "err": err})
+		}
+	}
+	return authRes
+}
@@ -0,0 +1,56 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+// LoginSuite uses the auth providers of the ChainSuite.
+type LoginSuite struct {
+	chain ChainSuite
+}
+
+var _ = Suite(&LoginSuite{})
+
+func (s *LoginSuite) SetUpTest(c *C) {
+	s.chain.SetUpTest(c)
+}
+
+func (s *LoginSuite) TestLoginHooks(c *C) {
+	mux := s.chain.newAuthMux(c, map[string]interface{}{})
+	var logins []string
+	mux.AddLoginHook(func(ctx context.Context, authRes *auth.AuthResource) error {
+		return errors.New("hook failed")
+	})
+	mux.AddLoginHook(func(ctx context.Context, authRes *auth.AuthResource) error {
+		logins = append(logins, authRes.Username)
+		return nil
+	})
+
+	r, _ := http.NewRequest("GET", "/api/files/photos", nil)
+	r.SetBasicAuth("bob", "b1")
+	authRes, err := mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+	c.Assert(logins, DeepEquals, []string{"bob"})
+
+	// creating tokens, refreshing them and the requests with the tokens created by the daemon are not logins.
+	accessToken, err := mux.CreateAuthTokenFromAuthResource(authRes)
+	c.Assert(err, IsNil)
+	refreshToken, err := mux.CreateRefreshToken(authRes)
+	c.Assert(err, IsNil)
+	_, _, err = mux.RefreshAuthToken(refreshToken)
+	c.Assert(err, IsNil)
+	r, _ = http.NewRequest("GET", "/api/files/photos", nil)
+	r.Header.Set("X-Auth-Key", accessToken)
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+	c.Assert(logins, HasLen, 1)
+
+	mux.Login(context.Background(), authRes)
+	c.Assert(logins, DeepEquals, []string{"bob", "bob"})
+}
@@ -7,26 +7,45 @@
 package mux
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/syncato/lib/audit"
 	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/authz"
+	"github.com/syncato/lib/auth/token"
+	"github.com/syncato/lib/auth/totp"
 	"github.com/syncato/lib/config"
 	"github.com/syncato/lib/logger"
-
-	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/net/context"
 )
 
 // AuthMux is the multiplexer responsible for routing authentication to an specific
 // authentication provider.
-// It keeps a map with all the authentication providers registered.
+// It keeps a map with all the authentication providers registered and the order they were registered.
 type AuthMux struct {
-	cfg                     *config.Config
-	log                     *logger.Logger
-	registeredAuthProviders map[string]auth.AuthProvider
+	cfg                          *config.Config
+	log                          *logger.Logger
+	registeredAuthProviders      map[string]auth.AuthProvider
+	authProviderOrder            []string
+	registeredTokenAuthProviders map[string]auth.TokenAuthProvider
+	tokenAuthProviderOrder       []string
+	registeredCertAuthProviders  map[string]auth.CertAuthProvider
+	certAuthProviderOrder        []string
+	keySet                       *token.KeySet
+	revocationList               *token.RevocationList
+	refreshStore                 *token.RefreshStore
+	appPasswords                 *token.AppPasswordStore
+	totpStore                    *totp.Store
+	userThrottle                 *throttle
+	addrThrottle                 *throttle
+	auditor                      audit.Auditor
+	authorizer                   *authz.Authorizer
+	loginHooks                   []LoginHook
 }
 
 // NewAuthMux creates an AuthMux object or returns an error
@@ -35,22 +54,176 @@ func NewAuthMux(cfg *config.Config, log *logger.Logger) (*AuthMux, error) {
 	m.cfg = cfg
 	m.log = log
 	m.registeredAuthProviders = make(map[string]auth.AuthProvider)
+	m.registeredTokenAuthProviders = make(map[string]auth.TokenAuthProvider)
+	m.registeredCertAuthProviders = make(map[string]auth.CertAuthProvider)
+	m.userThrottle = newThrottle()
+	m.addrThrottle = newThrottle()
+	m.auditor = audit.NewLogAuditor(log)
+
+	authorizer, err := authz.NewAuthorizer(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	m.authorizer = authorizer
+
+	keySet, err := token.NewKeySetFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.keySet = keySet
+
+	revocationList, err := token.NewRevocationList(cfg.TokenRevocationFile())
+	if err != nil {
+		return nil, err
+	}
+	m.revocationList = revocationList
+
+	refreshStore, err := token.NewRefreshStore(cfg.TokenRefreshFile())
+	if err != nil {
+		return nil, err
+	}
+	m.refreshStore = refreshStore
+
+	appPasswords, err := token.NewAppPasswordStore(cfg.AuthAppPasswordFile())
+	if err != nil {
+		return nil, err
+	}
+	m.appPasswords = appPasswords
+
+	totpStore, err := totp.NewStore(cfg.AuthTOTPFile(), cfg.AuthTOTPIssuer())
+	if err != nil {
+		return nil, err
+	}
+	m.totpStore = totpStore
 
 	return &m, nil
 }
 
+// SetAuditor sets the auditor used to record the audit events of the authentication.
+// By default audit events are written to the log.
+func (mux *AuthMux) SetAuditor(a audit.Auditor) {
+	mux.auditor = a
+}
+
+// KeySet returns the key set used to sign and verify the JWT authentication tokens.
+// New signing keys can be added at runtime while the old keys still verify the tokens they signed.
+func (mux *AuthMux) KeySet() *token.KeySet {
+	return mux.keySet
+}
+
+// AppPasswords returns the store of the app passwords the users can use instead of their main password.
+func (mux *AuthMux) AppPasswords() *token.AppPasswordStore {
+	return mux.appPasswords
+}
+
+// TOTP returns the store of the users enrolled in two-factor authentication with TOTP.
+func (mux *AuthMux) TOTP() *totp.Store {
+	return mux.totpStore
+}
+
 // RegisterAuthProvider register an authentication providers to be used for authenticate requests.
 func (mux *AuthMux) RegisterAuthProvider(ap auth.AuthProvider) error {
 	if _, ok := mux.registeredAuthProviders[ap.GetID()]; ok {
 		return errors.New(fmt.Sprintf("auth provider '%s' already registered", ap.GetID()))
 	}
 	mux.registeredAuthProviders[ap.GetID()] = ap
+	mux.authProviderOrder = append(mux.authProviderOrder, ap.GetID())
+	return nil
+}
+
+// RegisterTokenAuthProvider register a token authentication provider to be used for authenticate requests
+// carrying a bearer token issued by a third party.
+func (mux *AuthMux) RegisterTokenAuthProvider(tap auth.TokenAuthProvider) error {
+	if _, ok := mux.registeredTokenAuthProviders[tap.GetID()]; ok {
+		return errors.New(fmt.Sprintf("token auth provider '%s' already registered", tap.GetID()))
+	}
+	mux.registeredTokenAuthProviders[tap.GetID()] = tap
+	mux.tokenAuthProviderOrder = append(mux.tokenAuthProviderOrder, tap.GetID())
 	return nil
 }
 
+// RegisterCertAuthProvider register a certificate authentication provider to be used for authenticate requests
+// made with a TLS client certificate.
+func (mux *AuthMux) RegisterCertAuthProvider(cp auth.CertAuthProvider) error {
+	if _, ok := mux.registeredCertAuthProviders[cp.GetID()]; ok {
+		return errors.New(fmt.Sprintf("cert auth provider '%s' already registered", cp.GetID()))
+	}
+	mux.registeredCertAuthProviders[cp.GetID()] = cp
+	mux.certAuthProviderOrder = append(mux.certAuthProviderOrder, cp.GetID())
+	return nil
+}
+
+// AuthenticateCert authenticates a TLS client certificate chain against the registered certificate authentication providers,
+// in the order they were registered.
+func (mux *AuthMux) AuthenticateCert(chain []*x509.Certificate) (*auth.AuthResource, error) {
+	for _, id := range mux.certAuthProviderOrder {
+		cp := mux.registeredCertAuthProviders[id]
+		authRes, err := cp.AuthenticateCert(chain)
+		if err != nil {
+			mux.log.Debug("client certificate rejected by cert auth provider", map[string]interface{}{"auth_id": cp.GetID(), "err": err})
+			continue
+		}
+		return authRes, nil
+	}
+	return nil, errors.New("client certificate not accepted by any cert auth provider")
+}
+
+// AuthenticateToken authenticates a bearer token against the registered token authentication providers,
+// in the order they were registered.
+func (mux *AuthMux) AuthenticateToken(token string) (*auth.AuthResource, error) {
+	for _, id := range mux.tokenAuthProviderOrder {
+		tap := mux.registeredTokenAuthProviders[id]
+		authRes, err := tap.AuthenticateToken(token)
+		if err != nil {
+			mux.log.Debug("bearer token rejected by token auth provider", map[string]interface{}{"auth_id": tap.GetID(), "err": err})
+			continue
+		}
+		return authRes, nil
+	}
+	return nil, errors.New("bearer token not accepted by any token auth provider")
+}
+
 // Authenticate authenticates a user with username and password credentials.
-// The id parameter is the authentication provider id.
+// The id parameter is the authentication provider id. If it is empty the user is authenticated
+// against the authentication chain, see AuthChain in the configuration.
+// Failed attempts are throttled by username, see AuthenticateFromAddr.
 func (mux *AuthMux) Authenticate(username, password, id string, extra interface{}) (*auth.AuthResource, error) {
+	return mux.AuthenticateFromAddr(username, password, id, "", extra)
+}
+
+// AuthenticateFromAddr authenticates a user with username and password credentials coming from the
+// remote address addr.
+//
+// Failed attempts are tracked by username and by address. After a failure every new attempt must
+// wait an exponential delay and when the failures reach the configured thresholds the username or
+// the address is locked out. While waiting or locked out the credentials are not checked and a
+// ThrottledError is returned.
+func (mux *AuthMux) AuthenticateFromAddr(username, password, id, addr string, extra interface{}) (*auth.AuthResource, error) {
+	now := time.Now()
+	if err := mux.checkThrottle(username, addr, now); err != nil {
+		return nil, err
+	}
+	authRes, err := mux.authenticate(username, password, id, extra)
+	if err != nil {
+		// an unavailable backend is not the fault of the user.
+		if !auth.IsBackendError(err) {
+			mux.recordFailure(username, addr, now)
+		}
+		return nil, err
+	}
+	mux.userThrottle.reset(username)
+	if authRes.Scope == nil {
+		authRes.AMR = append(authRes.AMR, auth.AuthMethodPassword)
+	}
+	return authRes, nil
+}
+
+func (mux *AuthMux) authenticate(username, password, id string, extra interface{}) (*auth.AuthResource, error) {
+	// app passwords are checked against the app password store, not against the auth providers.
+	if token.IsAppPassword(password) {
+		return mux.authenticateAppPassword(username, password)
+	}
+
 	// the authentication request has been made specifically for an authentication provider.
 	if id != "" {
 		a, ok := mux.registeredAuthProviders[id]
@@ -65,19 +238,21 @@ func (mux *AuthMux) Authenticate(username, password, id string, extra interface{
 		return nil, &auth.UserNotFoundError{username, id}
 	}
 
-	// if the auth provider with the id passed is not found we try all the auth providers.
+	// if no auth provider id is passed we try the auth providers of the authentication chain.
 	// This is needed because with Basic Auth we cannot send the auth provider ID.
-	for _, a := range mux.registeredAuthProviders {
-		if a.GetID() != id {
-			aRes, _ := a.Authenticate(username, password, extra)
-			if aRes != nil {
-				return aRes, nil
-			}
+	return mux.authenticateChain(username, password, extra)
+}
+
+// authenticateAppPassword authenticates an app password. If username is not empty the password must belong to this user.
+func (mux *AuthMux) authenticateAppPassword(username, password string) (*auth.AuthResource, error) {
+	authRes, err := mux.appPasswords.Authenticate(username, password)
+	if err != nil {
+		if token.IsInvalidAppPasswordError(err) {
+			return nil, &auth.InvalidPasswordError{Username: username, AuthID: "apppassword"}
 		}
+		return nil, &auth.BackendError{AuthID: "apppassword", Err: err}
 	}
-
-	// we couldn´t find any auth provider that authenticated this user
-	return nil, &auth.UserNotFoundError{username, "all"}
+	return authRes, nil
 }
 
 // AuthenticateRequest authenticates a HTTP request.
@@ -89,60 +264,88 @@ func (mux *AuthMux) Authenticate(username, password, id string, extra interface{
 //
 // The following mechanisms are used in the order described to authenticate the request.
 //
-// 1. JWT authentication token as query parameter in the URL. The parameter name is auth-key.
+// 1. Signed URL, the token created by SignURL in the auth-signature query parameter. It only authenticates
+// requests for the path and the operation of the URL.
+//
+// 2. JWT authentication token as query parameter in the URL. The parameter name is auth-key.
 //
-// 2. JWT authentication token in the HTTP Header called X-Auth-Key.
+// 3. JWT authentication token in the HTTP Header called X-Auth-Key.
 //
-// 3. HTTP Basic Authentication without digest (Plain Basic Auth).
+// 4. Bearer token in the HTTP Header called Authorization. App passwords are validated by the app password store
+// and any other token by the registered token auth providers.
+//
+// 5. TLS client certificate, validated by the registered cert auth providers.
+//
+// 6. HTTP Basic Authentication without digest (Plain Basic Auth). The password can be an app password.
+// Users enrolled in two-factor authentication must use an app password.
+//
+// The requests authenticated with the mechanisms 4 to 6 are logins, so the login hooks are run, see AddLoginHook.
 //
 // More authentication methods wil be used in the future like Kerberos access tokens.
 func (mux *AuthMux) AuthenticateRequest(r *http.Request) (*auth.AuthResource, error) {
-	// 1. JWT authentication token as query parameter in the URL. The parameter name is auth-key.
-	authQueryParam := r.URL.Query().Get("auth-key")
-	if authQueryParam != "" {
-		token, err := jwt.Parse(authQueryParam, func(token *jwt.Token) (key interface{}, err error) {
-			return []byte(mux.cfg.TokenSecret()), nil
-		})
+	// 1. Signed URL.
+	if signature := r.URL.Query().Get(SignedURLParam); signature != "" {
+		authRes, err := mux.authenticateSignedURL(r, signature)
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Failed parsing auth query param because: %s", err.Error()))
+			return nil, errors.New(fmt.Sprintf("failed verifying signed url because: %s", err.Error()))
 		}
-		authRes := &auth.AuthResource{}
-		authRes.Username = token.Claims["username"].(string)
-		authRes.DisplayName = token.Claims["display_name"].(string)
-		authRes.Email = token.Claims["email"].(string)
-		authRes.AuthID = token.Claims["auth_id"].(string)
-
 		return authRes, nil
 	}
 
-	// 2. JWT authentication token in the HTTP Header called X-Auth-Key.
-	authHeader := r.Header.Get("X-Auth-Key")
-	if authHeader != "" {
-		token, err := jwt.Parse(authHeader, func(token *jwt.Token) (key interface{}, err error) {
-			return []byte(mux.cfg.TokenSecret()), nil
-		})
+	// 2. JWT authentication token as query parameter in the URL. The parameter name is auth-key.
+	if authQueryParam := r.URL.Query().Get("auth-key"); authQueryParam != "" {
+		claims, err := mux.parseAuthToken(authQueryParam)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed parsing auth query param because: %s", err.Error()))
+		}
+		return claims.AuthResource(), nil
+	}
+
+	// 3. JWT authentication token in the HTTP Header called X-Auth-Key.
+	if authHeader := r.Header.Get("X-Auth-Key"); authHeader != "" {
+		claims, err := mux.parseAuthToken(authHeader)
 		if err != nil {
 			return nil, errors.New(fmt.Sprintf("failed parsing auth header because: %s", err.Error()))
 		}
-		authRes := &auth.AuthResource{}
-		authRes.Username = token.Claims["username"].(string)
-		authRes.DisplayName = token.Claims["display_name"].(string)
-		authRes.Email = token.Claims["email"].(string)
-		authRes.AuthID = token.Claims["auth_id"].(string)
-		authRes.Extra = token.Claims["extra"]
+		return claims.AuthResource(), nil
+	}
 
-		return authRes, nil
+	// 4. Bearer token in the HTTP Header called Authorization.
+	if bearer := getBearerToken(r); bearer != "" {
+		var authRes *auth.AuthResource
+		var err error
+		if token.IsAppPassword(bearer) {
+			authRes, err = mux.authenticateAppPassword("", bearer)
+		} else {
+			authRes, err = mux.AuthenticateToken(bearer)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return mux.Login(r.Context(), authRes), nil
 	}
 
-	// 3. HTTP Basic Authentication without digest (Plain Basic Auth).
+	// 5. TLS client certificate.
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && len(mux.registeredCertAuthProviders) > 0 {
+		authRes, err := mux.AuthenticateCert(r.TLS.PeerCertificates)
+		if err != nil {
+			return nil, err
+		}
+		return mux.Login(r.Context(), authRes), nil
+	}
+
+	// 6. HTTP Basic Authentication without digest (Plain Basic Auth).
 	username, password, ok := r.BasicAuth()
 	if ok {
-		authRes, err := mux.Authenticate(username, password, "", nil)
+		authRes, err := mux.AuthenticateFromAddr(username, password, "", hostFromAddr(r.RemoteAddr), nil)
 		if err != nil {
 			return nil, err
 		}
+		if mux.requiresSecondFactor(authRes) {
+			return nil, &auth.SecondFactorRequiredError{Username: authRes.Username}
+		}
 		if err == nil {
-			return authRes, nil
+			return mux.Login(r.Context(), authRes), nil
 		}
 	}
 
@@ -150,37 +353,157 @@ func (mux *AuthMux) AuthenticateRequest(r *http.Request) (*auth.AuthResource, er
 }
 
 // CreateAuthTokenFromAuthResource creates an JWT authentication token from an AuthenticationResource object.
+// The token is signed with the signing key of the key set and it is valid for TokenExpirationTime seconds.
+// Every token has a unique ID in the jti claim so it can be revoked before it expires.
+// If the user is enrolled in two-factor authentication and has not verified the second factor a
+// SecondFactorRequiredError is returned, see CreateSecondFactorToken.
+// Creating a token is not a login, the APIs logging in users must call Login, see AddLoginHook.
 // It returns the JWT token or an error.
 func (mux *AuthMux) CreateAuthTokenFromAuthResource(authRes *auth.AuthResource) (string, error) {
-	token := jwt.New(jwt.GetSigningMethod(mux.cfg.TokenCipherSuite()))
-	token.Claims["iss"] = mux.cfg.TokenISS()
-	token.Claims["exp"] = time.Now().Add(time.Minute * 480).Unix() // we need to use cfg.TokenExpirationTime
-	token.Claims["username"] = authRes.Username
-	token.Claims["display_name"] = authRes.DisplayName
-	token.Claims["email"] = authRes.Email
-	token.Claims["auth_id"] = authRes.AuthID
-
-	tokenString, err := token.SignedString([]byte(mux.cfg.TokenSecret()))
+	if mux.requiresSecondFactor(authRes) {
+		return "", &auth.SecondFactorRequiredError{Username: authRes.Username}
+	}
+	return mux.createToken(authRes, time.Second*time.Duration(mux.cfg.TokenExpirationTime()), false)
+}
+
+// createToken creates a JWT token for the user valid for ttl.
+// If mfaPending is true the token can only be used to verify the second factor.
+func (mux *AuthMux) createToken(authRes *auth.AuthResource, ttl time.Duration, mfaPending bool) (string, error) {
+	claims, err := token.NewClaims(authRes, mux.cfg.TokenISS(), mux.cfg.TokenAUD(), ttl)
 	if err != nil {
 		return "", err
 	}
-	return tokenString, nil
+	claims.MFAPending = mfaPending
+	return mux.keySet.SignClaims(claims)
+}
+
+// parseToken parses a JWT token created by createToken and validates its claims.
+// Revoked tokens are rejected.
+func (mux *AuthMux) parseToken(tokenString string) (*token.Claims, error) {
+	claims, err := mux.keySet.ParseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := claims.Validate(mux.cfg.TokenISS(), mux.cfg.TokenAUD(), time.Now()); err != nil {
+		return nil, err
+	}
+	if mux.revocationList.IsRevoked(claims.ID) {
+		return nil, &token.InvalidTokenError{Err: "token has been revoked"}
+	}
+	return claims, nil
+}
+
+// parseAuthToken is like parseToken but it rejects the tokens waiting for the second factor
+// and the tokens of signed URLs.
+func (mux *AuthMux) parseAuthToken(tokenString string) (*token.Claims, error) {
+	claims, err := mux.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.MFAPending {
+		return nil, &token.InvalidTokenError{Err: "token is waiting for the second factor"}
+	}
+	if claims.URLPath != "" {
+		return nil, &token.InvalidTokenError{Err: "token is restricted to a signed URL"}
+	}
+	return claims, nil
+}
+
+// CreateRefreshToken creates a long lived refresh token for the user that can be exchanged
+// for new JWT authentication tokens with RefreshAuthToken.
+func (mux *AuthMux) CreateRefreshToken(authRes *auth.AuthResource) (string, error) {
+	return mux.refreshStore.Create(authRes, mux.refreshTTL())
+}
+
+// RefreshAuthToken exchanges a refresh token for a new JWT authentication token and a new refresh token.
+// The refresh token passed can not be used again: if it is, all the refresh tokens obtained from
+// it are revoked because the token has been stolen.
+// It returns the new JWT token and the new refresh token or an error.
+func (mux *AuthMux) RefreshAuthToken(refreshToken string) (string, string, error) {
+	authRes, newRefreshToken, err := mux.refreshStore.Rotate(refreshToken, mux.refreshTTL())
+	if err != nil {
+		if token.IsRefreshTokenReusedError(err) {
+			mux.log.Warn("refresh token reused, revoking token family", map[string]interface{}{"err": err})
+		}
+		return "", "", err
+	}
+	tokenString, err := mux.CreateAuthTokenFromAuthResource(authRes)
+	if err != nil {
+		return "", "", err
+	}
+	return tokenString, newRefreshToken, nil
+}
+
+// RevokeAuthToken revokes a JWT authentication token created by CreateAuthTokenFromAuthResource,
+// so it is rejected by AuthenticateRequest until it expires.
+func (mux *AuthMux) RevokeAuthToken(tokenString string) error {
+	claims, err := mux.keySet.ParseClaims(tokenString)
+	if err != nil {
+		return err
+	}
+	if claims.ID == "" {
+		return &token.InvalidTokenError{Err: "token does not contain jti claim"}
+	}
+	mux.log.Info("auth token revoked", map[string]interface{}{"jti": claims.ID, "username": claims.Username})
+	return mux.revocationList.Revoke(claims.ID, claims.ExpiresAt)
+}
+
+// RevokeRefreshToken revokes a refresh token and all the refresh tokens obtained from it.
+func (mux *AuthMux) RevokeRefreshToken(refreshToken string) error {
+	return mux.refreshStore.Revoke(refreshToken)
+}
+
+func (mux *AuthMux) refreshTTL() time.Duration {
+	return time.Second * time.Duration(mux.cfg.TokenRefreshExpirationTime())
 }
 
 // AuthMiddleWare is an HTTP middleware that besides authenticating the request like the AuthenticateRequest method
 // it does the following:
 //
-// 1. Return 401 (Unauthorized) if the authentication fails.
+// 1. Return 401 (Unauthorized) if the authentication fails, or 429 (Too Many Requests) with a Retry-After
+// header if the authentication has been throttled because of too many failed attempts.
 //
 // 2. Save the AuthResource object in the request context and call the next handler if the authentication is successful.
+//
+// 3. If an administrator is impersonating the user, audit the request and save in the request context a logger
+// that adds the actor and the user to every log line, see logger.FromContext.
 func (mux *AuthMux) AuthMiddleware(ctx context.Context, w http.ResponseWriter, r *http.Request, next func(ctx context.Context, w http.ResponseWriter, r *http.Request)) {
 	authRes, err := mux.AuthenticateRequest(r)
 	if err != nil {
 		mux.log.Error("Authentication of request failed", map[string]interface{}{"err": err})
+		if terr, ok := err.(*auth.ThrottledError); ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(terr.RetryAfter.Seconds())+1))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		if auth.IsSecondFactorRequiredError(err) {
+			w.Header().Set("X-Auth-Second-Factor", "required")
+		}
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	mux.log.Info("Authentication of request successful", map[string]interface{}{"username": authRes.Username, "auth_id": authRes.AuthID})
-	ctx = context.WithValue(ctx, "authRes", authRes)
+	mux.log.Info("Authentication of request successful", map[string]interface{}{"username": authRes.Username, "auth_id": authRes.AuthID, "actor": authRes.Actor})
+	ctx = auth.NewContext(ctx, authRes)
+	if authRes.Actor != "" {
+		mux.auditor.Audit(&audit.Event{
+			Type:       audit.EventImpersonatedRequest,
+			Username:   authRes.Username,
+			AuthID:     authRes.AuthID,
+			Actor:      authRes.Actor,
+			RemoteAddr: hostFromAddr(r.RemoteAddr),
+			Fields:     map[string]interface{}{"method": r.Method, "path": r.URL.Path},
+		})
+		log := logger.FromContext(ctx, mux.log).WithFields(map[string]interface{}{"actor": authRes.Actor, "impersonated_user": authRes.Username})
+		ctx = logger.NewContext(ctx, log)
+	}
 	next(ctx, w, r)
 }
+
+// getBearerToken returns the bearer token of the Authorization header or an empty string.
+func getBearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
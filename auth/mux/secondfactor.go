@@ -0,0 +1,64 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/syncato/lib/auth"
+)
+
+// SecondFactorTokenTime is how long a user has to verify the second factor after the password.
+const SecondFactorTokenTime = 5 * time.Minute
+
+// CreateSecondFactorToken creates a short lived JWT token for a user that has authenticated with a password
+// but must verify a second factor. The token is only accepted by VerifySecondFactor, so it cannot be used
+// to authenticate requests.
+func (mux *AuthMux) CreateSecondFactorToken(authRes *auth.AuthResource) (string, error) {
+	return mux.createToken(authRes, SecondFactorTokenTime, true)
+}
+
+// VerifySecondFactor verifies the TOTP code, or a recovery code, of the user of a token created with
+// CreateSecondFactorToken. The token can only be used once.
+// Failed attempts are throttled like password failures.
+// It returns the user with the otp authentication method, ready to be passed to CreateAuthTokenFromAuthResource.
+func (mux *AuthMux) VerifySecondFactor(tokenString, code, addr string) (*auth.AuthResource, error) {
+	claims, err := mux.parseToken(tokenString)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed parsing second factor token because: %s", err.Error()))
+	}
+	if !claims.MFAPending {
+		return nil, errors.New("token is not a second factor token")
+	}
+
+	now := time.Now()
+	if err := mux.checkThrottle(claims.Username, addr, now); err != nil {
+		return nil, err
+	}
+	if err := mux.totpStore.Verify(claims.Username, code); err != nil {
+		mux.recordFailure(claims.Username, addr, now)
+		return nil, err
+	}
+	mux.userThrottle.reset(claims.Username)
+
+	if err := mux.revocationList.Revoke(claims.ID, claims.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	authRes := claims.AuthResource()
+	authRes.AMR = append(authRes.AMR, auth.AuthMethodOTP)
+	return authRes, nil
+}
+
+// requiresSecondFactor checks if the user is enrolled in two-factor authentication and has not verified it.
+// App passwords are meant for non-interactive clients so they never require a second factor.
+func (mux *AuthMux) requiresSecondFactor(authRes *auth.AuthResource) bool {
+	if authRes.Scope != nil || authRes.HasAuthMethod(auth.AuthMethodOTP) || authRes.HasAuthMethod(auth.AuthMethodMFA) {
+		return false
+	}
+	return mux.totpStore.IsEnrolled(authRes.Username)
+}
@@ -0,0 +1,75 @@
+package mux
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/totp"
+
+	. "gopkg.in/check.v1"
+)
+
+// SecondFactorSuite uses the auth providers of the ChainSuite.
+type SecondFactorSuite struct {
+	chain ChainSuite
+}
+
+var _ = Suite(&SecondFactorSuite{})
+
+func (s *SecondFactorSuite) SetUpTest(c *C) {
+	s.chain.SetUpTest(c)
+}
+
+func (s *SecondFactorSuite) TestSecondFactor(c *C) {
+	mux := s.chain.newAuthMux(c, map[string]interface{}{})
+	enrollment, err := mux.TOTP().Enroll("alice")
+	c.Assert(err, IsNil)
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	c.Assert(err, IsNil)
+	c.Assert(mux.TOTP().Confirm("alice", code), IsNil)
+
+	authRes, err := mux.Authenticate("alice", "a1", "", nil)
+	c.Assert(err, IsNil)
+	_, err = mux.CreateAuthTokenFromAuthResource(authRes)
+	c.Assert(auth.IsSecondFactorRequiredError(err), Equals, true)
+
+	// an issuer claiming several factors has verified the second factor.
+	_, err = mux.CreateAuthTokenFromAuthResource(&auth.AuthResource{Username: "alice", AuthID: "first", AMR: []string{auth.AuthMethodMFA}})
+	c.Assert(err, IsNil)
+
+	// the second factor token cannot be used to authenticate requests.
+	secondFactorToken, err := mux.CreateSecondFactorToken(authRes)
+	c.Assert(err, IsNil)
+	r, _ := http.NewRequest("GET", "/api/files/", nil)
+	r.Header.Set("X-Auth-Key", secondFactorToken)
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, NotNil)
+
+	_, err = mux.VerifySecondFactor(secondFactorToken, "000000x", "")
+	c.Assert(err, NotNil)
+	authRes, err = mux.VerifySecondFactor(secondFactorToken, enrollment.RecoveryCodes[0], "")
+	c.Assert(err, IsNil)
+	c.Assert(authRes.HasAuthMethod(auth.AuthMethodOTP), Equals, true)
+	_, err = mux.VerifySecondFactor(secondFactorToken, enrollment.RecoveryCodes[1], "")
+	c.Assert(err, NotNil)
+
+	accessToken, err := mux.CreateAuthTokenFromAuthResource(authRes)
+	c.Assert(err, IsNil)
+	r.Header.Set("X-Auth-Key", accessToken)
+	authRes, err = mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+
+	// basic auth with the main password is rejected, app passwords still work.
+	r, _ = http.NewRequest("GET", "/api/files/", nil)
+	r.SetBasicAuth("alice", "a1")
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(auth.IsSecondFactorRequiredError(err), Equals, true)
+
+	password, _, err := mux.AppPasswords().Create(authRes, "sync client", nil)
+	c.Assert(err, IsNil)
+	r.SetBasicAuth("alice", password)
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+}
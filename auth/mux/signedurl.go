@@ -0,0 +1,117 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/token"
+)
+
+// The operations allowed by a signed URL.
+const (
+	SignedURLDownload = "download" // GET and HEAD requests.
+	SignedURLUpload   = "upload"   // PUT and POST requests.
+)
+
+// SignedURLParam is the query parameter carrying the token of a signed URL.
+const SignedURLParam = "auth-signature"
+
+// SignURL returns rawURL with a short lived token that lets anyone with the URL do the operation op
+// on its path, with its query parameters, acting as the user, without granting access to any other resource
+// or operation.
+// The token is valid for TokenSignedURLExpirationTime seconds.
+// Users restricted to read only access can only sign download URLs, and the credentials of a signed URL
+// cannot sign other URLs.
+func (mux *AuthMux) SignURL(authRes *auth.AuthResource, rawURL, op string) (string, error) {
+	if op != SignedURLDownload && op != SignedURLUpload {
+		return "", errors.New(fmt.Sprintf("signed url operation '%s' is not supported", op))
+	}
+	if authRes.Scope.IsSignedURL() {
+		return "", errors.New("signed url credentials cannot sign urls")
+	}
+	if op == SignedURLUpload && !authRes.Scope.AllowsWrite() {
+		return "", errors.New("read only credentials cannot sign upload urls")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" {
+		return "", errors.New("signed url has no path")
+	}
+
+	ttl := time.Second * time.Duration(mux.cfg.TokenSignedURLExpirationTime())
+	claims, err := token.NewClaims(authRes, mux.cfg.TokenISS(), mux.cfg.TokenAUD(), ttl)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Del(SignedURLParam)
+	claims.URLPath = path.Clean(u.Path)
+	claims.URLQuery = q.Encode()
+	claims.URLOp = op
+	tokenString, err := mux.keySet.SignClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	q.Set(SignedURLParam, tokenString)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// authenticateSignedURL authenticates a request with the token of a signed URL.
+// The request must be for the path, the query parameters and the operation of the token.
+// The user is restricted by a scope limited to the path and the operation of the token, and read only for
+// downloads, so the request cannot be used to manage credentials or to grant access to other users.
+func (mux *AuthMux) authenticateSignedURL(r *http.Request, tokenString string) (*auth.AuthResource, error) {
+	claims, err := mux.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.URLPath == "" || claims.MFAPending {
+		return nil, &token.InvalidTokenError{Err: "token is not a signed url token"}
+	}
+	if path.Clean(r.URL.Path) != claims.URLPath {
+		return nil, &token.InvalidTokenError{Err: fmt.Sprintf("signed url is for path '%s'", claims.URLPath)}
+	}
+	q := r.URL.Query()
+	q.Del(SignedURLParam)
+	if q.Encode() != claims.URLQuery {
+		return nil, &token.InvalidTokenError{Err: "signed url query has been modified"}
+	}
+	if signedURLOp(r.Method) != claims.URLOp {
+		return nil, &token.InvalidTokenError{Err: fmt.Sprintf("signed url only allows %s", claims.URLOp)}
+	}
+
+	authRes := claims.AuthResource()
+	scope := &auth.Scope{}
+	if authRes.Scope != nil {
+		*scope = *authRes.Scope
+	}
+	scope.ReadOnly = scope.ReadOnly || claims.URLOp == SignedURLDownload
+	scope.SignedURLPath = claims.URLPath
+	scope.SignedURLOp = claims.URLOp
+	authRes.Scope = scope
+	return authRes, nil
+}
+
+// signedURLOp returns the signed url operation of the HTTP method, or an empty string.
+func signedURLOp(method string) string {
+	switch method {
+	case "GET", "HEAD":
+		return SignedURLDownload
+	case "PUT", "POST":
+		return SignedURLUpload
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package mux
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+// SignedURLSuite uses the auth providers of the ChainSuite.
+type SignedURLSuite struct {
+	chain ChainSuite
+}
+
+var _ = Suite(&SignedURLSuite{})
+
+func (s *SignedURLSuite) SetUpTest(c *C) {
+	s.chain.SetUpTest(c)
+}
+
+func (s *SignedURLSuite) TestSignedURL(c *C) {
+	mux := s.chain.newAuthMux(c, map[string]interface{}{})
+	alice := &auth.AuthResource{Username: "alice", AuthID: "first"}
+
+	signed, err := mux.SignURL(alice, "/api/files/local/photo.jpg", SignedURLDownload)
+	c.Assert(err, IsNil)
+
+	r, _ := http.NewRequest("GET", signed, nil)
+	authRes, err := mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+	c.Assert(authRes.Scope.AllowsWrite(), Equals, false)
+
+	r, _ = http.NewRequest("PUT", signed, nil)
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, NotNil)
+
+	u, _ := url.Parse(signed)
+	u.Path = "/api/files/local/other.jpg"
+	r, _ = http.NewRequest("GET", u.String(), nil)
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, NotNil)
+
+	// the token of a signed url is not an auth token.
+	r, _ = http.NewRequest("GET", "/api/files/local/photo.jpg", nil)
+	r.Header.Set("X-Auth-Key", u.Query().Get(SignedURLParam))
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, NotNil)
+
+	_, err = mux.SignURL(&auth.AuthResource{Username: "alice", Scope: &auth.Scope{ReadOnly: true}}, "/api/files/local/photo.jpg", SignedURLUpload)
+	c.Assert(err, NotNil)
+}
+
+func (s *SignedURLSuite) TestSignedURLQuery(c *C) {
+	mux := s.chain.newAuthMux(c, map[string]interface{}{})
+	alice := &auth.AuthResource{Username: "alice", AuthID: "first"}
+
+	signed, err := mux.SignURL(alice, "/api/files/local/photo.jpg?size=small", SignedURLDownload)
+	c.Assert(err, IsNil)
+	r, _ := http.NewRequest("GET", signed, nil)
+	_, err = mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+
+	// the query parameters cannot be changed, added or removed.
+	for _, query := range []string{"size=large", "size=small&version=1", ""} {
+		u, _ := url.Parse(signed)
+		q, _ := url.ParseQuery(query)
+		q.Set(SignedURLParam, u.Query().Get(SignedURLParam))
+		u.RawQuery = q.Encode()
+		r, _ = http.NewRequest("GET", u.String(), nil)
+		_, err = mux.AuthenticateRequest(r)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *SignedURLSuite) TestSignedURLCannotSign(c *C) {
+	mux := s.chain.newAuthMux(c, map[string]interface{}{})
+	alice := &auth.AuthResource{Username: "alice", AuthID: "first"}
+
+	signed, err := mux.SignURL(alice, "/api/signedurl/", SignedURLUpload)
+	c.Assert(err, IsNil)
+	r, _ := http.NewRequest("POST", signed, nil)
+	authRes, err := mux.AuthenticateRequest(r)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Scope.IsSignedURL(), Equals, true)
+	c.Assert(authRes.Scope.SignedURLPath, Equals, "/api/signedurl")
+	c.Assert(authRes.Scope.SignedURLOp, Equals, SignedURLUpload)
+
+	_, err = mux.SignURL(authRes, "/api/files/local/secret", SignedURLDownload)
+	c.Assert(err, NotNil)
+}
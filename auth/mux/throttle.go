@@ -0,0 +1,113 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"sync"
+	"time"
+)
+
+// attempts keeps the failed authentication attempts of a username or an address.
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// throttle tracks failed authentication attempts by key (a username or an address).
+//
+// After the first failure every new attempt must wait an exponential delay, starting
+// at one second and doubling on every failure up to maxDelay. When the failures reach
+// the threshold the key is locked out for the lockout duration. The failures of a key
+// are forgotten after a lockout duration without failures.
+type throttle struct {
+	sync.Mutex
+	entries   map[string]*attempts
+	lastPurge time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{entries: map[string]*attempts{}}
+}
+
+// check returns how long the key must wait before trying to authenticate again.
+// A zero duration means the key can try now.
+func (t *throttle) check(key string, now time.Time, lockout, maxDelay time.Duration) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	if now.Sub(e.lastFailure) > lockout && now.After(e.lockedUntil) {
+		delete(t.entries, key)
+		return 0
+	}
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+	if next := e.lastFailure.Add(backoff(e.failures, maxDelay)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt for the key.
+// It returns true if the key has been locked out because of this failure.
+func (t *throttle) fail(key string, now time.Time, threshold int, lockout time.Duration) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	t.purge(now, lockout)
+	e, ok := t.entries[key]
+	if !ok {
+		e = &attempts{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	if threshold > 0 && e.failures >= threshold && !now.Before(e.lockedUntil) {
+		e.lockedUntil = now.Add(lockout)
+		return true
+	}
+	return false
+}
+
+// reset forgets the failed attempts of the key.
+func (t *throttle) reset(key string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.entries, key)
+}
+
+// purge removes the entries without recent failures, at most once a minute.
+// It must be called with the lock held.
+func (t *throttle) purge(now time.Time, lockout time.Duration) {
+	if now.Sub(t.lastPurge) < time.Minute {
+		return
+	}
+	t.lastPurge = now
+	for key, e := range t.entries {
+		if now.Sub(e.lastFailure) > lockout && now.After(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// backoff returns the delay after the given number of failures.
+func backoff(failures int, maxDelay time.Duration) time.Duration {
+	if failures < 2 {
+		return 0
+	}
+	delay := time.Second
+	for i := 2; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
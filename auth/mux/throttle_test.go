@@ -0,0 +1,50 @@
+package mux
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ThrottleSuite struct{}
+
+var _ = Suite(&ThrottleSuite{})
+
+func (s *ThrottleSuite) TestBackoffAndLockout(c *C) {
+	t := newThrottle()
+	now := time.Unix(1000000, 0)
+	lockout := 15 * time.Minute
+	maxDelay := 4 * time.Second
+
+	c.Assert(t.check("alice", now, lockout, maxDelay), Equals, time.Duration(0))
+
+	// the first failure does not delay, then the delay doubles up to maxDelay.
+	c.Assert(t.fail("alice", now, 5, lockout), Equals, false)
+	c.Assert(t.check("alice", now, lockout, maxDelay), Equals, time.Duration(0))
+	c.Assert(t.fail("alice", now, 5, lockout), Equals, false)
+	c.Assert(t.check("alice", now, lockout, maxDelay), Equals, time.Second)
+	c.Assert(t.fail("alice", now, 5, lockout), Equals, false)
+	c.Assert(t.check("alice", now, lockout, maxDelay), Equals, 2*time.Second)
+	c.Assert(t.fail("alice", now, 5, lockout), Equals, false)
+	c.Assert(t.check("alice", now, lockout, maxDelay), Equals, 4*time.Second)
+
+	// the threshold locks the key out.
+	c.Assert(t.fail("alice", now, 5, lockout), Equals, true)
+	c.Assert(t.check("alice", now.Add(time.Minute), lockout, maxDelay), Equals, lockout-time.Minute)
+
+	// the lockout expires and the failures are forgotten.
+	c.Assert(t.check("alice", now.Add(lockout+time.Second), lockout, maxDelay), Equals, time.Duration(0))
+	c.Assert(t.check("bob", now, lockout, maxDelay), Equals, time.Duration(0))
+}
+
+func (s *ThrottleSuite) TestReset(c *C) {
+	t := newThrottle()
+	now := time.Unix(1000000, 0)
+	c.Assert(t.fail("alice", now, 1, time.Minute), Equals, true)
+	c.Assert(t.check("alice", now, time.Minute, time.Second) > 0, Equals, true)
+	t.reset("alice")
+	c.Assert(t.check("alice", now, time.Minute, time.Second), Equals, time.Duration(0))
+}
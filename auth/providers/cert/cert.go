@@ -0,0 +1,140 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package cert implements the CertAuthProvider interface to authenticate users with X.509 client certificates.
+package cert
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+)
+
+// rule is a compiled config.CertRule.
+type rule struct {
+	field    string
+	pattern  *regexp.Regexp
+	username string
+}
+
+// AuthCert is the implementation of the CertAuthProvider interface to authenticate TLS client certificates.
+//
+// Certificates must be valid for client authentication and be issued by one of the certificate
+// authorities of AuthCertCAFile. The username is obtained with the first rule of AuthCertRules
+// matching the certificate. Certificates not matching any rule are rejected.
+type AuthCert struct {
+	id    string
+	cfg   *config.Config
+	log   *logger.Logger
+	roots *x509.CertPool
+	rules []*rule
+}
+
+// NewAuthCert returns an AuthCert object or an error.
+func NewAuthCert(id string, cfg *config.Config, log *logger.Logger) (*AuthCert, error) {
+	data, err := ioutil.ReadFile(cfg.AuthCertCAFile())
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, errors.New(fmt.Sprintf("no certificates found in %s", cfg.AuthCertCAFile()))
+	}
+
+	rules := []*rule{}
+	for _, r := range cfg.AuthCertRules() {
+		switch r.Field {
+		case "cn", "dn", "email", "dns", "uri":
+		default:
+			return nil, errors.New(fmt.Sprintf("cert rule field '%s' not supported", r.Field))
+		}
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule{field: r.Field, pattern: pattern, username: r.Username})
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("no cert rules configured")
+	}
+	return &AuthCert{id: id, cfg: cfg, log: log, roots: roots, rules: rules}, nil
+}
+
+// GetID returns the ID of the cert auth provider.
+func (a *AuthCert) GetID() string {
+	return a.id
+}
+
+// AuthenticateCert validates the client certificate chain and maps the leaf certificate to a username.
+func (a *AuthCert) AuthenticateCert(chain []*x509.Certificate) (*auth.AuthResource, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+	leaf := chain[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return nil, err
+	}
+
+	for _, r := range a.rules {
+		for _, value := range fieldValues(leaf, r.field) {
+			match := r.pattern.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			username := value
+			if r.username != "" {
+				username = string(r.pattern.ExpandString(nil, r.username, value, match))
+			}
+			if username == "" {
+				continue
+			}
+			authRes := &auth.AuthResource{
+				Username:    username,
+				DisplayName: leaf.Subject.CommonName,
+				AuthID:      a.GetID(),
+			}
+			if len(leaf.EmailAddresses) > 0 {
+				authRes.Email = leaf.EmailAddresses[0]
+			}
+			return authRes, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("client certificate '%s' does not match any cert rule", leaf.Subject.String()))
+}
+
+// fieldValues returns the values of a certificate field.
+func fieldValues(c *x509.Certificate, field string) []string {
+	switch field {
+	case "cn":
+		return []string{c.Subject.CommonName}
+	case "dn":
+		return []string{c.Subject.String()}
+	case "email":
+		return c.EmailAddresses
+	case "dns":
+		return c.DNSNames
+	case "uri":
+		values := []string{}
+		for _, u := range c.URIs {
+			values = append(values, u.String())
+		}
+		return values
+	}
+	return nil
+}
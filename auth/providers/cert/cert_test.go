@@ -0,0 +1,115 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	a      *AuthCert
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	var err error
+	s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	s.caCert = s.newCert(c, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	dir := c.MkDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw}), 0600)
+	c.Assert(err, IsNil)
+
+	data, err := json.Marshal(map[string]interface{}{
+		"auth_cert_ca_file": caFile,
+		"auth_cert_rules": []config.CertRule{
+			{Field: "email", Pattern: `^(.+)@example\.org$`, Username: "$1"},
+			{Field: "cn", Pattern: `^batch-(.+)$`, Username: "svc-$1"},
+		},
+	})
+	c.Assert(err, IsNil)
+	cfgFile := filepath.Join(dir, "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	s.a, err = NewAuthCert("cert", cfg, log)
+	c.Assert(err, IsNil)
+}
+
+// newCert creates a certificate from the template signed by the CA, or self signed if parent is nil.
+func (s *TestSuite) newCert(c *C, template, parent *x509.Certificate) *x509.Certificate {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	pub := &s.caKey.PublicKey
+	if parent == nil {
+		parent = template
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		c.Assert(err, IsNil)
+		pub = &key.PublicKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, s.caKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return cert
+}
+
+func (s *TestSuite) TestAuthenticateCert(c *C) {
+	clientAuth := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	alice := s.newCert(c, &x509.Certificate{Subject: pkix.Name{CommonName: "Alice"}, EmailAddresses: []string{"alice@example.org"}, ExtKeyUsage: clientAuth}, s.caCert)
+	authRes, err := s.a.AuthenticateCert([]*x509.Certificate{alice})
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+	c.Assert(authRes.AuthID, Equals, "cert")
+
+	batch := s.newCert(c, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-reco"}, ExtKeyUsage: clientAuth}, s.caCert)
+	authRes, err = s.a.AuthenticateCert([]*x509.Certificate{batch})
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "svc-reco")
+
+	// no rule matches.
+	bob := s.newCert(c, &x509.Certificate{Subject: pkix.Name{CommonName: "Bob"}, EmailAddresses: []string{"bob@other.org"}, ExtKeyUsage: clientAuth}, s.caCert)
+	_, err = s.a.AuthenticateCert([]*x509.Certificate{bob})
+	c.Assert(err, NotNil)
+
+	// server certificates are not valid for client authentication.
+	server := s.newCert(c, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-web"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, s.caCert)
+	_, err = s.a.AuthenticateCert([]*x509.Certificate{server})
+	c.Assert(err, NotNil)
+
+	// self signed certificates are not trusted.
+	self := s.newCert(c, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-self"}, ExtKeyUsage: clientAuth}, nil)
+	_, err = s.a.AuthenticateCert([]*x509.Certificate{self})
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package htpasswd
+
+import (
+	"crypto/md5"
+)
+
+const (
+	apr1Magic = "$apr1$"
+	itoa64    = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// apr1Crypt returns the APR1-MD5 hash of the password, the variant of the MD5-based crypt(3)
+// used by Apache. The returned value has the format $apr1$salt$hash.
+func apr1Crypt(password, salt []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	alternateSum := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write([]byte(apr1Magic))
+	ctx.Write(salt)
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alternateSum)
+		} else {
+			ctx.Write(alternateSum[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	// 1000 rounds to slow down brute force attacks.
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(nil)
+	}
+
+	result := make([]byte, 0, 22)
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			result = append(result, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(uint(final[0])<<16|uint(final[6])<<8|uint(final[12]), 4)
+	encode(uint(final[1])<<16|uint(final[7])<<8|uint(final[13]), 4)
+	encode(uint(final[2])<<16|uint(final[8])<<8|uint(final[14]), 4)
+	encode(uint(final[3])<<16|uint(final[9])<<8|uint(final[15]), 4)
+	encode(uint(final[4])<<16|uint(final[10])<<8|uint(final[5]), 4)
+	encode(uint(final[11]), 2)
+
+	return apr1Magic + string(salt) + "$" + string(result)
+}
@@ -0,0 +1,263 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package htpasswd implements the AuthProvider interface to authenticate users against an Apache htpasswd file.
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthHtpasswd is the implementation of the AuthProvider interface to use an Apache htpasswd
+// file as an authentication provider.
+//
+// The supported password formats are bcrypt ($2y$, $2a$, $2b$), SHA1 ({SHA}) and APR1-MD5 ($apr1$).
+// Entries using other formats like crypt(3) are ignored.
+//
+// The groups of the users are read from the Apache htgroup file configured in AuthHtgroupFile, if any.
+//
+// The files are parsed the first time a user authenticates and they are parsed again when their
+// modification time or size changes, so they can be edited with the htpasswd tool
+// without restarting the daemon.
+type AuthHtpasswd struct {
+	id  string
+	cfg *config.Config
+	log *logger.Logger
+
+	sync.Mutex
+	usersFile  fileState
+	users      map[string]string // username => password hash
+	groupsFile fileState
+	groups     map[string][]string // username => groups
+}
+
+// fileState identifies the version of a file that has been parsed.
+type fileState struct {
+	filename string
+	modTime  time.Time
+	size     int64
+}
+
+func (f *fileState) changed(filename string, finfo os.FileInfo) bool {
+	return filename != f.filename || !finfo.ModTime().Equal(f.modTime) || finfo.Size() != f.size
+}
+
+// NewAuthHtpasswd returns an AuthHtpasswd object or an error.
+func NewAuthHtpasswd(id string, cfg *config.Config, log *logger.Logger) (*AuthHtpasswd, error) {
+	return &AuthHtpasswd{id: id, cfg: cfg, log: log, users: map[string]string{}, groups: map[string][]string{}}, nil
+}
+
+// GetID returns the ID of the htpasswd auth provider.
+func (a *AuthHtpasswd) GetID() string {
+	return a.id
+}
+
+// Authenticate authenticates a user against the htpasswd file.
+func (a *AuthHtpasswd) Authenticate(username, password string, extra interface{}) (*auth.AuthResource, error) {
+	users, err := a.getUsers()
+	if err != nil {
+		return nil, &auth.BackendError{AuthID: a.GetID(), Err: err}
+	}
+
+	hash, ok := users[username]
+	if !ok {
+		return nil, &auth.UserNotFoundError{Username: username, AuthID: a.GetID()}
+	}
+	if !checkPassword(hash, password) {
+		return nil, &auth.InvalidPasswordError{Username: username, AuthID: a.GetID()}
+	}
+	return a.newAuthResource(username)
+}
+
+// LookupUser returns the details of a user of the htpasswd file without checking its password.
+func (a *AuthHtpasswd) LookupUser(username string) (*auth.AuthResource, error) {
+	users, err := a.getUsers()
+	if err != nil {
+		return nil, &auth.BackendError{AuthID: a.GetID(), Err: err}
+	}
+	if _, ok := users[username]; !ok {
+		return nil, &auth.UserNotFoundError{Username: username, AuthID: a.GetID()}
+	}
+	return a.newAuthResource(username)
+}
+
+func (a *AuthHtpasswd) newAuthResource(username string) (*auth.AuthResource, error) {
+	groups, err := a.getGroups()
+	if err != nil {
+		return nil, &auth.BackendError{AuthID: a.GetID(), Err: err}
+	}
+
+	authRes := auth.AuthResource{
+		Username:    username,
+		DisplayName: username,
+		AuthID:      a.GetID(),
+		Groups:      groups[username],
+	}
+	return &authRes, nil
+}
+
+// getUsers returns the users in the htpasswd file, parsing the file again if it has changed since
+// the last time it was read.
+func (a *AuthHtpasswd) getUsers() (map[string]string, error) {
+	filename := a.cfg.AuthHtpasswdFile()
+	finfo, err := os.Stat(filename)
+	if err != nil {
+		a.log.Error(err.Error(), nil)
+		return nil, err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.usersFile.changed(filename, finfo) {
+		return a.users, nil
+	}
+
+	users, err := a.parseFile(filename)
+	if err != nil {
+		a.log.Error(err.Error(), nil)
+		return nil, err
+	}
+
+	a.log.Info("htpasswd file loaded", map[string]interface{}{"file": filename, "users": len(users)})
+	a.usersFile = fileState{filename, finfo.ModTime(), finfo.Size()}
+	a.users = users
+	return a.users, nil
+}
+
+// getGroups returns the groups of the users in the htgroup file, parsing the file again if it has
+// changed since the last time it was read. If no htgroup file is configured users have no groups.
+func (a *AuthHtpasswd) getGroups() (map[string][]string, error) {
+	filename := a.cfg.AuthHtgroupFile()
+	if filename == "" {
+		return map[string][]string{}, nil
+	}
+	finfo, err := os.Stat(filename)
+	if err != nil {
+		a.log.Error(err.Error(), nil)
+		return nil, err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.groupsFile.changed(filename, finfo) {
+		return a.groups, nil
+	}
+
+	groups, err := parseGroupFile(filename)
+	if err != nil {
+		a.log.Error(err.Error(), nil)
+		return nil, err
+	}
+
+	a.log.Info("htgroup file loaded", map[string]interface{}{"file": filename})
+	a.groupsFile = fileState{filename, finfo.ModTime(), finfo.Size()}
+	a.groups = groups
+	return a.groups, nil
+}
+
+// parseFile parses an htpasswd file.
+// Every line has the format username:hash. Empty lines and lines starting with # are ignored.
+func (a *AuthHtpasswd) parseFile(filename string) (map[string]string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			a.log.Warn("ignoring malformed htpasswd entry", map[string]interface{}{"file": filename})
+			continue
+		}
+		if !isSupportedHash(parts[1]) {
+			a.log.Warn("ignoring htpasswd entry with unsupported password format", map[string]interface{}{"file": filename, "username": parts[0]})
+			continue
+		}
+		users[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// parseGroupFile parses an htgroup file and returns the groups of every user.
+// Every line has the format group: user1 user2. Empty lines and lines starting with # are ignored.
+func parseGroupFile(filename string) (map[string][]string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	groups := make(map[string][]string)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		group := strings.TrimSpace(parts[0])
+		for _, username := range strings.Fields(parts[1]) {
+			groups[username] = append(groups[username], group)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func isSupportedHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") ||
+		strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "{SHA}") ||
+		strings.HasPrefix(hash, apr1Magic)
+}
+
+// checkPassword checks the password against an htpasswd hash.
+func checkPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	case strings.HasPrefix(hash, apr1Magic):
+		parts := strings.SplitN(strings.TrimPrefix(hash, apr1Magic), "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		expected := apr1Crypt([]byte(password), []byte(parts[0]))
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+}
@@ -0,0 +1,117 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	"golang.org/x/crypto/bcrypt"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir       string
+	file      string
+	groupFile string
+	a         *AuthHtpasswd
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.file = filepath.Join(s.dir, "htpasswd")
+	s.groupFile = filepath.Join(s.dir, "htgroup")
+	cfgFile := filepath.Join(s.dir, "config.json")
+	err := ioutil.WriteFile(cfgFile, []byte(`{"auth_htpasswd_file": "`+s.file+`", "auth_htgroup_file": "`+s.groupFile+`"}`), 0600)
+	c.Assert(err, IsNil)
+
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	s.a, err = NewAuthHtpasswd("htpasswd", cfg, log)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) writeFile(c *C, content string) {
+	err := ioutil.WriteFile(s.file, []byte(content), 0600)
+	c.Assert(err, IsNil)
+	if _, err := os.Stat(s.groupFile); os.IsNotExist(err) {
+		c.Assert(ioutil.WriteFile(s.groupFile, nil, 0600), IsNil)
+	}
+}
+
+var APR1Tests = []struct {
+	password string
+	salt     string
+	expected string
+}{
+	{"secret", "saltsalt", "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"},
+	{"", "ab", "$apr1$ab$S8K6Sgp3W8c9Jb6LxgywZ."},
+}
+
+func (s *TestSuite) TestAPR1Crypt(c *C) {
+	for _, t := range APR1Tests {
+		c.Assert(apr1Crypt([]byte(t.password), []byte(t.salt)), Equals, t.expected)
+	}
+}
+
+func (s *TestSuite) TestAuthenticate(c *C) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	c.Assert(err, IsNil)
+	s.writeFile(c, "# comment\n"+
+		"bob:"+string(bcryptHash)+"\n"+
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"+
+		"carol:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n"+
+		"dave:rqXexS6ZhobKA\n")
+
+	for _, username := range []string{"bob", "alice", "carol"} {
+		authRes, err := s.a.Authenticate(username, "secret", nil)
+		c.Assert(err, IsNil)
+		c.Assert(authRes.Username, Equals, username)
+		c.Assert(authRes.AuthID, Equals, "htpasswd")
+
+		_, err = s.a.Authenticate(username, "wrong", nil)
+		c.Assert(err, FitsTypeOf, &auth.InvalidPasswordError{})
+	}
+
+	_, err = s.a.Authenticate("nobody", "secret", nil)
+	c.Assert(err, FitsTypeOf, &auth.UserNotFoundError{})
+
+	// crypt(3) entries are not supported
+	_, err = s.a.Authenticate("dave", "secret", nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestReloadOnChange(c *C) {
+	s.writeFile(c, "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	_, err := s.a.Authenticate("alice", "secret", nil)
+	c.Assert(err, IsNil)
+
+	s.writeFile(c, "carol:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n")
+	future := time.Now().Add(time.Minute)
+	c.Assert(os.Chtimes(s.file, future, future), IsNil)
+
+	_, err = s.a.Authenticate("alice", "secret", nil)
+	c.Assert(err, NotNil)
+	_, err = s.a.Authenticate("carol", "secret", nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestGroups(c *C) {
+	s.writeFile(c, "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	err := ioutil.WriteFile(s.groupFile, []byte("# comment\nadmins: alice\nphysics: bob alice\n"), 0600)
+	c.Assert(err, IsNil)
+
+	authRes, err := s.a.Authenticate("alice", "secret", nil)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Groups, DeepEquals, []string{"admins", "physics"})
+}
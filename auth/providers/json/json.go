@@ -20,6 +20,8 @@ type User struct {
 	Password    string      `json:"password"`
 	DisplayName string      `json:"display_name"`
 	Email       string      `json:"email"`
+	Groups      []string    `json:"groups"`
+	Roles       []string    `json:"roles"`
 	Extra       interface{} `json:"extra"`
 }
 
@@ -45,36 +47,63 @@ func (a *AuthJSON) GetID() string {
 // Authenticate authenticates a user agains the JSON file.
 // User credentials in the JSON file are kept in plain text, so the password is not encrypted.
 func (a *AuthJSON) Authenticate(username, password string, extra interface{}) (*auth.AuthResource, error) {
-	fd, err := os.Open(a.cfg.AuthJSONFile())
-	defer fd.Close()
+	user, err := a.getUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.Password != password {
+		return nil, &auth.InvalidPasswordError{Username: username, AuthID: a.GetID()}
+	}
+	return a.newAuthResource(user), nil
+}
+
+// LookupUser returns the details of a user of the JSON file without checking its password.
+func (a *AuthJSON) LookupUser(username string) (*auth.AuthResource, error) {
+	user, err := a.getUser(username)
 	if err != nil {
 		return nil, err
 	}
+	return a.newAuthResource(user), nil
+}
+
+// getUser returns a user of the JSON file.
+func (a *AuthJSON) getUser(username string) (*User, error) {
+	fd, err := os.Open(a.cfg.AuthJSONFile())
+	if err != nil {
+		a.log.Error(err.Error(), nil)
+		return nil, &auth.BackendError{AuthID: a.GetID(), Err: err}
+	}
+	defer fd.Close()
 
 	data, err := ioutil.ReadAll(fd)
 	if err != nil {
 		a.log.Error(err.Error(), nil)
-		return nil, err
+		return nil, &auth.BackendError{AuthID: a.GetID(), Err: err}
 	}
 
 	users := make([]*User, 0)
 	err = json.Unmarshal(data, &users)
 	if err != nil {
 		a.log.Error(err.Error(), nil)
-		return nil, err
+		return nil, &auth.BackendError{AuthID: a.GetID(), Err: err}
 	}
 
 	for _, user := range users {
-		if user.Username == username && user.Password == password {
-			authRes := auth.AuthResource{
-				Username:    user.Username,
-				DisplayName: user.DisplayName,
-				Email:       user.Email,
-				AuthID:      a.GetID(),
-				Extra:       user.Extra,
-			}
-			return &authRes, nil
+		if user.Username == username {
+			return user, nil
 		}
 	}
 	return nil, &auth.UserNotFoundError{username, a.GetID()}
 }
+
+func (a *AuthJSON) newAuthResource(user *User) *auth.AuthResource {
+	return &auth.AuthResource{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+		AuthID:      a.GetID(),
+		Groups:      user.Groups,
+		Roles:       user.Roles,
+		Extra:       user.Extra,
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package oidc implements the TokenAuthProvider interface to authenticate bearer tokens
+// issued by an OpenID Connect provider.
+// Extended documentation about OpenID Connect can be found at http://openid.net/specs/openid-connect-core-1_0.html
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/jwk"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// validMethods are the signing methods accepted for tokens issued by the OpenID Connect provider.
+// Symmetric methods are never accepted because the keys are public.
+var validMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// Discovery represents the OpenID Connect discovery document of an issuer.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// TokenResponse represents the response of the token endpoint in the authorization code flow.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// AuthOIDC is the implementation of the TokenAuthProvider interface to use an OpenID Connect
+// issuer as an authentication provider.
+//
+// The issuer keys are fetched from the jwks_uri of the discovery document and cached for
+// the time configured in OIDCJWKSCacheTime. When a token is signed with an unknown key ID
+// the keys are fetched again, so key rotations in the issuer are picked up automatically.
+type AuthOIDC struct {
+	id     string
+	cfg    *config.Config
+	log    *logger.Logger
+	client *http.Client
+
+	sync.Mutex
+	discovery   *Discovery
+	keys        *jwk.Set
+	keysFetched time.Time
+	minRefetch  time.Duration // minimum time between two fetches triggered by unknown key IDs.
+}
+
+// NewAuthOIDC returns an AuthOIDC object or an error.
+func NewAuthOIDC(id string, cfg *config.Config, log *logger.Logger) (*AuthOIDC, error) {
+	if cfg.OIDCIssuer() == "" {
+		return nil, errors.New("oidc issuer not configured")
+	}
+	a := &AuthOIDC{
+		id:         id,
+		cfg:        cfg,
+		log:        log,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		minRefetch: time.Minute,
+	}
+	return a, nil
+}
+
+// GetID returns the ID of the OpenID Connect auth provider.
+func (a *AuthOIDC) GetID() string {
+	return a.id
+}
+
+// AuthenticateToken authenticates a bearer token issued by the OpenID Connect issuer.
+func (a *AuthOIDC) AuthenticateToken(token string) (*auth.AuthResource, error) {
+	return a.verify(token, "")
+}
+
+// AuthCodeURL returns the URL of the issuer where the user must be redirected to start
+// the authorization code flow.
+func (a *AuthOIDC) AuthCodeURL(state, nonce string) (string, error) {
+	d, err := a.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", a.cfg.OIDCClientID())
+	v.Set("redirect_uri", a.cfg.OIDCRedirectURL())
+	v.Set("scope", strings.Join(a.cfg.OIDCScopes(), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange exchanges the authorization code returned by the issuer for an ID token
+// and returns the AuthResource of the user or an error.
+// The nonce must be the same used to create the authorization URL.
+func (a *AuthOIDC) Exchange(code, nonce string) (*auth.AuthResource, error) {
+	d, err := a.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", a.cfg.OIDCRedirectURL())
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.cfg.OIDCClientID()), url.QueryEscape(a.cfg.OIDCClientSecret()))
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("oidc token endpoint returned status %d", res.StatusCode))
+	}
+
+	tokenRes := &TokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(tokenRes); err != nil {
+		return nil, err
+	}
+	if tokenRes.IDToken == "" {
+		return nil, errors.New("oidc token endpoint did not return an id_token")
+	}
+	return a.verify(tokenRes.IDToken, nonce)
+}
+
+// verify verifies the signature and the claims of a token issued by the OpenID Connect issuer
+// and maps its claims to an AuthResource.
+// If nonce is not empty the token must contain the same nonce claim.
+func (a *AuthOIDC) verify(tokenString, nonce string) (*auth.AuthResource, error) {
+	d, err := a.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &jwt.Parser{ValidMethods: validMethods}
+	token, err := parser.Parse(tokenString, a.keyFunc)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("oidc token is not valid because: %s", err.Error()))
+	}
+
+	if iss, _ := token.Claims["iss"].(string); iss != d.Issuer {
+		return nil, errors.New(fmt.Sprintf("oidc token issuer '%s' does not match '%s'", iss, d.Issuer))
+	}
+	if !hasAudience(token.Claims["aud"], a.cfg.OIDCClientID()) {
+		return nil, errors.New(fmt.Sprintf("oidc token audience does not contain '%s'", a.cfg.OIDCClientID()))
+	}
+	if _, ok := token.Claims["exp"]; !ok {
+		return nil, errors.New("oidc token does not contain exp claim")
+	}
+	if nonce != "" {
+		if n, _ := token.Claims["nonce"].(string); n != nonce {
+			return nil, errors.New("oidc token nonce does not match")
+		}
+	}
+
+	username, _ := token.Claims[a.cfg.OIDCUsernameClaim()].(string)
+	if username == "" {
+		return nil, errors.New(fmt.Sprintf("oidc token does not contain username claim '%s'", a.cfg.OIDCUsernameClaim()))
+	}
+	authRes := &auth.AuthResource{
+		Username: username,
+		AuthID:   a.GetID(),
+		Groups:   auth.ClaimStrings(token.Claims[a.cfg.OIDCGroupsClaim()]),
+		Roles:    auth.ClaimStrings(token.Claims[a.cfg.OIDCRolesClaim()]),
+		AMR:      auth.ClaimStrings(token.Claims["amr"]),
+		Extra:    token.Claims,
+	}
+	authRes.DisplayName, _ = token.Claims["name"].(string)
+	authRes.Email, _ = token.Claims["email"].(string)
+	return authRes, nil
+}
+
+// keyFunc returns the issuer key used to verify the token.
+// The key must be compatible with the signing method of the token, and if the key
+// declares an algorithm it must be the same as the token algorithm.
+func (a *AuthOIDC) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := a.getKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := token.Method.Alg()
+	if key.Alg != "" && key.Alg != alg {
+		return nil, errors.New(fmt.Sprintf("key '%s' must be used with %s but token uses %s", kid, key.Alg, alg))
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return nil, errors.New(fmt.Sprintf("key '%s' is an RSA key but token uses %s", kid, alg))
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return nil, errors.New(fmt.Sprintf("key '%s' is an EC key but token uses %s", kid, alg))
+		}
+	}
+	return pub, nil
+}
+
+// getKey returns the issuer key with the given key ID.
+// If the key is not found the keys are fetched again, at most once every minRefetch.
+func (a *AuthOIDC) getKey(kid string) (*jwk.Key, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	cacheTime := time.Duration(a.cfg.OIDCJWKSCacheTime()) * time.Second
+	if a.keys == nil || time.Since(a.keysFetched) > cacheTime {
+		if err := a.fetchKeys(); err != nil {
+			return nil, err
+		}
+	}
+
+	key := a.findKey(kid)
+	if key == nil && time.Since(a.keysFetched) > a.minRefetch {
+		a.log.Info("oidc key not found, fetching issuer keys again", map[string]interface{}{"kid": kid})
+		if err := a.fetchKeys(); err != nil {
+			return nil, err
+		}
+		key = a.findKey(kid)
+	}
+	if key == nil {
+		return nil, errors.New(fmt.Sprintf("oidc key '%s' not found", kid))
+	}
+	return key, nil
+}
+
+// findKey returns the key with the given key ID.
+// Tokens without key ID are only accepted if the issuer publishes a single key.
+func (a *AuthOIDC) findKey(kid string) *jwk.Key {
+	if kid == "" {
+		if len(a.keys.Keys) == 1 {
+			return a.keys.Keys[0]
+		}
+		return nil
+	}
+	return a.keys.Key(kid)
+}
+
+// fetchKeys fetches the issuer keys. It must be called with the lock held.
+func (a *AuthOIDC) fetchKeys() error {
+	d, err := a.getDiscoveryLocked()
+	if err != nil {
+		return err
+	}
+	keys := &jwk.Set{}
+	if err := a.getJSON(d.JWKSURI, keys); err != nil {
+		a.log.Error("failed fetching oidc keys", map[string]interface{}{"err": err, "url": d.JWKSURI})
+		return err
+	}
+	a.keys = keys
+	a.keysFetched = time.Now()
+	return nil
+}
+
+func (a *AuthOIDC) getDiscovery() (*Discovery, error) {
+	a.Lock()
+	defer a.Unlock()
+	return a.getDiscoveryLocked()
+}
+
+// getDiscoveryLocked returns the discovery document of the issuer, fetching it the first time.
+// It must be called with the lock held.
+func (a *AuthOIDC) getDiscoveryLocked() (*Discovery, error) {
+	if a.discovery != nil {
+		return a.discovery, nil
+	}
+
+	issuer := strings.TrimRight(a.cfg.OIDCIssuer(), "/")
+	d := &Discovery{}
+	if err := a.getJSON(issuer+"/.well-known/openid-configuration", d); err != nil {
+		a.log.Error("failed fetching oidc discovery document", map[string]interface{}{"err": err, "issuer": issuer})
+		return nil, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != issuer {
+		return nil, errors.New(fmt.Sprintf("oidc discovery issuer '%s' does not match '%s'", d.Issuer, issuer))
+	}
+	if d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document does not contain jwks_uri")
+	}
+	a.discovery = d
+	return a.discovery, nil
+}
+
+func (a *AuthOIDC) getJSON(url string, v interface{}) error {
+	res, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("GET %s returned status %d", url, res.StatusCode))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// hasAudience checks if the aud claim, a string or an array of strings, contains the audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syncato/lib/auth/jwk"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+
+	"github.com/dgrijalva/jwt-go"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+// fakeIssuer is a minimal OpenID Connect issuer serving the discovery document,
+// the keys and the token endpoint.
+type fakeIssuer struct {
+	server *httptest.Server
+	sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	jwksHits  int
+	idToken   string
+	tokenCode string
+}
+
+func newFakeIssuer() *fakeIssuer {
+	f := &fakeIssuer{keys: map[string]*rsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Discovery{
+			Issuer:                f.server.URL,
+			AuthorizationEndpoint: f.server.URL + "/authorize",
+			TokenEndpoint:         f.server.URL + "/token",
+			JWKSURI:               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		f.jwksHits++
+		set := &jwk.Set{}
+		for kid, k := range f.keys {
+			set.Keys = append(set.Keys, &jwk.Key{
+				Kty: "RSA",
+				Kid: kid,
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "syncato" || secret != "secret" || r.FormValue("code") != f.tokenCode {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&TokenResponse{IDToken: f.idToken, TokenType: "Bearer"})
+	})
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeIssuer) addKey(c *C, kid string) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	f.Lock()
+	f.keys[kid] = k
+	f.Unlock()
+}
+
+func (f *fakeIssuer) sign(c *C, kid string, claims map[string]interface{}) string {
+	f.Lock()
+	k := f.keys[kid]
+	f.Unlock()
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = kid
+	token.Claims = claims
+	s, err := token.SignedString(k)
+	c.Assert(err, IsNil)
+	return s
+}
+
+func (f *fakeIssuer) claims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":                f.server.URL,
+		"aud":                "syncato",
+		"sub":                "1234",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+		"name":               "Alice",
+		"email":              "alice@example.org",
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+type TestSuite struct {
+	issuer *fakeIssuer
+	a      *AuthOIDC
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	s.issuer = newFakeIssuer()
+	s.issuer.addKey(c, "key1")
+
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	data, _ := json.Marshal(map[string]interface{}{
+		"oidc_issuer":        s.issuer.server.URL,
+		"oidc_client_id":     "syncato",
+		"oidc_client_secret": "secret",
+		"oidc_redirect_url":  "https://syncato.example.org/api/oidc/callback",
+	})
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	s.a, err = NewAuthOIDC("oidc", cfg, log)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	s.issuer.server.Close()
+}
+
+func (s *TestSuite) TestAuthenticateToken(c *C) {
+	token := s.issuer.sign(c, "key1", s.issuer.claims(nil))
+	authRes, err := s.a.AuthenticateToken(token)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+	c.Assert(authRes.DisplayName, Equals, "Alice")
+	c.Assert(authRes.Email, Equals, "alice@example.org")
+	c.Assert(authRes.AuthID, Equals, "oidc")
+}
+
+func (s *TestSuite) TestAudienceArray(c *C) {
+	token := s.issuer.sign(c, "key1", s.issuer.claims(map[string]interface{}{"aud": []string{"other", "syncato"}}))
+	_, err := s.a.AuthenticateToken(token)
+	c.Assert(err, IsNil)
+}
+
+var InvalidClaimsTests = []map[string]interface{}{
+	{"aud": "other"},
+	{"iss": "https://evil.example.org"},
+	{"exp": time.Now().Add(-time.Minute).Unix()},
+	{"preferred_username": ""},
+}
+
+func (s *TestSuite) TestInvalidClaims(c *C) {
+	for _, t := range InvalidClaimsTests {
+		token := s.issuer.sign(c, "key1", s.issuer.claims(t))
+		_, err := s.a.AuthenticateToken(token)
+		c.Assert(err, NotNil, Commentf("claims %v", t))
+	}
+}
+
+func (s *TestSuite) TestRejectSymmetricAlgorithm(c *C) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["kid"] = "key1"
+	token.Claims = s.issuer.claims(nil)
+	tokenString, err := token.SignedString([]byte("key1"))
+	c.Assert(err, IsNil)
+	_, err = s.a.AuthenticateToken(tokenString)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestKeyRotation(c *C) {
+	_, err := s.a.AuthenticateToken(s.issuer.sign(c, "key1", s.issuer.claims(nil)))
+	c.Assert(err, IsNil)
+
+	// keys are cached, a token signed with a known key does not fetch them again.
+	_, err = s.a.AuthenticateToken(s.issuer.sign(c, "key1", s.issuer.claims(nil)))
+	c.Assert(err, IsNil)
+	c.Assert(s.issuer.jwksHits, Equals, 1)
+
+	s.issuer.addKey(c, "key2")
+	s.a.minRefetch = 0
+	_, err = s.a.AuthenticateToken(s.issuer.sign(c, "key2", s.issuer.claims(nil)))
+	c.Assert(err, IsNil)
+	c.Assert(s.issuer.jwksHits, Equals, 2)
+}
+
+func (s *TestSuite) TestUnknownKeyRefetchIsLimited(c *C) {
+	_, err := s.a.AuthenticateToken(s.issuer.sign(c, "key1", s.issuer.claims(nil)))
+	c.Assert(err, IsNil)
+
+	s.issuer.addKey(c, "key2")
+	_, err = s.a.AuthenticateToken(s.issuer.sign(c, "key2", s.issuer.claims(nil)))
+	c.Assert(err, NotNil)
+	c.Assert(s.issuer.jwksHits, Equals, 1)
+}
+
+func (s *TestSuite) TestAuthorizationCodeFlow(c *C) {
+	authURL, err := s.a.AuthCodeURL("state1", "nonce1")
+	c.Assert(err, IsNil)
+	u, err := url.Parse(authURL)
+	c.Assert(err, IsNil)
+	c.Assert(u.Path, Equals, "/authorize")
+	c.Assert(u.Query().Get("client_id"), Equals, "syncato")
+	c.Assert(u.Query().Get("state"), Equals, "state1")
+	c.Assert(u.Query().Get("nonce"), Equals, "nonce1")
+
+	s.issuer.tokenCode = "code1"
+	s.issuer.idToken = s.issuer.sign(c, "key1", s.issuer.claims(map[string]interface{}{"nonce": "nonce1"}))
+
+	authRes, err := s.a.Exchange("code1", "nonce1")
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+
+	_, err = s.a.Exchange("code1", "nonce2")
+	c.Assert(err, NotNil)
+	_, err = s.a.Exchange("bad-code", "nonce1")
+	c.Assert(err, NotNil)
+}
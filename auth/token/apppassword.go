@@ -0,0 +1,198 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+)
+
+// AppPasswordPrefix is the prefix of all the app passwords, used to tell them apart from
+// main passwords and JWT tokens.
+const AppPasswordPrefix = "syncato_"
+
+// lastUsedResolution is how often the last used time of an app password is saved to the file.
+const lastUsedResolution = time.Minute
+
+// InvalidAppPasswordError is returned when an app password is unknown, revoked or belongs to another user.
+type InvalidAppPasswordError struct {
+	Err string
+}
+
+func (e *InvalidAppPasswordError) Error() string { return e.Err }
+
+// AppPassword represents an app password or API key of a user.
+// The secret part of the password is never stored, only its SHA-256 hash.
+type AppPassword struct {
+	ID       string             `json:"id"`        // the public ID of the password.
+	Label    string             `json:"label"`     // the name given by the user, like "laptop sync client".
+	Hash     string             `json:"-"`         // the hex encoded SHA-256 hash of the secret.
+	AuthRes  *auth.AuthResource `json:"-"`         // the user the password was created for.
+	Scope    *auth.Scope        `json:"scope"`     // the restrictions of the password.
+	Created  int64              `json:"created"`   // the creation time in unix seconds.
+	LastUsed int64              `json:"last_used"` // the last time the password was used in unix seconds, or zero.
+}
+
+// storedAppPassword is the persisted form of an AppPassword, including its hash and owner.
+type storedAppPassword struct {
+	AppPassword
+	Hash    string             `json:"hash"`
+	AuthRes *auth.AuthResource `json:"auth_res"`
+}
+
+// AppPasswordStore keeps the app passwords created by the users to be used instead of their main
+// password in sync clients and scripts.
+//
+// App passwords are opaque strings with the format syncato_<id>.<secret>. They do not expire and can be
+// restricted with a scope. The store is persisted to a JSON file after every change.
+type AppPasswordStore struct {
+	sync.Mutex
+	filename  string
+	passwords map[string]*AppPassword // id => password
+}
+
+// NewAppPasswordStore creates an AppPasswordStore loading the app passwords from the file.
+// If filename is empty the store is kept in memory only.
+func NewAppPasswordStore(filename string) (*AppPasswordStore, error) {
+	s := &AppPasswordStore{filename: filename, passwords: map[string]*AppPassword{}}
+	stored := map[string]*storedAppPassword{}
+	if err := LoadJSON(filename, &stored); err != nil {
+		return nil, err
+	}
+	for id, sp := range stored {
+		p := sp.AppPassword
+		p.Hash = sp.Hash
+		p.AuthRes = sp.AuthRes
+		s.passwords[id] = &p
+	}
+	return s, nil
+}
+
+// IsAppPassword checks if the password or token has the format of an app password.
+func IsAppPassword(password string) bool {
+	return strings.HasPrefix(password, AppPasswordPrefix)
+}
+
+// Create creates an app password for the user with a label and an optional scope.
+// It returns the app password, which is not kept by the store and must be shown to the user only once,
+// and its details.
+func (s *AppPasswordStore) Create(authRes *auth.AuthResource, label string, scope *auth.Scope) (string, *AppPassword, error) {
+	id, err := NewID()
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	if scope == nil {
+		scope = &auth.Scope{}
+	}
+	p := &AppPassword{
+		ID:      id,
+		Label:   label,
+		Hash:    hashSecret(secret),
+		AuthRes: authRes,
+		Scope:   scope,
+		Created: time.Now().Unix(),
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.passwords[id] = p
+	if err := s.save(); err != nil {
+		delete(s.passwords, id)
+		return "", nil, err
+	}
+	info := *p
+	return AppPasswordPrefix + id + "." + secret, &info, nil
+}
+
+// List returns the app passwords of a user sorted by creation time.
+func (s *AppPasswordStore) List(username string) []*AppPassword {
+	s.Lock()
+	defer s.Unlock()
+	list := []*AppPassword{}
+	for _, p := range s.passwords {
+		if p.AuthRes.Username == username {
+			info := *p
+			list = append(list, &info)
+		}
+	}
+	sort.Sort(byCreated(list))
+	return list
+}
+
+// Revoke removes the app password with the ID of a user.
+func (s *AppPasswordStore) Revoke(username, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	p, ok := s.passwords[id]
+	if !ok || p.AuthRes.Username != username {
+		return &InvalidAppPasswordError{fmt.Sprintf("app password %s not found for user %s", id, username)}
+	}
+	delete(s.passwords, id)
+	return s.save()
+}
+
+// Authenticate authenticates an app password and records its use.
+// If username is not empty the password must belong to this user.
+// It returns the user the password was created for, restricted by the scope of the password.
+func (s *AppPasswordStore) Authenticate(username, password string) (*auth.AuthResource, error) {
+	parts := strings.SplitN(strings.TrimPrefix(password, AppPasswordPrefix), ".", 2)
+	if !IsAppPassword(password) || len(parts) != 2 {
+		return nil, &InvalidAppPasswordError{"malformed app password"}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	p, ok := s.passwords[parts[0]]
+	if !ok || subtle.ConstantTimeCompare([]byte(p.Hash), []byte(hashSecret(parts[1]))) != 1 {
+		return nil, &InvalidAppPasswordError{"app password not found"}
+	}
+	if username != "" && p.AuthRes.Username != username {
+		return nil, &InvalidAppPasswordError{fmt.Sprintf("app password does not belong to user %s", username)}
+	}
+
+	now := time.Now()
+	if now.Sub(time.Unix(p.LastUsed, 0)) >= lastUsedResolution {
+		p.LastUsed = now.Unix()
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	authRes := *p.AuthRes
+	scope := *p.Scope
+	authRes.Scope = &scope
+	return &authRes, nil
+}
+
+// save persists the store. It must be called with the lock held.
+func (s *AppPasswordStore) save() error {
+	stored := make(map[string]*storedAppPassword, len(s.passwords))
+	for id, p := range s.passwords {
+		stored[id] = &storedAppPassword{AppPassword: *p, Hash: p.Hash, AuthRes: p.AuthRes}
+	}
+	return SaveJSON(s.filename, stored)
+}
+
+// IsInvalidAppPasswordError checks if the error is an InvalidAppPasswordError.
+func IsInvalidAppPasswordError(err error) bool {
+	_, ok := err.(*InvalidAppPasswordError)
+	return ok
+}
+
+type byCreated []*AppPassword
+
+func (l byCreated) Len() int           { return len(l) }
+func (l byCreated) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byCreated) Less(i, j int) bool { return l[i].Created < l[j].Created }
@@ -0,0 +1,57 @@
+package token
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+type AppPasswordSuite struct {
+	file string
+	s    *AppPasswordStore
+}
+
+var _ = Suite(&AppPasswordSuite{})
+
+func (s *AppPasswordSuite) SetUpTest(c *C) {
+	s.file = filepath.Join(c.MkDir(), "app_passwords.json")
+	var err error
+	s.s, err = NewAppPasswordStore(s.file)
+	c.Assert(err, IsNil)
+}
+
+func (s *AppPasswordSuite) TestLifecycle(c *C) {
+	alice := &auth.AuthResource{Username: "alice", AuthID: "json"}
+	password, p, err := s.s.Create(alice, "laptop", &auth.Scope{ReadOnly: true, Schemes: []string{"local"}})
+	c.Assert(err, IsNil)
+	c.Assert(IsAppPassword(password), Equals, true)
+
+	// only the hash of the secret is stored.
+	data, err := ioutil.ReadFile(s.file)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(data), password[strings.Index(password, ".")+1:]), Equals, false)
+
+	store, err := NewAppPasswordStore(s.file)
+	c.Assert(err, IsNil)
+	authRes, err := store.Authenticate("alice", password)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+	c.Assert(authRes.Scope.AllowsWrite(), Equals, false)
+	c.Assert(authRes.Scope.AllowsScheme("eos"), Equals, false)
+
+	_, err = store.Authenticate("bob", password)
+	c.Assert(IsInvalidAppPasswordError(err), Equals, true)
+
+	list := store.List("alice")
+	c.Assert(list, HasLen, 1)
+	c.Assert(list[0].Label, Equals, "laptop")
+	c.Assert(list[0].LastUsed > 0, Equals, true)
+
+	c.Assert(store.Revoke("alice", p.ID), IsNil)
+	_, err = store.Authenticate("", password)
+	c.Assert(IsInvalidAppPasswordError(err), Equals, true)
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/syncato/lib/auth"
+)
+
+// InvalidTokenError is returned when a JWT authentication token is malformed, has not
+// been signed by the key set or its claims are not valid.
+type InvalidTokenError struct {
+	Err string
+}
+
+func (e *InvalidTokenError) Error() string { return e.Err }
+
+// Audience is the aud claim. It is encoded as a string if it has a single value and
+// as an array of strings otherwise, and it can be decoded from both forms.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return errors.New("aud claim must be a string or an array of strings")
+	}
+	*a = Audience(ss)
+	return nil
+}
+
+// Contains checks if aud is one of the values of the audience.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor is the act claim (RFC 8693) of the tokens used to impersonate a user.
+type Actor struct {
+	Subject string `json:"sub"` // the username of the user acting as the subject of the token.
+}
+
+// Claims are the claims of the JWT authentication tokens issued by the daemon.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+
+	Username    string      `json:"username"`
+	DisplayName string      `json:"display_name"`
+	Email       string      `json:"email"`
+	AuthID      string      `json:"auth_id"`
+	Groups      []string    `json:"groups,omitempty"`
+	Roles       []string    `json:"roles,omitempty"`
+	AMR         []string    `json:"amr,omitempty"`
+	Scope       *auth.Scope `json:"scope,omitempty"`
+	Actor       *Actor      `json:"act,omitempty"`
+	Extra       interface{} `json:"extra,omitempty"`
+
+	// MFAPending marks the tokens of users that must still verify the second factor.
+	MFAPending bool `json:"mfa_pending,omitempty"`
+
+	// URLPath, URLQuery and URLOp restrict the tokens of signed URLs to one resource path, with the encoded
+	// query parameters of the URL, and one operation.
+	URLPath  string `json:"url_path,omitempty"`
+	URLQuery string `json:"url_query,omitempty"`
+	URLOp    string `json:"url_op,omitempty"`
+}
+
+// NewClaims returns the claims of a token for the user, issued now and valid for ttl.
+func NewClaims(authRes *auth.AuthResource, iss, aud string, ttl time.Duration) (*Claims, error) {
+	jti, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c := &Claims{
+		Issuer:      iss,
+		Subject:     authRes.Username,
+		ExpiresAt:   now.Add(ttl).Unix(),
+		IssuedAt:    now.Unix(),
+		ID:          jti,
+		Username:    authRes.Username,
+		DisplayName: authRes.DisplayName,
+		Email:       authRes.Email,
+		AuthID:      authRes.AuthID,
+		Groups:      authRes.Groups,
+		Roles:       authRes.Roles,
+		AMR:         authRes.AMR,
+		Scope:       authRes.Scope,
+		Extra:       authRes.Extra,
+	}
+	if aud != "" {
+		c.Audience = Audience{aud}
+	}
+	if authRes.Actor != "" {
+		c.Actor = &Actor{Subject: authRes.Actor}
+	}
+	return c, nil
+}
+
+// ClaimsFromMap decodes the claims of a parsed token.
+func ClaimsFromMap(m map[string]interface{}) (*Claims, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, &InvalidTokenError{Err: fmt.Sprintf("malformed claims: %s", err.Error())}
+	}
+	c := &Claims{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, &InvalidTokenError{Err: fmt.Sprintf("malformed claims: %s", err.Error())}
+	}
+	return c, nil
+}
+
+// Map returns the claims as the map signed by KeySet.Sign.
+func (c *Claims) Map() (map[string]interface{}, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks the registered claims at the time now.
+// The token must have an ID, a user and an expiration time, and if iss or aud are not empty
+// they must be the issuer and one of the audiences of the token.
+func (c *Claims) Validate(iss, aud string, now time.Time) error {
+	switch {
+	case c.ID == "":
+		return &InvalidTokenError{Err: "token does not contain jti claim"}
+	case c.Username == "":
+		return &InvalidTokenError{Err: "token does not contain username claim"}
+	case c.Subject != "" && c.Subject != c.Username:
+		return &InvalidTokenError{Err: fmt.Sprintf("sub claim '%s' does not match username '%s'", c.Subject, c.Username)}
+	case c.ExpiresAt == 0:
+		return &InvalidTokenError{Err: "token does not contain exp claim"}
+	case now.Unix() > c.ExpiresAt:
+		return &InvalidTokenError{Err: "token has expired"}
+	case c.NotBefore != 0 && now.Unix() < c.NotBefore:
+		return &InvalidTokenError{Err: "token is not valid yet"}
+	case c.IssuedAt != 0 && now.Unix() < c.IssuedAt:
+		return &InvalidTokenError{Err: "token has been issued in the future"}
+	case iss != "" && c.Issuer != iss:
+		return &InvalidTokenError{Err: fmt.Sprintf("token issuer '%s' is not '%s'", c.Issuer, iss)}
+	case aud != "" && !c.Audience.Contains(aud):
+		return &InvalidTokenError{Err: fmt.Sprintf("token audience is not '%s'", aud)}
+	}
+	return nil
+}
+
+// AuthResource returns the user of the token.
+func (c *Claims) AuthResource() *auth.AuthResource {
+	authRes := &auth.AuthResource{
+		Username:    c.Username,
+		DisplayName: c.DisplayName,
+		Email:       c.Email,
+		AuthID:      c.AuthID,
+		Groups:      c.Groups,
+		Roles:       c.Roles,
+		AMR:         c.AMR,
+		Scope:       c.Scope,
+		Extra:       c.Extra,
+	}
+	if c.Actor != nil {
+		authRes.Actor = c.Actor.Subject
+	}
+	return authRes
+}
+
+// SignClaims signs the claims with the signing key and returns the JWT.
+func (ks *KeySet) SignClaims(c *Claims) (string, error) {
+	m, err := c.Map()
+	if err != nil {
+		return "", err
+	}
+	return ks.Sign(m)
+}
+
+// ParseClaims parses and verifies a JWT signed by one of the keys in the set and decodes its claims.
+// The registered claims are not validated, see Claims.Validate.
+func (ks *KeySet) ParseClaims(tokenString string) (*Claims, error) {
+	t, err := ks.Parse(tokenString)
+	if err != nil {
+		return nil, &InvalidTokenError{Err: err.Error()}
+	}
+	return ClaimsFromMap(t.Claims)
+}
+
+// IsInvalidTokenError checks if the error is an InvalidTokenError.
+func IsInvalidTokenError(err error) bool {
+	_, ok := err.(*InvalidTokenError)
+	return ok
+}
@@ -0,0 +1,67 @@
+package token
+
+import (
+	"time"
+
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+type ClaimsSuite struct {
+	ks *KeySet
+}
+
+var _ = Suite(&ClaimsSuite{})
+
+func (s *ClaimsSuite) SetUpTest(c *C) {
+	s.ks = NewKeySet()
+	k, err := NewHMACKey("", "HS256", []byte("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(s.ks.AddKey(k), IsNil)
+	c.Assert(s.ks.SetSigningKey(""), IsNil)
+}
+
+func (s *ClaimsSuite) TestRoundTrip(c *C) {
+	authRes := &auth.AuthResource{
+		Username: "alice",
+		AuthID:   "json",
+		Groups:   []string{"staff"},
+		Scope:    &auth.Scope{ReadOnly: true, Schemes: []string{"local"}},
+		Actor:    "root",
+		Extra:    map[string]interface{}{"quota": "10G"},
+	}
+	claims, err := NewClaims(authRes, "syncato.org", "files", time.Minute)
+	c.Assert(err, IsNil)
+	tokenString, err := s.ks.SignClaims(claims)
+	c.Assert(err, IsNil)
+
+	parsed, err := s.ks.ParseClaims(tokenString)
+	c.Assert(err, IsNil)
+	c.Assert(parsed.Validate("syncato.org", "files", time.Now()), IsNil)
+	c.Assert(parsed.AuthResource(), DeepEquals, authRes)
+}
+
+func (s *ClaimsSuite) TestMalformed(c *C) {
+	tokenString, err := s.ks.Sign(map[string]interface{}{"username": 42})
+	c.Assert(err, IsNil)
+	_, err = s.ks.ParseClaims(tokenString)
+	c.Assert(IsInvalidTokenError(err), Equals, true)
+
+	tokenString, err = s.ks.Sign(map[string]interface{}{"jti": "1", "exp": time.Now().Add(time.Minute).Unix()})
+	c.Assert(err, IsNil)
+	claims, err := s.ks.ParseClaims(tokenString)
+	c.Assert(err, IsNil)
+	c.Assert(claims.Validate("", "", time.Now()), ErrorMatches, "token does not contain username claim")
+}
+
+func (s *ClaimsSuite) TestValidate(c *C) {
+	claims, err := NewClaims(&auth.AuthResource{Username: "alice"}, "syncato.org", "files", time.Minute)
+	c.Assert(err, IsNil)
+	c.Assert(claims.Validate("other.org", "", time.Now()), NotNil)
+	c.Assert(claims.Validate("", "other", time.Now()), NotNil)
+	c.Assert(claims.Validate("", "", time.Now().Add(2*time.Minute)), ErrorMatches, "token has expired")
+
+	claims.NotBefore = time.Now().Add(time.Minute).Unix()
+	c.Assert(claims.Validate("", "", time.Now()), ErrorMatches, "token is not valid yet")
+}
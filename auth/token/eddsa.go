@@ -0,0 +1,51 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method with Ed25519 keys.
+// Sign expects an ed25519.PrivateKey and Verify expects an ed25519.PublicKey.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok || len(pub) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("EdDSA verification failed")
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok || len(priv) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package token defines the keys used to sign and verify the JWT authentication tokens
+// issued by the daemon.
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syncato/lib/auth/jwk"
+	"github.com/syncato/lib/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Key is a key used to sign and verify JWT authentication tokens.
+// A key is identified by its ID, sent in the kid header of the tokens, and
+// it can only be used with a single algorithm.
+type Key struct {
+	ID  string
+	Alg string
+
+	signKey   interface{} // the private key or the HMAC secret.
+	verifyKey interface{} // the public key or the HMAC secret.
+}
+
+// NewHMACKey creates a symmetric key for the HS256, HS384 or HS512 algorithms.
+func NewHMACKey(kid, alg string, secret []byte) (*Key, error) {
+	switch alg {
+	case "HS256", "HS384", "HS512":
+	default:
+		return nil, errors.New(fmt.Sprintf("algorithm '%s' is not an HMAC algorithm", alg))
+	}
+	if len(secret) == 0 {
+		return nil, errors.New(fmt.Sprintf("empty secret for key '%s'", kid))
+	}
+	return &Key{ID: kid, Alg: alg, signKey: secret, verifyKey: secret}, nil
+}
+
+// NewKey creates an asymmetric key from a private key.
+// RS256 requires an *rsa.PrivateKey, ES256 an *ecdsa.PrivateKey on the P-256 curve and
+// EdDSA an ed25519.PrivateKey.
+func NewKey(kid, alg string, priv crypto.Signer) (*Key, error) {
+	ok := false
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		ok = alg == "RS256" || alg == "RS384" || alg == "RS512"
+	case *ecdsa.PrivateKey:
+		ok = (alg == "ES256" && k.Curve.Params().Name == "P-256") ||
+			(alg == "ES384" && k.Curve.Params().Name == "P-384") ||
+			(alg == "ES512" && k.Curve.Params().Name == "P-521")
+	case ed25519.PrivateKey:
+		ok = alg == "EdDSA"
+	}
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("key '%s' of type %T cannot be used with algorithm '%s'", kid, priv, alg))
+	}
+	return &Key{ID: kid, Alg: alg, signKey: priv, verifyKey: priv.Public()}, nil
+}
+
+// LoadKey creates an asymmetric key from a PEM encoded private key file.
+// PKCS#1 RSA, SEC 1 EC and PKCS#8 private keys are supported.
+func LoadKey(kid, alg, filename string) (*Key, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("no PEM data found in '%s'", filename))
+	}
+
+	var priv interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported private key in '%s'", filename))
+	}
+	return NewKey(kid, alg, signer)
+}
+
+// KeySet keeps the keys used to sign and verify the JWT authentication tokens.
+//
+// Tokens are always signed with the signing key. All the keys in the set are used to
+// verify tokens, so a new signing key can be added while tokens signed with the
+// old keys are still valid until the old keys are removed.
+type KeySet struct {
+	sync.RWMutex
+	keys         map[string]*Key
+	signingKeyID string
+}
+
+// NewKeySet creates an empty KeySet.
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]*Key{}}
+}
+
+// NewKeySetFromConfig creates a KeySet with the keys defined in the configuration.
+//
+// The TokenSecret, if not empty, is added as an HMAC key without key ID using the TokenCipherSuite
+// algorithm, so tokens issued before asymmetric keys were configured are still valid.
+// The signing key is TokenSigningKeyID or the TokenSecret if no signing key ID is configured.
+func NewKeySetFromConfig(cfg *config.Config) (*KeySet, error) {
+	ks := NewKeySet()
+	if cfg.TokenSecret() != "" {
+		k, err := NewHMACKey("", cfg.TokenCipherSuite(), []byte(cfg.TokenSecret()))
+		if err != nil {
+			return nil, err
+		}
+		ks.AddKey(k)
+	}
+	for _, tk := range cfg.TokenKeys() {
+		if tk.ID == "" {
+			return nil, errors.New(fmt.Sprintf("token key '%s' without kid", tk.PrivateKeyFile))
+		}
+		k, err := LoadKey(tk.ID, tk.Alg, tk.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.AddKey(k); err != nil {
+			return nil, err
+		}
+	}
+	if err := ks.SetSigningKey(cfg.TokenSigningKeyID()); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// AddKey adds a key to the set.
+func (ks *KeySet) AddKey(k *Key) error {
+	ks.Lock()
+	defer ks.Unlock()
+	if _, ok := ks.keys[k.ID]; ok {
+		return errors.New(fmt.Sprintf("key '%s' already in key set", k.ID))
+	}
+	ks.keys[k.ID] = k
+	return nil
+}
+
+// RemoveKey removes a key from the set. The signing key cannot be removed.
+func (ks *KeySet) RemoveKey(kid string) error {
+	ks.Lock()
+	defer ks.Unlock()
+	if kid == ks.signingKeyID {
+		return errors.New(fmt.Sprintf("key '%s' is the signing key", kid))
+	}
+	delete(ks.keys, kid)
+	return nil
+}
+
+// SetSigningKey sets the key used to sign new tokens.
+func (ks *KeySet) SetSigningKey(kid string) error {
+	ks.Lock()
+	defer ks.Unlock()
+	if _, ok := ks.keys[kid]; !ok {
+		return errors.New(fmt.Sprintf("key '%s' not in key set", kid))
+	}
+	ks.signingKeyID = kid
+	return nil
+}
+
+// SigningKeyID returns the ID of the key used to sign new tokens.
+func (ks *KeySet) SigningKeyID() string {
+	ks.RLock()
+	defer ks.RUnlock()
+	return ks.signingKeyID
+}
+
+// Sign signs the claims with the signing key and returns the JWT.
+func (ks *KeySet) Sign(claims map[string]interface{}) (string, error) {
+	ks.RLock()
+	k, ok := ks.keys[ks.signingKeyID]
+	ks.RUnlock()
+	if !ok {
+		return "", errors.New("no signing key in key set")
+	}
+
+	token := jwt.New(jwt.GetSigningMethod(k.Alg))
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+	token.Claims = claims
+	return token.SignedString(k.signKey)
+}
+
+// Parse parses and verifies a JWT signed by one of the keys in the set.
+// The key is selected by the kid header and the token algorithm must be the algorithm of the key,
+// so a token cannot be verified with a key of a different type.
+func (ks *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		ks.RLock()
+		k, ok := ks.keys[kid]
+		ks.RUnlock()
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("key '%s' not found", kid))
+		}
+		if token.Method.Alg() != k.Alg {
+			return nil, errors.New(fmt.Sprintf("key '%s' must be used with %s but token uses %s", kid, k.Alg, token.Method.Alg()))
+		}
+		return k.verifyKey, nil
+	})
+}
+
+// JWKS returns the public keys of the set as a JSON Web Key Set.
+// Symmetric keys are never published.
+func (ks *KeySet) JWKS() (*jwk.Set, error) {
+	ks.RLock()
+	defer ks.RUnlock()
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := &jwk.Set{Keys: []*jwk.Key{}}
+	for _, kid := range kids {
+		k := ks.keys[kid]
+		if strings.HasPrefix(k.Alg, "HS") {
+			continue
+		}
+		pub, err := jwk.NewKey(k.ID, k.Alg, k.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, pub)
+	}
+	return set, nil
+}
@@ -0,0 +1,126 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	ks *KeySet
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	s.ks = NewKeySet()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	k, err := NewKey("rsa1", "RS256", rsaKey)
+	c.Assert(err, IsNil)
+	c.Assert(s.ks.AddKey(k), IsNil)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	k, err = NewKey("ec1", "ES256", ecKey)
+	c.Assert(err, IsNil)
+	c.Assert(s.ks.AddKey(k), IsNil)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+	k, err = NewKey("ed1", "EdDSA", edKey)
+	c.Assert(err, IsNil)
+	c.Assert(s.ks.AddKey(k), IsNil)
+}
+
+func (s *TestSuite) TestSignAndParse(c *C) {
+	for _, kid := range []string{"rsa1", "ec1", "ed1"} {
+		c.Assert(s.ks.SetSigningKey(kid), IsNil)
+		tokenString, err := s.ks.Sign(map[string]interface{}{"username": "alice"})
+		c.Assert(err, IsNil)
+		token, err := s.ks.Parse(tokenString)
+		c.Assert(err, IsNil)
+		c.Assert(token.Header["kid"], Equals, kid)
+		c.Assert(token.Claims["username"], Equals, "alice")
+	}
+}
+
+func (s *TestSuite) TestRotation(c *C) {
+	c.Assert(s.ks.SetSigningKey("rsa1"), IsNil)
+	oldToken, err := s.ks.Sign(map[string]interface{}{"username": "alice"})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.ks.SetSigningKey("ed1"), IsNil)
+	_, err = s.ks.Parse(oldToken)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.ks.RemoveKey("ed1"), NotNil)
+	c.Assert(s.ks.RemoveKey("rsa1"), IsNil)
+	_, err = s.ks.Parse(oldToken)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestAlgorithmConfusion(c *C) {
+	// a token signed with HS256 using the public RSA key as secret must be rejected.
+	jwks, err := s.ks.JWKS()
+	c.Assert(err, IsNil)
+	pub, err := jwks.Key("rsa1").PublicKey()
+	c.Assert(err, IsNil)
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	c.Assert(err, IsNil)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["kid"] = "rsa1"
+	token.Claims["username"] = "mallory"
+	tokenString, err := token.SignedString(pubBytes)
+	c.Assert(err, IsNil)
+	_, err = s.ks.Parse(tokenString)
+	c.Assert(err, NotNil)
+
+	// a token signed with a key of the set but claiming another kid must be rejected.
+	c.Assert(s.ks.SetSigningKey("ec1"), IsNil)
+	tokenString, err = s.ks.Sign(map[string]interface{}{"username": "alice"})
+	c.Assert(err, IsNil)
+	parts := strings.Split(tokenString, ".")
+	t, _ := jwt.Parse(tokenString, nil)
+	t.Header["kid"] = "ed1"
+	forged, err := t.SigningString()
+	c.Assert(err, IsNil)
+	_, err = s.ks.Parse(forged + "." + parts[2])
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestJWKSOmitsSymmetricKeys(c *C) {
+	k, err := NewHMACKey("", "HS256", []byte("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(s.ks.AddKey(k), IsNil)
+
+	jwks, err := s.ks.JWKS()
+	c.Assert(err, IsNil)
+	c.Assert(jwks.Keys, HasLen, 3)
+	for _, k := range jwks.Keys {
+		pub, err := k.PublicKey()
+		c.Assert(err, IsNil)
+		c.Assert(pub, NotNil)
+	}
+}
+
+func (s *TestSuite) TestNewKeyChecksAlgorithm(c *C) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	c.Assert(err, IsNil)
+	_, err = NewKey("ec2", "ES256", ecKey)
+	c.Assert(err, NotNil)
+	_, err = NewKey("ec2", "RS256", ecKey)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,191 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+)
+
+// RefreshTokenReusedError is returned when a refresh token that has already been exchanged is used again.
+// This means the token has been stolen, so all the tokens of its family are revoked.
+type RefreshTokenReusedError struct {
+	Username string
+	Family   string
+}
+
+func (e *RefreshTokenReusedError) Error() string {
+	return fmt.Sprintf("refresh token of family %s for user %s has already been used", e.Family, e.Username)
+}
+
+// InvalidRefreshTokenError is returned when a refresh token is unknown, expired or revoked.
+type InvalidRefreshTokenError struct {
+	Err string
+}
+
+func (e *InvalidRefreshTokenError) Error() string { return e.Err }
+
+// refreshToken is the server side state of a refresh token.
+// The secret part of the token is never stored, only its SHA-256 hash.
+type refreshToken struct {
+	Family  string             `json:"family"`   // all the tokens obtained by rotation share the family of the first one.
+	Hash    string             `json:"hash"`     // the hex encoded SHA-256 hash of the secret.
+	AuthRes *auth.AuthResource `json:"auth_res"` // the user the token was issued to.
+	Expires int64              `json:"expires"`  // the expiration time in unix seconds.
+	Used    bool               `json:"used"`     // if the token has already been exchanged.
+}
+
+// RefreshStore keeps the long lived refresh tokens used to obtain new access tokens.
+//
+// Refresh tokens are opaque strings with the format <id>.<secret>. Every time a refresh token is
+// exchanged it is marked as used and a new token of the same family is returned (rotation).
+// Presenting a used token again revokes the whole family (reuse detection).
+// The store is persisted to a JSON file after every change.
+type RefreshStore struct {
+	sync.Mutex
+	filename string
+	tokens   map[string]*refreshToken // id => token
+}
+
+// NewRefreshStore creates a RefreshStore loading the refresh tokens from the file.
+// If filename is empty the store is kept in memory only.
+func NewRefreshStore(filename string) (*RefreshStore, error) {
+	rs := &RefreshStore{filename: filename, tokens: map[string]*refreshToken{}}
+	if err := LoadJSON(filename, &rs.tokens); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Create creates a refresh token of a new family for the user.
+func (rs *RefreshStore) Create(authRes *auth.AuthResource, ttl time.Duration) (string, error) {
+	family, err := NewID()
+	if err != nil {
+		return "", err
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.create(family, authRes, ttl)
+}
+
+// Rotate exchanges a refresh token for a new one of the same family.
+// It returns the user the token was issued to and the new refresh token or an error.
+func (rs *RefreshStore) Rotate(tokenString string, ttl time.Duration) (*auth.AuthResource, string, error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	id, t, err := rs.lookup(tokenString)
+	if err != nil {
+		return nil, "", err
+	}
+	if t.Used {
+		rs.revokeFamily(t.Family)
+		if err := SaveJSON(rs.filename, rs.tokens); err != nil {
+			return nil, "", err
+		}
+		return nil, "", &RefreshTokenReusedError{Username: t.AuthRes.Username, Family: t.Family}
+	}
+	if t.Expires < time.Now().Unix() {
+		delete(rs.tokens, id)
+		if err := SaveJSON(rs.filename, rs.tokens); err != nil {
+			return nil, "", err
+		}
+		return nil, "", &InvalidRefreshTokenError{"refresh token expired"}
+	}
+
+	t.Used = true
+	newToken, err := rs.create(t.Family, t.AuthRes, ttl)
+	if err != nil {
+		return nil, "", err
+	}
+	return t.AuthRes, newToken, nil
+}
+
+// Revoke revokes the family of the refresh token, so neither the token nor the ones
+// obtained from it can be used anymore.
+func (rs *RefreshStore) Revoke(tokenString string) error {
+	rs.Lock()
+	defer rs.Unlock()
+	_, t, err := rs.lookup(tokenString)
+	if err != nil {
+		return err
+	}
+	rs.revokeFamily(t.Family)
+	return SaveJSON(rs.filename, rs.tokens)
+}
+
+// create creates a refresh token of the given family. It must be called with the lock held.
+func (rs *RefreshStore) create(family string, authRes *auth.AuthResource, ttl time.Duration) (string, error) {
+	id, err := NewID()
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	rs.tokens[id] = &refreshToken{
+		Family:  family,
+		Hash:    hashSecret(secret),
+		AuthRes: authRes,
+		Expires: time.Now().Add(ttl).Unix(),
+	}
+	rs.purge()
+	if err := SaveJSON(rs.filename, rs.tokens); err != nil {
+		delete(rs.tokens, id)
+		return "", err
+	}
+	return id + "." + secret, nil
+}
+
+// lookup returns the stored token matching the token string. It must be called with the lock held.
+func (rs *RefreshStore) lookup(tokenString string) (string, *refreshToken, error) {
+	parts := strings.SplitN(tokenString, ".", 2)
+	if len(parts) != 2 {
+		return "", nil, &InvalidRefreshTokenError{"malformed refresh token"}
+	}
+	t, ok := rs.tokens[parts[0]]
+	if !ok || subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashSecret(parts[1]))) != 1 {
+		return "", nil, &InvalidRefreshTokenError{"refresh token not found"}
+	}
+	return parts[0], t, nil
+}
+
+// revokeFamily removes all the tokens of a family. It must be called with the lock held.
+func (rs *RefreshStore) revokeFamily(family string) {
+	for id, t := range rs.tokens {
+		if t.Family == family {
+			delete(rs.tokens, id)
+		}
+	}
+}
+
+// purge removes the expired tokens. It must be called with the lock held.
+func (rs *RefreshStore) purge() {
+	now := time.Now().Unix()
+	for id, t := range rs.tokens {
+		if t.Expires < now {
+			delete(rs.tokens, id)
+		}
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsRefreshTokenReusedError checks if the error is a RefreshTokenReusedError.
+func IsRefreshTokenReusedError(err error) bool {
+	_, ok := err.(*RefreshTokenReusedError)
+	return ok
+}
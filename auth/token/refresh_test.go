@@ -0,0 +1,81 @@
+package token
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+type RefreshSuite struct {
+	file string
+	rs   *RefreshStore
+}
+
+var _ = Suite(&RefreshSuite{})
+
+func (s *RefreshSuite) SetUpTest(c *C) {
+	s.file = filepath.Join(c.MkDir(), "refresh.json")
+	var err error
+	s.rs, err = NewRefreshStore(s.file)
+	c.Assert(err, IsNil)
+}
+
+func (s *RefreshSuite) TestRotation(c *C) {
+	t1, err := s.rs.Create(&auth.AuthResource{Username: "alice", AuthID: "json"}, time.Hour)
+	c.Assert(err, IsNil)
+
+	authRes, t2, err := s.rs.Rotate(t1, time.Hour)
+	c.Assert(err, IsNil)
+	c.Assert(authRes.Username, Equals, "alice")
+	c.Assert(t2, Not(Equals), t1)
+
+	// the store is persisted, a new store sees the same tokens.
+	rs, err := NewRefreshStore(s.file)
+	c.Assert(err, IsNil)
+	_, _, err = rs.Rotate(t2, time.Hour)
+	c.Assert(err, IsNil)
+}
+
+func (s *RefreshSuite) TestReuseRevokesFamily(c *C) {
+	t1, err := s.rs.Create(&auth.AuthResource{Username: "alice", AuthID: "json"}, time.Hour)
+	c.Assert(err, IsNil)
+	_, t2, err := s.rs.Rotate(t1, time.Hour)
+	c.Assert(err, IsNil)
+
+	_, _, err = s.rs.Rotate(t1, time.Hour)
+	c.Assert(IsRefreshTokenReusedError(err), Equals, true)
+
+	_, _, err = s.rs.Rotate(t2, time.Hour)
+	c.Assert(err, FitsTypeOf, &InvalidRefreshTokenError{})
+}
+
+func (s *RefreshSuite) TestRevokeAndExpire(c *C) {
+	t1, err := s.rs.Create(&auth.AuthResource{Username: "alice", AuthID: "json"}, time.Hour)
+	c.Assert(err, IsNil)
+	c.Assert(s.rs.Revoke(t1), IsNil)
+	_, _, err = s.rs.Rotate(t1, time.Hour)
+	c.Assert(err, NotNil)
+
+	t2, err := s.rs.Create(&auth.AuthResource{Username: "alice", AuthID: "json"}, -time.Second)
+	c.Assert(err, IsNil)
+	_, _, err = s.rs.Rotate(t2, time.Hour)
+	c.Assert(err, NotNil)
+
+	// a token expired after being saved is removed from the file too.
+	t3, err := s.rs.Create(&auth.AuthResource{Username: "alice", AuthID: "json"}, time.Hour)
+	c.Assert(err, IsNil)
+	s.rs.tokens[strings.SplitN(t3, ".", 2)[0]].Expires = time.Now().Add(-time.Second).Unix()
+	_, _, err = s.rs.Rotate(t3, time.Hour)
+	c.Assert(err, ErrorMatches, "refresh token expired")
+	rs, err := NewRefreshStore(s.file)
+	c.Assert(err, IsNil)
+	_, _, err = rs.Rotate(t3, time.Hour)
+	c.Assert(err, ErrorMatches, "refresh token not found")
+
+	_, _, err = s.rs.Rotate("malformed", time.Hour)
+	c.Assert(err, NotNil)
+}
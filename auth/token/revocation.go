@@ -0,0 +1,56 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationList keeps the IDs (jti claims) of the JWT authentication tokens revoked before their expiration.
+// An entry is kept until the token it revokes expires, so the list does not grow forever.
+// The list is persisted to a JSON file after every change.
+type RevocationList struct {
+	sync.RWMutex
+	filename string
+	revoked  map[string]int64 // jti => expiration time of the token in unix seconds.
+}
+
+// NewRevocationList creates a RevocationList loading the revoked tokens from the file.
+// If filename is empty the list is kept in memory only.
+func NewRevocationList(filename string) (*RevocationList, error) {
+	rl := &RevocationList{filename: filename, revoked: map[string]int64{}}
+	if err := LoadJSON(filename, &rl.revoked); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Revoke revokes the token with the given jti until its expiration time.
+func (rl *RevocationList) Revoke(jti string, exp int64) error {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.revoked[jti] = exp
+	rl.purge()
+	return SaveJSON(rl.filename, rl.revoked)
+}
+
+// IsRevoked checks if the token with the given jti has been revoked.
+func (rl *RevocationList) IsRevoked(jti string) bool {
+	rl.RLock()
+	defer rl.RUnlock()
+	_, ok := rl.revoked[jti]
+	return ok
+}
+
+// purge removes the entries of expired tokens. It must be called with the lock held.
+func (rl *RevocationList) purge() {
+	now := time.Now().Unix()
+	for jti, exp := range rl.revoked {
+		if exp < now {
+			delete(rl.revoked, jti)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/syncato/lib/atomicfile"
+)
+
+// LoadJSON loads a JSON file into v. A missing file is not an error.
+func LoadJSON(filename string, v interface{}) error {
+	if filename == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SaveJSON saves v into a JSON file. An empty filename keeps the data in memory only.
+func SaveJSON(filename string, v interface{}) error {
+	if filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(filename, data, 0600)
+}
+
+// NewID returns a random URL safe identifier with 128 bits of entropy,
+// used for token IDs (jti claims) and refresh token families.
+func NewID() (string, error) {
+	return randomString(16)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/atomicfile"
+)
+
+// recoveryCodes is the number of recovery codes generated on enrollment.
+const recoveryCodes = 10
+
+// InvalidCodeError is returned when a code is wrong, already used, or the user is not enrolled.
+type InvalidCodeError struct {
+	Username string
+}
+
+func (e *InvalidCodeError) Error() string {
+	return fmt.Sprintf("invalid second factor code for user %s", e.Username)
+}
+
+// IsInvalidCodeError checks if the error is an InvalidCodeError.
+func IsInvalidCodeError(err error) bool {
+	_, ok := err.(*InvalidCodeError)
+	return ok
+}
+
+// Enrollment is the result of enrolling a user, to be shown to the user only once.
+type Enrollment struct {
+	Secret        string   `json:"secret"`         // the base32 encoded secret.
+	URI           string   `json:"uri"`            // the otpauth:// URI of the secret.
+	RecoveryCodes []string `json:"recovery_codes"` // single use codes to log in if the device is lost.
+}
+
+// enrollment is the server side state of a user enrolled.
+// The secret must be kept to compute the codes, but only the SHA-256 hashes of the recovery codes are stored.
+type enrollment struct {
+	Secret        string   `json:"secret"`
+	Confirmed     bool     `json:"confirmed"`      // the user has proved the device works with a valid code.
+	RecoveryCodes []string `json:"recovery_codes"` // the hex encoded SHA-256 hashes of the unused recovery codes.
+	LastCounter   int64    `json:"last_counter"`   // the time step of the last code used, to reject replays.
+}
+
+// Store keeps the TOTP secrets of the users enrolled in two-factor authentication.
+//
+// Enrolling is done in two steps: Enroll generates the secret and Confirm activates it when the user
+// sends the first valid code, so a user is never locked out by a secret that did not reach the device.
+// The store is persisted to a JSON file after every change. The file contains the secrets
+// so it must only be readable by the daemon.
+type Store struct {
+	sync.Mutex
+	filename    string
+	issuer      string
+	enrollments map[string]*enrollment // username => enrollment
+}
+
+// NewStore creates a Store loading the enrollments from the file.
+// The issuer is the name shown by the authenticator apps next to the account.
+// If filename is empty the store is kept in memory only.
+func NewStore(filename, issuer string) (*Store, error) {
+	s := &Store{filename: filename, issuer: issuer, enrollments: map[string]*enrollment{}}
+	if filename == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.enrollments); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// IsEnrolled checks if the user has a confirmed second factor.
+func (s *Store) IsEnrolled(username string) bool {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.enrollments[username]
+	return ok && e.Confirmed
+}
+
+// Enroll generates a new secret and recovery codes for the user.
+// The secret replaces any previous one after it is confirmed with Confirm.
+func (s *Store) Enroll(username string) (*Enrollment, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	res := &Enrollment{Secret: secret, URI: URI(s.issuer, username, secret)}
+	hashes := []string{}
+	for i := 0; i < recoveryCodes; i++ {
+		c, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		res.RecoveryCodes = append(res.RecoveryCodes, c)
+		hashes = append(hashes, hashCode(c))
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	// a confirmed enrollment is kept until the new one is confirmed.
+	key := pendingKey(username)
+	s.enrollments[key] = &enrollment{Secret: secret, RecoveryCodes: hashes}
+	if err := s.save(); err != nil {
+		delete(s.enrollments, key)
+		return nil, err
+	}
+	return res, nil
+}
+
+// Confirm activates the pending enrollment of the user with a valid code.
+func (s *Store) Confirm(username, code string) error {
+	s.Lock()
+	defer s.Unlock()
+	key := pendingKey(username)
+	e, ok := s.enrollments[key]
+	if !ok {
+		return &InvalidCodeError{Username: username}
+	}
+	c, err := Validate(e.Secret, code, time.Now())
+	if err != nil {
+		return err
+	}
+	if c < 0 {
+		return &InvalidCodeError{Username: username}
+	}
+	e.Confirmed = true
+	e.LastCounter = c
+	delete(s.enrollments, key)
+	s.enrollments[username] = e
+	return s.save()
+}
+
+// Verify checks a code, or a recovery code, of a user enrolled.
+// Every code can only be used once.
+func (s *Store) Verify(username, code string) error {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.enrollments[username]
+	if !ok || !e.Confirmed {
+		return &InvalidCodeError{Username: username}
+	}
+
+	c, err := Validate(e.Secret, code, time.Now())
+	if err != nil {
+		return err
+	}
+	if c >= 0 {
+		if c <= e.LastCounter {
+			return &InvalidCodeError{Username: username}
+		}
+		e.LastCounter = c
+		return s.save()
+	}
+
+	hash := hashCode(normalizeRecoveryCode(code))
+	for i, h := range e.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			e.RecoveryCodes = append(e.RecoveryCodes[:i], e.RecoveryCodes[i+1:]...)
+			return s.save()
+		}
+	}
+	return &InvalidCodeError{Username: username}
+}
+
+// Disable removes the second factor of the user.
+func (s *Store) Disable(username string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.enrollments, username)
+	delete(s.enrollments, pendingKey(username))
+	return s.save()
+}
+
+// save persists the store. It must be called with the lock held.
+func (s *Store) save() error {
+	if s.filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.enrollments)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.filename, data, 0600)
+}
+
+// pendingKey is the key of an enrollment not confirmed yet. Usernames cannot contain
+// the NUL character, so it never collides with a username.
+func pendingKey(username string) string {
+	return username + "\x00pending"
+}
+
+// randomRecoveryCode returns a code like 3f9a-c2e1-77b0.
+func randomRecoveryCode() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	h := hex.EncodeToString(b)
+	return h[0:4] + "-" + h[4:8] + "-" + h[8:12], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
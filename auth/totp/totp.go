@@ -0,0 +1,105 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package totp implements time-based one-time passwords (RFC 6238) used as a second
+// authentication factor, and the store of the users enrolled.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the duration of a time step, in seconds.
+	Period = 30
+
+	// Digits is the number of digits of a code.
+	Digits = 6
+
+	// Skew is the number of time steps before and after the current one accepted
+	// to tolerate clock drift between the server and the device of the user.
+	Skew = 1
+)
+
+// GenerateSecret returns a random base32 encoded secret of 160 bits, the size recommended by RFC 4226.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI of a secret, usually shown as a QR code to be scanned by authenticator apps.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", Digits))
+	v.Set("period", fmt.Sprintf("%d", Period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code returns the code of the secret for the time step of t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, counter(t)), nil
+}
+
+// Validate checks the code against the secret for the time steps around t.
+// It returns the counter of the time step matched, used to reject codes already used, or -1.
+func Validate(secret, code string, t time.Time) (int64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return -1, err
+	}
+	code = strings.TrimSpace(code)
+	now := counter(t)
+	for c := now - Skew; c <= now+Skew; c++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, c)), []byte(code)) == 1 {
+			return c, nil
+		}
+	}
+	return -1, nil
+}
+
+// hotp computes the HOTP value (RFC 4226) of the key for the counter.
+func hotp(key []byte, c int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(c))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+// the RFC 6238 SHA1 secret "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func (s *TestSuite) TestRFC6238(c *C) {
+	for t, expected := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := Code(rfcSecret, time.Unix(t, 0))
+		c.Assert(err, IsNil)
+		c.Assert(code, Equals, expected)
+	}
+
+	// the previous and next time steps are accepted.
+	counter, err := Validate(rfcSecret, "287082", time.Unix(59+Period, 0))
+	c.Assert(err, IsNil)
+	c.Assert(counter, Equals, int64(1))
+	counter, err = Validate(rfcSecret, "287082", time.Unix(59+3*Period, 0))
+	c.Assert(err, IsNil)
+	c.Assert(counter, Equals, int64(-1))
+}
+
+func (s *TestSuite) TestURI(c *C) {
+	uri := URI("Syncato", "alice", rfcSecret)
+	c.Assert(strings.HasPrefix(uri, "otpauth://totp/Syncato:alice?"), Equals, true)
+	c.Assert(strings.Contains(uri, "secret="+rfcSecret), Equals, true)
+}
+
+func (s *TestSuite) TestStore(c *C) {
+	file := filepath.Join(c.MkDir(), "totp.json")
+	store, err := NewStore(file, "Syncato")
+	c.Assert(err, IsNil)
+
+	enrollment, err := store.Enroll("alice")
+	c.Assert(err, IsNil)
+	c.Assert(enrollment.RecoveryCodes, HasLen, recoveryCodes)
+	c.Assert(store.IsEnrolled("alice"), Equals, false)
+
+	code, err := Code(enrollment.Secret, time.Now())
+	c.Assert(err, IsNil)
+	c.Assert(IsInvalidCodeError(store.Confirm("alice", "000000x")), Equals, true)
+	c.Assert(store.Confirm("alice", code), IsNil)
+
+	store, err = NewStore(file, "Syncato")
+	c.Assert(err, IsNil)
+	c.Assert(store.IsEnrolled("alice"), Equals, true)
+
+	// the code used to confirm cannot be used again.
+	c.Assert(IsInvalidCodeError(store.Verify("alice", code)), Equals, true)
+
+	// recovery codes are single use.
+	c.Assert(store.Verify("alice", strings.ToUpper(enrollment.RecoveryCodes[0])), IsNil)
+	c.Assert(IsInvalidCodeError(store.Verify("alice", enrollment.RecoveryCodes[0])), Equals, true)
+
+	c.Assert(store.Disable("alice"), IsNil)
+	c.Assert(store.IsEnrolled("alice"), Equals, false)
+}
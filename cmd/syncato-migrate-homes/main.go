@@ -0,0 +1,78 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Command syncato-migrate-homes relocates the home directories of a local storage to a new home layout.
+// The metadata directories of the homes under root_meta_dir are relocated too.
+//
+// The daemon must be stopped while migrating. The old layout is the one in the configuration file, or
+// the one given with -from, and after migrating the layout of the storage in storage_home_layouts must be
+// changed to the new one:
+//
+//	syncato-migrate-homes -config /etc/syncato/config.json -to "{authid}/{username[0]}/{username}"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	"github.com/syncato/lib/storage/providers/local"
+)
+
+func main() {
+	cfgFile := flag.String("config", "/etc/syncato/config.json", "the configuration file of the daemon")
+	scheme := flag.String("scheme", "local", "the scheme of the local storage")
+	from := flag.String("from", "", "the current home layout, by default the one in the configuration file")
+	to := flag.String("to", "", "the new home layout, "+storage.LegacyHomeLayout+" for the layout of the storages without one")
+	dryRun := flag.Bool("dry-run", false, "only print the homes that would be moved")
+	flag.Parse()
+
+	log := logger.NewLogger("syncato-migrate-homes", 4)
+	if *to == "" {
+		fail("the new home layout is missing, use -to")
+	}
+	cfg, err := config.New(*cfgFile, log)
+	if err != nil {
+		fail(err.Error())
+	}
+	if *from == "" {
+		*from = cfg.StorageHomeLayouts()[*scheme]
+	}
+	fromLayout, err := storage.NewHomeLayout(*from)
+	if err != nil {
+		fail(err.Error())
+	}
+	toLayout, err := storage.NewHomeLayout(*to)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	moves, err := local.MigrateHomes(cfg.RootDataDir(), fromLayout, toLayout, *dryRun, log)
+	for _, m := range moves {
+		fmt.Printf("%s -> %s\n", m.From, m.To)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+	if _, err := os.Stat(cfg.RootMetaDir()); cfg.RootMetaDir() != "" && err == nil {
+		metaMoves, err := local.MigrateHomes(cfg.RootMetaDir(), fromLayout, toLayout, *dryRun, log)
+		for _, m := range metaMoves {
+			fmt.Printf("metadata %s -> %s\n", m.From, m.To)
+		}
+		if err != nil {
+			fail(err.Error())
+		}
+	}
+	if !*dryRun {
+		fmt.Printf("%d homes moved, set the layout of %s in storage_home_layouts to %s\n", len(moves), *scheme, toLayout)
+	}
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, "syncato-migrate-homes:", msg)
+	os.Exit(1)
+}
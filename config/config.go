@@ -22,13 +22,61 @@ import (
 // 	  "maintenance_message": "Sorry for the intervention",
 // 	  "token_secret": "ViYiQqErJrt045NoRxCO0f3S6yzOuqRBZF8GyanMhwl1RyGB9GGe2KlGe3XR",
 // 	  "token_cipher_suite": "HS256",
+// 	  "token_keys": [{"kid": "2015-10", "alg": "ES256", "private_key_file": "/etc/private/syncato-2015-10.pem"}],
+// 	  "token_signing_key_id": "2015-10",
 // 	  "token_iss": "syncato.org",
+// 	  "token_aud": "syncato.org",
 // 	  "token_expiration_time": 3600,
+// 	  "token_refresh_expiration_time": 2592000,
+// 	  "token_signed_url_expiration_time": 300,
+// 	  "token_revocation_file": "/var/lib/syncato/revoked_tokens.json",
+// 	  "token_refresh_file": "/var/lib/syncato/refresh_tokens.json",
+// 	  "auth_app_password_file": "/var/lib/syncato/app_passwords.json",
+// 	  "auth_totp_file": "/var/lib/syncato/totp.json",
+// 	  "auth_totp_issuer": "Syncato",
 // 	  "create_user_home_on_login": true,
-// 	  "create_user_home_in_storages": ["local"]
+// 	  "create_user_home_in_storages": ["local"],
+// 	  "create_user_home_skeleton_dir": "/etc/syncato/skeleton",
+// 	  "create_user_home_quota": 5368709120,
+// 	  "create_user_home_state_file": "/var/lib/syncato/provisioning.json",
+// 	  "auth_chain": [{"id": "ldap", "realm": "example.org"}, {"id": "json", "username_pattern": "^svc-"}],
+// 	  "auth_chain_policy": "stop",
+// 	  "auth_lockout_threshold": 10,
+// 	  "auth_lockout_addr_threshold": 100,
+// 	  "auth_lockout_duration": 900,
+// 	  "auth_backoff_max_delay": 30,
+// 	  "auth_impersonation_time": 900,
+// 	  "authz_role_groups": {"admin": ["sysadmins"]},
+// 	  "authz_api_roles": {"admin": ["admin"]},
+// 	  "authz_scheme_groups": {"eos": ["physics", "it"]},
 // 	  "root_data_dir": "/data",
 // 	  "root_tmp_dir": "/tmp",
-// 	  "auth_json_file": "/etc/private/syncato_auth.json"
+// 	  "root_meta_dir": "/var/lib/syncato/meta",
+// 	  "storage_home_layouts": {"local": "{authid}/{username[0]}/{username}"},
+// 	  "storage_local_umask": "0027",
+// 	  "share_link_file": "/var/lib/syncato/share_links.json",
+// 	  "share_file": "/var/lib/syncato/shares.json",
+// 	  "storage_quota_default": 10737418240,
+// 	  "storage_quotas": {"ldap/alice": 107374182400, "json/backup": -1},
+// 	  "storage_quota_usage_file": "/var/lib/syncato/quota_usage.json",
+// 	  "storage_lock_file": "/var/lib/syncato/locks.json",
+// 	  "storage_lock_max_timeout": 604800,
+// 	  "storage_change_journal_size": 10000,
+// 	  "storage_mounts": [{"path": "/home", "scheme": "local", "prefix": "/"}, {"path": "/projects", "scheme": "eos", "prefix": "/projects"}, {"path": "/shared", "scheme": "shared"}],
+// 	  "auth_json_file": "/etc/private/syncato_auth.json",
+// 	  "auth_htpasswd_file": "/etc/private/syncato.htpasswd",
+// 	  "auth_htgroup_file": "/etc/private/syncato.htgroup",
+// 	  "auth_cert_ca_file": "/etc/private/syncato_clients_ca.pem",
+// 	  "auth_cert_rules": [{"field": "email", "pattern": "^(.+)@example\\.org$", "username": "$1"}, {"field": "cn", "pattern": "^batch-(.+)$", "username": "svc-$1"}],
+// 	  "oidc_issuer": "https://sso.example.org",
+// 	  "oidc_client_id": "syncato",
+// 	  "oidc_client_secret": "secret",
+// 	  "oidc_redirect_url": "https://syncato.example.org/api/oidc/callback",
+// 	  "oidc_scopes": ["openid", "profile", "email"],
+// 	  "oidc_username_claim": "preferred_username",
+// 	  "oidc_groups_claim": "groups",
+// 	  "oidc_roles_claim": "roles",
+// 	  "oidc_jwks_cache_time": 3600
 // 	}
 type ConfigParams struct {
 
@@ -56,25 +104,148 @@ type ConfigParams struct {
 	// @RO
 	// The cipher suite used to create the JWT secret.
 	// Once the daemon has run you MUST NOT change this value.
-	// Possible values: HS256
+	// Possible values: HS256, HS384, HS512
 	TokenCipherSuite string `json:"token_cipher_suite"`
 
+	// @RO
+	// The asymmetric keys used to sign and verify the JWT.
+	// All the keys are used to verify tokens, so to rotate keys add a new key, point
+	// TokenSigningKeyID to it and remove the old key once its tokens have expired.
+	// If TokenSecret is not empty it is also used to verify tokens without key ID.
+	TokenKeys []TokenKey `json:"token_keys"`
+
+	// @RO
+	// The ID of the key in TokenKeys used to sign new JWT.
+	// If this is empty, tokens are signed with TokenSecret.
+	TokenSigningKeyID string `json:"token_signing_key_id"`
+
 	// @RO
 	// The name of the organization issuing the JWT.
 	TokenISS string `json:"token_iss"`
 
+	// @RO
+	// The audience of the JWT, the tokens without this audience are rejected.
+	// If this is empty, the audience of the tokens is not checked.
+	TokenAUD string `json:"token_aud"`
+
 	// @RO
 	// The duration in seconds of the JWT to be valid.
+	// If this is zero, the default will be 3600.
 	TokenExpirationTime int `json:"token_expiration_time"`
 
+	// @RO
+	// The duration in seconds of the refresh tokens to be valid.
+	// Every time a refresh token is used a new one with this duration is issued.
+	// If this is zero, the default will be 2592000 (30 days).
+	TokenRefreshExpirationTime int `json:"token_refresh_expiration_time"`
+
+	// @RO
+	// The duration in seconds of the signed URLs to be valid.
+	// If this is zero, the default will be 300.
+	TokenSignedURLExpirationTime int `json:"token_signed_url_expiration_time"`
+
+	// @RO
+	// Indicates the JSON file where the revoked JWT are saved.
+	// If this is empty, revoked tokens are only kept in memory.
+	TokenRevocationFile string `json:"token_revocation_file"`
+
+	// @RO
+	// Indicates the JSON file where the refresh tokens are saved.
+	// If this is empty, refresh tokens are only kept in memory.
+	TokenRefreshFile string `json:"token_refresh_file"`
+
+	// @RO
+	// Indicates the JSON file where the app passwords of the users are saved.
+	// If this is empty, app passwords are only kept in memory.
+	AuthAppPasswordFile string `json:"auth_app_password_file"`
+
+	// @RO
+	// Indicates the JSON file where the TOTP secrets of the users enrolled in two-factor authentication are saved.
+	// If this is empty, enrollments are only kept in memory.
+	AuthTOTPFile string `json:"auth_totp_file"`
+
+	// @RO
+	// The name shown by the authenticator apps next to the account of the user.
+	// If this is empty, the default will be Syncato.
+	AuthTOTPIssuer string `json:"auth_totp_issuer"`
+
+	// @RW
+	// The ordered list of auth providers tried to authenticate a user when no auth provider ID is given,
+	// like with Basic Auth. Providers not in the list are not tried.
+	// If this is empty, all the providers are tried in the order they were registered.
+	AuthChain []AuthChainEntry `json:"auth_chain"`
+
+	// @RW
+	// Indicates what to do when an auth provider of the chain finds the user but rejects the password.
+	// Possible values: stop (the default) and continue.
+	AuthChainPolicy string `json:"auth_chain_policy"`
+
+	// @RW
+	// The number of failed authentication attempts for a username before it is locked out.
+	// If this is zero, the default will be 10. A negative value disables the lockout.
+	AuthLockoutThreshold int `json:"auth_lockout_threshold"`
+
+	// @RW
+	// The number of failed authentication attempts from an address before it is locked out.
+	// If this is zero, the default will be 100. A negative value disables the lockout.
+	AuthLockoutAddrThreshold int `json:"auth_lockout_addr_threshold"`
+
+	// @RW
+	// The duration in seconds of a lockout. Failed attempts are forgotten after this time without failures.
+	// If this is zero, the default will be 900.
+	AuthLockoutDuration int `json:"auth_lockout_duration"`
+
+	// @RW
+	// The maximum delay in seconds between two authentication attempts after failures.
+	// The delay starts at one second and doubles on every failure.
+	// If this is zero, the default will be 30.
+	AuthBackoffMaxDelay int `json:"auth_backoff_max_delay"`
+
+	// @RW
+	// The duration in seconds of the tokens obtained by administrators to impersonate a user.
+	// If this is zero, the default will be 900.
+	AuthImpersonationTime int `json:"auth_impersonation_time"`
+
+	// @RW
+	// The groups granting a role, by role name. Users in any of the groups have the role
+	// in addition to the roles given by their auth provider.
+	AuthzRoleGroups map[string][]string `json:"authz_role_groups"`
+
+	// @RW
+	// The roles required to use an API, by API ID. Users need at least one of the roles.
+	// APIs not in the map can be used by any authenticated user.
+	AuthzAPIRoles map[string][]string `json:"authz_api_roles"`
+
+	// @RW
+	// The groups required to access a storage, by storage scheme. Users need to be in at least one of the groups.
+	// Storages not in the map can be accessed by any authenticated user.
+	AuthzSchemeGroups map[string][]string `json:"authz_scheme_groups"`
+
 	// @RW
 	// Indicates if the user homedirectory must be created when the user log in
 	CreateUserHomeOnLogin bool `json:"create_user_home_on_login"`
 
 	// @RW
 	// If CreateUserHomeOnLogin is enabled indicates in which storages the home dir will be created.
+	// If this is empty, the home dir will be created in all storages.
 	CreateUserHomeInStorages []string `json:"create_user_home_in_storages"`
 
+	// @RW
+	// The directory with the files and collections copied to the new home dirs.
+	// If this is empty, the new home dirs are empty.
+	CreateUserHomeSkeletonDir string `json:"create_user_home_skeleton_dir"`
+
+	// @RW
+	// The quota in bytes set in StorageQuotas for the users when their home dir is created, so it
+	// does not change with StorageQuotaDefault. If this is zero, no quota is set.
+	CreateUserHomeQuota int64 `json:"create_user_home_quota"`
+
+	// @RO
+	// Indicates the JSON file where the provisioning steps done for every user are saved, so the steps that
+	// failed are done on the next login even after a restart.
+	// If this is empty, the steps are kept in memory only.
+	CreateUserHomeStateFile string `json:"create_user_home_state_file"`
+
 	// @RW
 	// Indicates if the server should validate the upload with the provided checksum and checksumtype
 	// sent by the client.
@@ -98,9 +269,183 @@ type ConfigParams struct {
 	// Indicates where temporary data will be saved.
 	RootTmpDir string `json:"root_tmp_dir"`
 
+	// @RO
+	// Indicates where the storages keep the metadata of the homes of the users, like the IDs of the
+	// resources and the change journals. The metadata of a home has the path of the home under this
+	// directory, outside the data of the users.
+	RootMetaDir string `json:"root_meta_dir"`
+
+	// @RO
+	// The layout of the home directories of the users, by storage scheme, as a template like
+	// {authid}/{username[0]}/{username}. See storage.HomeLayout for the placeholders.
+	// If a storage has no layout, the layout is storage.LegacyHomeLayout, {authid}/{username} with the values
+	// not escaped, so the homes created before the layouts were configurable keep their paths.
+	// Changing the layout of a storage with data needs the homes to be migrated.
+	StorageHomeLayouts map[string]string `json:"storage_home_layouts"`
+
+	// @RO
+	// The umask, in octal, applied to the modes of the files and directories created by the local storages.
+	// If this is empty, the umask will be 0022.
+	StorageLocalUmask string `json:"storage_local_umask"`
+
+	// @RO
+	// Indicates the JSON file where the public share links are saved.
+	// If this is empty, links are only kept in memory.
+	ShareLinkFile string `json:"share_link_file"`
+
+	// @RO
+	// Indicates the JSON file where the resources shared between users are saved.
+	// If this is empty, shares are only kept in memory.
+	ShareFile string `json:"share_file"`
+
+	// @RW
+	// The quota in bytes of the users without a quota in StorageQuotas.
+	// If this is zero, the users have no quota.
+	StorageQuotaDefault int64 `json:"storage_quota_default"`
+
+	// @RW
+	// The quota in bytes of some users, by AuthID/Username like ldap/alice, overriding StorageQuotaDefault.
+	// A negative quota means no limit.
+	StorageQuotas map[string]int64 `json:"storage_quotas"`
+
+	// @RO
+	// Indicates the JSON file where the space used by the users is saved.
+	// If this is empty, the usage is only kept in memory.
+	StorageQuotaUsageFile string `json:"storage_quota_usage_file"`
+
+	// @RO
+	// Indicates the JSON file where the locks of the resources are saved.
+	// If this is empty, locks are only kept in memory.
+	StorageLockFile string `json:"storage_lock_file"`
+
+	// @RW
+	// The maximum time in seconds a lock is valid before it must be refreshed. It is also the timeout
+	// of the locks created without a timeout.
+	// If this is zero, the maximum will be 604800 seconds (a week).
+	StorageLockMaxTimeout int `json:"storage_lock_max_timeout"`
+
+	// @RW
+	// The number of changes of the resources of a user kept in the change journal. When the journal grows to
+	// twice this size the oldest changes are dropped, and the clients with older cursors must sync the whole tree.
+	// If this is zero, 10000 changes will be kept.
+	StorageChangeJournalSize int `json:"storage_change_journal_size"`
+
+	// @RW
+	// The mount points of the namespace seen by the users, so clients use paths like /home/photos
+	// instead of storage URIs and storages can be moved without clients changing their paths.
+	StorageMounts []StorageMount `json:"storage_mounts"`
+
 	// @RO
 	// Indicates the JSON file to be used as an authentication backend.
 	AuthJSONFile string `json:"auth_json_file"`
+
+	// @RO
+	// Indicates the Apache htpasswd file to be used as an authentication backend.
+	// Supported password formats are bcrypt, SHA1 and APR1-MD5.
+	AuthHtpasswdFile string `json:"auth_htpasswd_file"`
+
+	// @RO
+	// Indicates the Apache htgroup file with the groups of the users in AuthHtpasswdFile.
+	// Every line has the format group: user1 user2.
+	AuthHtgroupFile string `json:"auth_htgroup_file"`
+
+	// @RO
+	// Indicates the PEM file with the certificate authorities used to validate client certificates.
+	AuthCertCAFile string `json:"auth_cert_ca_file"`
+
+	// @RO
+	// The rules to map a client certificate to a username. The first rule matching the certificate is used.
+	AuthCertRules []CertRule `json:"auth_cert_rules"`
+
+	// @RO
+	// The URL of the OpenID Connect issuer. The discovery document is fetched
+	// from <issuer>/.well-known/openid-configuration.
+	OIDCIssuer string `json:"oidc_issuer"`
+
+	// @RO
+	// The client ID registered in the OpenID Connect issuer.
+	// Bearer tokens must contain this value in the aud claim.
+	OIDCClientID string `json:"oidc_client_id"`
+
+	// @RO
+	// The client secret registered in the OpenID Connect issuer.
+	OIDCClientSecret string `json:"oidc_client_secret"`
+
+	// @RO
+	// The URL the OpenID Connect issuer redirects to after the user logs in.
+	OIDCRedirectURL string `json:"oidc_redirect_url"`
+
+	// @RO
+	// The scopes asked in the authorization code flow.
+	// If this is empty, the default scopes will be openid, profile and email.
+	OIDCScopes []string `json:"oidc_scopes"`
+
+	// @RO
+	// The claim used as the username.
+	// If this is empty, the default claim will be preferred_username.
+	OIDCUsernameClaim string `json:"oidc_username_claim"`
+
+	// @RO
+	// The claim with the groups of the user, a list of strings.
+	// If this is empty, the default claim will be groups.
+	OIDCGroupsClaim string `json:"oidc_groups_claim"`
+
+	// @RO
+	// The claim with the roles of the user, a list of strings.
+	// If this is empty, the default claim will be roles.
+	OIDCRolesClaim string `json:"oidc_roles_claim"`
+
+	// @RO
+	// The duration in seconds the issuer keys are cached before fetching them again.
+	// Keys with an unknown key ID trigger a refetch before this time.
+	// If this is zero, the default will be 3600.
+	OIDCJWKSCacheTime int `json:"oidc_jwks_cache_time"`
+}
+
+// AuthChainEntry represents an auth provider in the authentication chain.
+type AuthChainEntry struct {
+	// the auth provider ID.
+	ID string `json:"id"`
+
+	// if not empty, only usernames matching this regular expression are tried against the provider.
+	UsernamePattern string `json:"username_pattern"`
+
+	// if not empty, only usernames like user@realm are tried against the provider,
+	// and the provider receives the username without the realm.
+	Realm string `json:"realm"`
+}
+
+// StorageMount represents a mount point of the namespace of the users.
+type StorageMount struct {
+	// the path of the mount point in the namespace, like /home.
+	Path string `json:"path"`
+
+	// the scheme of the storage mounted.
+	Scheme string `json:"scheme"`
+
+	// the path in the storage mounted at the mount point. If this is empty, it is the root of the storage.
+	Prefix string `json:"prefix"`
+}
+
+// CertRule represents a rule to map a client certificate to a username.
+type CertRule struct {
+	// the certificate field matched: cn (subject common name), dn (subject distinguished name),
+	// email, dns or uri (subject alternative names).
+	Field string `json:"field"`
+
+	// the regular expression the field must match.
+	Pattern string `json:"pattern"`
+
+	// the username, that can reference the submatches of the pattern like $1.
+	// If this is empty, the username is the value of the field.
+	Username string `json:"username"`
+}
+
+// TokenKey represents an asymmetric key used to sign and verify the JWT.
+type TokenKey struct {
+	ID             string `json:"kid"`              // the key ID sent in the kid header of the JWT.
+	Alg            string `json:"alg"`              // the algorithm: RS256, ES256 or EdDSA.
+	PrivateKeyFile string `json:"private_key_file"` // the PEM encoded private key.
 }
 
 func New(filename string, log *logger.Logger) (*Config, error) {
@@ -207,9 +552,130 @@ func (c *Config) TokenSecret() string {
 func (c *Config) TokenCipherSuite() string {
 	return c.cfg.TokenCipherSuite
 }
+func (c *Config) TokenKeys() []TokenKey {
+	return c.cfg.TokenKeys
+}
+func (c *Config) TokenSigningKeyID() string {
+	return c.cfg.TokenSigningKeyID
+}
 func (c *Config) TokenISS() string {
 	return c.cfg.TokenISS
 }
+func (c *Config) TokenAUD() string {
+	return c.cfg.TokenAUD
+}
+func (c *Config) TokenExpirationTime() int {
+	if c.cfg.TokenExpirationTime == 0 {
+		return 3600
+	}
+	return c.cfg.TokenExpirationTime
+}
+func (c *Config) TokenRefreshExpirationTime() int {
+	if c.cfg.TokenRefreshExpirationTime == 0 {
+		return 2592000
+	}
+	return c.cfg.TokenRefreshExpirationTime
+}
+func (c *Config) TokenSignedURLExpirationTime() int {
+	if c.cfg.TokenSignedURLExpirationTime == 0 {
+		return 300
+	}
+	return c.cfg.TokenSignedURLExpirationTime
+}
+func (c *Config) TokenRevocationFile() string {
+	return c.cfg.TokenRevocationFile
+}
+func (c *Config) TokenRefreshFile() string {
+	return c.cfg.TokenRefreshFile
+}
+func (c *Config) AuthAppPasswordFile() string {
+	return c.cfg.AuthAppPasswordFile
+}
+func (c *Config) AuthTOTPFile() string {
+	return c.cfg.AuthTOTPFile
+}
+func (c *Config) AuthTOTPIssuer() string {
+	if c.cfg.AuthTOTPIssuer == "" {
+		return "Syncato"
+	}
+	return c.cfg.AuthTOTPIssuer
+}
+func (c *Config) AuthChain() []AuthChainEntry {
+	return c.cfg.AuthChain
+}
+func (c *Config) SetAuthChain(val []AuthChainEntry) error {
+	c.Lock()
+	c.cfg.AuthChain = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) AuthChainPolicy() string {
+	if c.cfg.AuthChainPolicy == "" {
+		return "stop"
+	}
+	return c.cfg.AuthChainPolicy
+}
+func (c *Config) AuthLockoutThreshold() int {
+	if c.cfg.AuthLockoutThreshold == 0 {
+		return 10
+	}
+	return c.cfg.AuthLockoutThreshold
+}
+func (c *Config) AuthLockoutAddrThreshold() int {
+	if c.cfg.AuthLockoutAddrThreshold == 0 {
+		return 100
+	}
+	return c.cfg.AuthLockoutAddrThreshold
+}
+func (c *Config) AuthLockoutDuration() int {
+	if c.cfg.AuthLockoutDuration == 0 {
+		return 900
+	}
+	return c.cfg.AuthLockoutDuration
+}
+func (c *Config) AuthBackoffMaxDelay() int {
+	if c.cfg.AuthBackoffMaxDelay == 0 {
+		return 30
+	}
+	return c.cfg.AuthBackoffMaxDelay
+}
+func (c *Config) AuthImpersonationTime() int {
+	if c.cfg.AuthImpersonationTime == 0 {
+		return 900
+	}
+	return c.cfg.AuthImpersonationTime
+}
+func (c *Config) AuthzRoleGroups() map[string][]string {
+	return c.cfg.AuthzRoleGroups
+}
+func (c *Config) SetAuthzRoleGroups(val map[string][]string) error {
+	c.Lock()
+	c.cfg.AuthzRoleGroups = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) AuthzAPIRoles() map[string][]string {
+	return c.cfg.AuthzAPIRoles
+}
+func (c *Config) SetAuthzAPIRoles(val map[string][]string) error {
+	c.Lock()
+	c.cfg.AuthzAPIRoles = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) AuthzSchemeGroups() map[string][]string {
+	return c.cfg.AuthzSchemeGroups
+}
+func (c *Config) SetAuthzSchemeGroups(val map[string][]string) error {
+	c.Lock()
+	c.cfg.AuthzSchemeGroups = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
 func (c *Config) CreateUserHomeOnLogin() bool {
 	return c.cfg.CreateUserHomeOnLogin
 }
@@ -230,6 +696,29 @@ func (c *Config) SetCreateUserHomeInStorages(val []string) error {
 	c.Unlock()
 	return err
 }
+func (c *Config) CreateUserHomeSkeletonDir() string {
+	return c.cfg.CreateUserHomeSkeletonDir
+}
+func (c *Config) SetCreateUserHomeSkeletonDir(val string) error {
+	c.Lock()
+	c.cfg.CreateUserHomeSkeletonDir = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) CreateUserHomeQuota() int64 {
+	return c.cfg.CreateUserHomeQuota
+}
+func (c *Config) SetCreateUserHomeQuota(val int64) error {
+	c.Lock()
+	c.cfg.CreateUserHomeQuota = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) CreateUserHomeStateFile() string {
+	return c.cfg.CreateUserHomeStateFile
+}
 func (c *Config) VerifyClientChecksum() bool {
 	return c.cfg.VerifyClientChecksum
 }
@@ -256,6 +745,156 @@ func (c *Config) RootDataDir() string {
 func (c *Config) RootTmpDir() string {
 	return c.cfg.RootTmpDir
 }
+func (c *Config) RootMetaDir() string {
+	return c.cfg.RootMetaDir
+}
+func (c *Config) StorageHomeLayouts() map[string]string {
+	return c.cfg.StorageHomeLayouts
+}
+func (c *Config) StorageLocalUmask() string {
+	if c.cfg.StorageLocalUmask == "" {
+		return "0022"
+	}
+	return c.cfg.StorageLocalUmask
+}
+func (c *Config) ShareLinkFile() string {
+	return c.cfg.ShareLinkFile
+}
+func (c *Config) ShareFile() string {
+	return c.cfg.ShareFile
+}
+func (c *Config) StorageQuotaDefault() int64 {
+	return c.cfg.StorageQuotaDefault
+}
+func (c *Config) SetStorageQuotaDefault(val int64) error {
+	c.Lock()
+	c.cfg.StorageQuotaDefault = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) StorageQuotas() map[string]int64 {
+	return c.cfg.StorageQuotas
+}
+func (c *Config) SetStorageQuotas(val map[string]int64) error {
+	c.Lock()
+	c.cfg.StorageQuotas = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+
+// AddStorageQuota sets the quota of the user with the key in StorageQuotas, unless it already has one.
+// The quotas are read and written under the lock, so concurrent calls for different users are all kept.
+func (c *Config) AddStorageQuota(key string, val int64) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.cfg.StorageQuotas[key]; ok {
+		return nil
+	}
+	quotas := map[string]int64{key: val}
+	for k, q := range c.cfg.StorageQuotas {
+		quotas[k] = q
+	}
+	c.cfg.StorageQuotas = quotas
+	return c.save()
+}
+func (c *Config) StorageQuotaUsageFile() string {
+	return c.cfg.StorageQuotaUsageFile
+}
+func (c *Config) StorageLockFile() string {
+	return c.cfg.StorageLockFile
+}
+func (c *Config) StorageLockMaxTimeout() int {
+	if c.cfg.StorageLockMaxTimeout == 0 {
+		return 604800
+	}
+	return c.cfg.StorageLockMaxTimeout
+}
+func (c *Config) SetStorageLockMaxTimeout(val int) error {
+	c.Lock()
+	c.cfg.StorageLockMaxTimeout = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) StorageChangeJournalSize() int {
+	if c.cfg.StorageChangeJournalSize == 0 {
+		return 10000
+	}
+	return c.cfg.StorageChangeJournalSize
+}
+func (c *Config) SetStorageChangeJournalSize(val int) error {
+	c.Lock()
+	c.cfg.StorageChangeJournalSize = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
+func (c *Config) StorageMounts() []StorageMount {
+	return c.cfg.StorageMounts
+}
+func (c *Config) SetStorageMounts(val []StorageMount) error {
+	c.Lock()
+	c.cfg.StorageMounts = val
+	err := c.save()
+	c.Unlock()
+	return err
+}
 func (c *Config) AuthJSONFile() string {
 	return c.cfg.AuthJSONFile
 }
+func (c *Config) AuthHtpasswdFile() string {
+	return c.cfg.AuthHtpasswdFile
+}
+func (c *Config) AuthHtgroupFile() string {
+	return c.cfg.AuthHtgroupFile
+}
+func (c *Config) AuthCertCAFile() string {
+	return c.cfg.AuthCertCAFile
+}
+func (c *Config) AuthCertRules() []CertRule {
+	return c.cfg.AuthCertRules
+}
+func (c *Config) OIDCIssuer() string {
+	return c.cfg.OIDCIssuer
+}
+func (c *Config) OIDCClientID() string {
+	return c.cfg.OIDCClientID
+}
+func (c *Config) OIDCClientSecret() string {
+	return c.cfg.OIDCClientSecret
+}
+func (c *Config) OIDCRedirectURL() string {
+	return c.cfg.OIDCRedirectURL
+}
+func (c *Config) OIDCScopes() []string {
+	if len(c.cfg.OIDCScopes) == 0 {
+		return []string{"openid", "profile", "email"}
+	}
+	return c.cfg.OIDCScopes
+}
+func (c *Config) OIDCUsernameClaim() string {
+	if c.cfg.OIDCUsernameClaim == "" {
+		return "preferred_username"
+	}
+	return c.cfg.OIDCUsernameClaim
+}
+func (c *Config) OIDCGroupsClaim() string {
+	if c.cfg.OIDCGroupsClaim == "" {
+		return "groups"
+	}
+	return c.cfg.OIDCGroupsClaim
+}
+func (c *Config) OIDCRolesClaim() string {
+	if c.cfg.OIDCRolesClaim == "" {
+		return "roles"
+	}
+	return c.cfg.OIDCRolesClaim
+}
+func (c *Config) OIDCJWKSCacheTime() int {
+	if c.cfg.OIDCJWKSCacheTime == 0 {
+		return 3600
+	}
+	return c.cfg.OIDCJWKSCacheTime
+}
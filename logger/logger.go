@@ -6,6 +6,7 @@
 package logger
 
 import (
+	"context"
 	"os"
 
 	"github.com/Sirupsen/logrus"
@@ -13,8 +14,9 @@ import (
 
 // Logger is responsible for log information to a target supported by the log implementation
 type Logger struct {
-	rid string         // the request id
-	log *logrus.Logger // the log implementation
+	rid    string                 // the request id
+	log    *logrus.Logger         // the log implementation
+	fields map[string]interface{} // the fields added to every log line
 }
 
 // NewLogger creates a logger instance with a custom log level
@@ -23,31 +25,63 @@ type Logger struct {
 func NewLogger(rid string, level int) *Logger {
 	logr := logrus.New()
 	logr.Level = logrus.Level(level)
-	log := Logger{rid, logr}
+	log := Logger{rid: rid, log: logr}
 	return &log
 }
 
+// WithFields returns a logger that adds the fields to every log line, besides the fields of this logger.
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{rid: l.rid, log: l.log, fields: merged}
+}
+
+// WithRequestID returns a logger that logs the request ID rid, with the same fields as this logger.
+func (l *Logger) WithRequestID(rid string) *Logger {
+	return &Logger{rid: rid, log: l.log, fields: l.fields}
+}
+
 func (l *Logger) Debug(msg interface{}, fields map[string]interface{}) {
-	host, _ := os.Hostname()
-	l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(fields).Debug(msg)
+	l.entry(fields).Debug(msg)
 }
 func (l *Logger) Info(msg interface{}, fields map[string]interface{}) {
-	host, _ := os.Hostname()
-	l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(fields).Info(msg)
+	l.entry(fields).Info(msg)
 }
 func (l *Logger) Warn(msg interface{}, fields map[string]interface{}) {
-	host, _ := os.Hostname()
-	l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(fields).Warn(msg)
+	l.entry(fields).Warn(msg)
 }
 func (l *Logger) Error(msg interface{}, fields map[string]interface{}) {
-	host, _ := os.Hostname()
-	l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(fields).Error(msg)
+	l.entry(fields).Error(msg)
 }
 func (l *Logger) Fatal(msg interface{}, fields map[string]interface{}) {
-	host, _ := os.Hostname()
-	l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(fields).Fatal(msg)
+	l.entry(fields).Fatal(msg)
 }
 func (l *Logger) Panic(msg interface{}, fields map[string]interface{}) {
+	l.entry(fields).Panic(msg)
+}
+
+func (l *Logger) entry(fields map[string]interface{}) *logrus.Entry {
 	host, _ := os.Hostname()
-	l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(fields).Panic(msg)
+	return l.log.WithField("RID", l.rid).WithField("HOST", host).WithFields(l.fields).WithFields(fields)
+}
+
+// contextKey is the type of the key of the logger in a context, so it does not collide with keys of other packages.
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger.
+func NewContext(ctx context.Context, log *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, log)
+}
+
+// FromContext returns the logger carried by the context, or log if the context does not carry a logger.
+func FromContext(ctx context.Context, log *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return log
 }
@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestContext(c *C) {
+	log := NewLogger("", 0)
+	c.Assert(FromContext(context.Background(), log), Equals, log)
+
+	withFields := log.WithFields(map[string]interface{}{"user": "alice"})
+	withRID := withFields.WithRequestID("abc")
+	c.Assert(FromContext(NewContext(context.Background(), withRID), log), Equals, withRID)
+	c.Assert(withRID.rid, Equals, "abc")
+	c.Assert(withRID.fields, DeepEquals, map[string]interface{}{"user": "alice"})
+	// the original loggers are not modified.
+	c.Assert(log.rid, Equals, "")
+	c.Assert(withFields.rid, Equals, "")
+}
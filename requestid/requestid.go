@@ -0,0 +1,61 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package requestid defines the identifier of a request, used to correlate the log lines of the
+// daemon and libraries serving the same request.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/syncato/lib/logger"
+)
+
+// Header is the HTTP header carrying the request ID.
+const Header = "X-Request-Id"
+
+// validID matches the request IDs accepted from clients, to avoid injecting arbitrary data in the logs.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// contextKey is the type of the key of the request ID in a context, so it does not collide with keys of other packages.
+type contextKey struct{}
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewContext returns a context carrying the request ID.
+func NewContext(ctx context.Context, rid string) context.Context {
+	return context.WithValue(ctx, contextKey{}, rid)
+}
+
+// FromContext returns the request ID carried by the context, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	rid, ok := ctx.Value(contextKey{}).(string)
+	return rid, ok
+}
+
+// NewMiddleware returns an HTTP middleware that saves the request ID in the request context and sends it back
+// in the response header. The ID sent by the client in the X-Request-Id header is used if it is valid,
+// otherwise a new one is created.
+// The context also carries log with the request ID, see logger.FromContext.
+func NewMiddleware(log *logger.Logger) func(ctx context.Context, w http.ResponseWriter, r *http.Request, next func(ctx context.Context, w http.ResponseWriter, r *http.Request)) {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, next func(ctx context.Context, w http.ResponseWriter, r *http.Request)) {
+		rid := r.Header.Get(Header)
+		if !validID.MatchString(rid) {
+			rid = New()
+		}
+		w.Header().Set(Header, rid)
+		ctx = NewContext(ctx, rid)
+		ctx = logger.NewContext(ctx, logger.FromContext(ctx, log).WithRequestID(rid))
+		next(ctx, w, r)
+	}
+}
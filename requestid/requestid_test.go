@@ -0,0 +1,55 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/syncato/lib/logger"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestContext(c *C) {
+	_, ok := FromContext(context.Background())
+	c.Assert(ok, Equals, false)
+	rid, ok := FromContext(NewContext(context.Background(), "abc"))
+	c.Assert(ok, Equals, true)
+	c.Assert(rid, Equals, "abc")
+}
+
+func (s *TestSuite) TestMiddleware(c *C) {
+	log := logger.NewLogger("", 0)
+	middleware := NewMiddleware(log)
+	var got string
+	var gotLog *logger.Logger
+	next := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(ctx)
+		gotLog = logger.FromContext(ctx, nil)
+	}
+
+	// a valid ID sent by the client is kept.
+	r, _ := http.NewRequest("GET", "/api/files/", nil)
+	r.Header.Set(Header, "client-id.1")
+	w := httptest.NewRecorder()
+	middleware(context.Background(), w, r, next)
+	c.Assert(got, Equals, "client-id.1")
+	c.Assert(w.Header().Get(Header), Equals, "client-id.1")
+	c.Assert(gotLog, NotNil)
+	c.Assert(gotLog, Not(Equals), log)
+
+	// an invalid ID is replaced.
+	r.Header.Set(Header, "bad id\n")
+	w = httptest.NewRecorder()
+	middleware(context.Background(), w, r, next)
+	c.Assert(validID.MatchString(got), Equals, true)
+	c.Assert(got, Not(Equals), "bad id\n")
+	c.Assert(w.Header().Get(Header), Equals, got)
+}
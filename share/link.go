@@ -0,0 +1,255 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package share defines how users share their resources with other people.
+package share
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	authtoken "github.com/syncato/lib/auth/token"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The permissions of a public link.
+const (
+	LinkRead      = "read"       // download the resource, or list and download the resources of a collection.
+	LinkUpload    = "upload"     // upload new files to a collection without seeing its contents.
+	LinkReadWrite = "read-write" // read, upload, overwrite and remove the resources of a collection.
+)
+
+// LinkNotFoundError is returned when a link does not exist, has expired or has reached its download limit.
+type LinkNotFoundError struct {
+	Token string
+}
+
+func (e *LinkNotFoundError) Error() string { return fmt.Sprintf("link %s not found", e.Token) }
+
+// InvalidLinkPasswordError is returned when the password of a link protected by password is missing or wrong.
+type InvalidLinkPasswordError struct {
+	Token string
+}
+
+func (e *InvalidLinkPasswordError) Error() string {
+	return fmt.Sprintf("invalid password for link %s", e.Token)
+}
+
+// Link is a public link to a resource of a user, anyone with the link can access the resource
+// with its permission, acting as the owner of the resource.
+type Link struct {
+	Token        string             `json:"token"`         // the public ID of the link, part of its URL.
+	Owner        *auth.AuthResource `json:"-"`             // the user that created the link.
+	URI          string             `json:"uri"`           // the storage URI of the resource, like local://photos.
+	IsCol        bool               `json:"iscol"`         // if the resource is a collection.
+	Permission   string             `json:"permission"`    // LinkRead, LinkUpload or LinkReadWrite.
+	HasPassword  bool               `json:"has_password"`  // if the link is protected by a password.
+	Expires      int64              `json:"expires"`       // the expiration time in unix seconds, or zero.
+	MaxDownloads int                `json:"max_downloads"` // the number of downloads allowed, or zero for no limit.
+	Downloads    int                `json:"downloads"`     // the number of downloads.
+	Created      int64              `json:"created"`       // the creation time in unix seconds.
+}
+
+// LinkOptions are the options to create a link.
+type LinkOptions struct {
+	Permission   string    // LinkRead if empty.
+	Password     string    // the password of the link, or empty.
+	Expires      time.Time // the expiration time, or the zero time.
+	MaxDownloads int       // the number of downloads allowed, or zero for no limit.
+}
+
+// storedLink is the persisted form of a Link, including its owner and password hash.
+type storedLink struct {
+	Link
+	Owner        *auth.AuthResource `json:"owner"`
+	PasswordHash string             `json:"password_hash"`
+}
+
+// LinkStore keeps the public links created by the users.
+// The store is persisted to a JSON file after every change.
+type LinkStore struct {
+	sync.Mutex
+	filename string
+	links    map[string]*storedLink // token => link
+}
+
+// NewLinkStore creates a LinkStore loading the links from the file.
+// If filename is empty the store is kept in memory only.
+func NewLinkStore(filename string) (*LinkStore, error) {
+	s := &LinkStore{filename: filename, links: map[string]*storedLink{}}
+	if err := authtoken.LoadJSON(filename, &s.links); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create creates a link to the resource with the storage URI of the owner.
+// The owner must be the user that can access the resource, and isCol tells if it is a collection.
+// Links cannot be created while impersonating a user, and the link only keeps the identity of the owner,
+// not the restrictions of the credentials used to create it, see auth.AuthResource.Identity.
+func (s *LinkStore) Create(owner *auth.AuthResource, uri string, isCol bool, opts *LinkOptions) (*Link, error) {
+	if opts == nil {
+		opts = &LinkOptions{}
+	}
+	perm := opts.Permission
+	if perm == "" {
+		perm = LinkRead
+	}
+	if perm != LinkRead && perm != LinkUpload && perm != LinkReadWrite {
+		return nil, errors.New(fmt.Sprintf("link permission '%s' is not supported", perm))
+	}
+	if perm != LinkRead && !isCol {
+		return nil, errors.New(fmt.Sprintf("link permission '%s' requires a collection", perm))
+	}
+	if owner.Actor != "" {
+		return nil, errors.New("links cannot be created while impersonating a user")
+	}
+	if owner.Scope.IsSignedURL() {
+		return nil, errors.New("signed url credentials cannot create links")
+	}
+	if perm != LinkRead && !owner.Scope.AllowsWrite() {
+		return nil, errors.New("read only credentials cannot create writable links")
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return nil, errors.New(fmt.Sprintf("'%s' is not a storage uri", uri))
+	}
+	if !owner.Scope.AllowsScheme(u.Scheme) {
+		return nil, errors.New(fmt.Sprintf("credentials cannot create links to storage %s", u.Scheme))
+	}
+
+	token, err := authtoken.NewID()
+	if err != nil {
+		return nil, err
+	}
+	owner = owner.Identity()
+	sl := &storedLink{Link: Link{
+		Token:        token,
+		Owner:        owner,
+		URI:          uri,
+		IsCol:        isCol,
+		Permission:   perm,
+		MaxDownloads: opts.MaxDownloads,
+		Created:      time.Now().Unix(),
+	}, Owner: owner}
+	if !opts.Expires.IsZero() {
+		sl.Expires = opts.Expires.Unix()
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		sl.PasswordHash = string(hash)
+		sl.HasPassword = true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.links[token] = sl
+	if err := authtoken.SaveJSON(s.filename, s.links); err != nil {
+		delete(s.links, token)
+		return nil, err
+	}
+	return sl.link(), nil
+}
+
+// List returns the links of the user sorted by creation time.
+func (s *LinkStore) List(owner *auth.AuthResource) []*Link {
+	s.Lock()
+	defer s.Unlock()
+	list := []*Link{}
+	for _, sl := range s.links {
+		if sameUser(sl.Owner, owner) {
+			list = append(list, sl.link())
+		}
+	}
+	sort.Sort(byCreated(list))
+	return list
+}
+
+// Delete removes the link with the token of the user.
+func (s *LinkStore) Delete(owner *auth.AuthResource, token string) error {
+	s.Lock()
+	defer s.Unlock()
+	sl, ok := s.links[token]
+	if !ok || !sameUser(sl.Owner, owner) {
+		return &LinkNotFoundError{Token: token}
+	}
+	delete(s.links, token)
+	return authtoken.SaveJSON(s.filename, s.links)
+}
+
+// Open returns the link with the token if it is valid and the password is right.
+// The password is ignored if the link is not protected by password.
+func (s *LinkStore) Open(token, password string) (*Link, error) {
+	s.Lock()
+	defer s.Unlock()
+	sl, err := s.get(token)
+	if err != nil {
+		return nil, err
+	}
+	if sl.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(sl.PasswordHash), []byte(password)) != nil {
+		return nil, &InvalidLinkPasswordError{Token: token}
+	}
+	return sl.link(), nil
+}
+
+// RecordDownload counts a download of the link, it fails if the download limit has been reached.
+func (s *LinkStore) RecordDownload(token string) error {
+	s.Lock()
+	defer s.Unlock()
+	sl, err := s.get(token)
+	if err != nil {
+		return err
+	}
+	sl.Downloads++
+	return authtoken.SaveJSON(s.filename, s.links)
+}
+
+// get returns a valid link. It must be called with the lock held.
+func (s *LinkStore) get(token string) (*storedLink, error) {
+	sl, ok := s.links[token]
+	if !ok {
+		return nil, &LinkNotFoundError{Token: token}
+	}
+	if sl.Expires != 0 && time.Now().Unix() > sl.Expires {
+		return nil, &LinkNotFoundError{Token: token}
+	}
+	if sl.MaxDownloads != 0 && sl.Downloads >= sl.MaxDownloads {
+		return nil, &LinkNotFoundError{Token: token}
+	}
+	return sl, nil
+}
+
+// link returns a copy of the link with its owner.
+func (sl *storedLink) link() *Link {
+	l := sl.Link
+	l.Owner = sl.Owner
+	return &l
+}
+
+// IsLinkNotFoundError checks if the error is a LinkNotFoundError.
+func IsLinkNotFoundError(err error) bool {
+	_, ok := err.(*LinkNotFoundError)
+	return ok
+}
+
+// IsInvalidLinkPasswordError checks if the error is an InvalidLinkPasswordError.
+func IsInvalidLinkPasswordError(err error) bool {
+	_, ok := err.(*InvalidLinkPasswordError)
+	return ok
+}
+
+type byCreated []*Link
+
+func (l byCreated) Len() int           { return len(l) }
+func (l byCreated) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byCreated) Less(i, j int) bool { return l[i].Created < l[j].Created }
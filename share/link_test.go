@@ -0,0 +1,84 @@
+package share
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncato/lib/auth"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type LinkSuite struct {
+	filename string
+	alice    *auth.AuthResource
+}
+
+var _ = Suite(&LinkSuite{})
+
+func (s *LinkSuite) SetUpTest(c *C) {
+	s.filename = filepath.Join(c.MkDir(), "links.json")
+	s.alice = &auth.AuthResource{Username: "alice", AuthID: "json"}
+}
+
+func (s *LinkSuite) TestCreateAndOpen(c *C) {
+	store, err := NewLinkStore(s.filename)
+	c.Assert(err, IsNil)
+
+	_, err = store.Create(s.alice, "local://photos/beach.png", false, &LinkOptions{Permission: LinkUpload})
+	c.Assert(err, NotNil)
+	signed := &auth.AuthResource{Username: "alice", AuthID: "json", Scope: &auth.Scope{SignedURLPath: "/api/links", SignedURLOp: "upload"}}
+	_, err = store.Create(signed, "local://photos", true, nil)
+	c.Assert(err, NotNil)
+	impersonated := &auth.AuthResource{Username: "alice", AuthID: "json", Actor: "root"}
+	_, err = store.Create(impersonated, "local://photos", true, nil)
+	c.Assert(err, NotNil)
+
+	// the link does not keep the scope of the credentials used to create it.
+	scoped := &auth.AuthResource{Username: "alice", AuthID: "json", Scope: &auth.Scope{Schemes: []string{"local"}}}
+	link, err := store.Create(scoped, "local://photos", true, &LinkOptions{Password: "s3cret"})
+	c.Assert(err, IsNil)
+	c.Assert(link.Permission, Equals, LinkRead)
+	c.Assert(link.HasPassword, Equals, true)
+
+	_, err = store.Open(link.Token, "wrong")
+	c.Assert(IsInvalidLinkPasswordError(err), Equals, true)
+
+	// links are persisted.
+	store, err = NewLinkStore(s.filename)
+	c.Assert(err, IsNil)
+	opened, err := store.Open(link.Token, "s3cret")
+	c.Assert(err, IsNil)
+	c.Assert(opened.Owner.Username, Equals, "alice")
+	c.Assert(opened.Owner.Scope, IsNil)
+	c.Assert(opened.URI, Equals, "local://photos")
+	c.Assert(store.List(s.alice), HasLen, 1)
+
+	// a user with the same name in another auth provider does not own the link.
+	otherAlice := &auth.AuthResource{Username: "alice", AuthID: "ldap"}
+	c.Assert(store.List(otherAlice), HasLen, 0)
+	c.Assert(IsLinkNotFoundError(store.Delete(otherAlice, link.Token)), Equals, true)
+	c.Assert(store.Delete(&auth.AuthResource{Username: "bob", AuthID: "json"}, link.Token), NotNil)
+	c.Assert(store.Delete(s.alice, link.Token), IsNil)
+	_, err = store.Open(link.Token, "s3cret")
+	c.Assert(IsLinkNotFoundError(err), Equals, true)
+}
+
+func (s *LinkSuite) TestLimits(c *C) {
+	store, err := NewLinkStore("")
+	c.Assert(err, IsNil)
+
+	link, err := store.Create(s.alice, "local://report.pdf", false, &LinkOptions{MaxDownloads: 1})
+	c.Assert(err, IsNil)
+	c.Assert(store.RecordDownload(link.Token), IsNil)
+	_, err = store.Open(link.Token, "")
+	c.Assert(IsLinkNotFoundError(err), Equals, true)
+
+	link, err = store.Create(s.alice, "local://report.pdf", false, &LinkOptions{Expires: time.Now().Add(-time.Minute)})
+	c.Assert(err, IsNil)
+	_, err = store.Open(link.Token, "")
+	c.Assert(IsLinkNotFoundError(err), Equals, true)
+}
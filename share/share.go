@@ -0,0 +1,231 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package share
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	authtoken "github.com/syncato/lib/auth/token"
+	"github.com/syncato/lib/storage"
+)
+
+// The types of recipients of a share.
+const (
+	RecipientUser  = "user"  // the share is for one user.
+	RecipientGroup = "group" // the share is for all the users in a group.
+)
+
+// ShareNotFoundError is returned when a share does not exist or it does not belong to the user.
+type ShareNotFoundError struct {
+	ID string
+}
+
+func (e *ShareNotFoundError) Error() string { return fmt.Sprintf("share %s not found", e.ID) }
+
+// Share is a resource of a user shared with another user or with a group.
+// The recipients access the resource as the owner, restricted by the permissions of the share.
+type Share struct {
+	ID              string               `json:"id"`                // the ID of the share.
+	Owner           *auth.AuthResource   `json:"-"`                 // the user sharing the resource.
+	URI             string               `json:"uri"`               // the storage URI of the resource, like local://photos.
+	IsCol           bool                 `json:"iscol"`             // if the resource is a collection.
+	RecipientType   string               `json:"recipient_type"`    // RecipientUser or RecipientGroup.
+	Recipient       string               `json:"recipient"`         // the username or the group name.
+	RecipientAuthID string               `json:"recipient_auth_id"` // the ID of the auth provider of the recipient.
+	Permissions     *storage.Permissions `json:"permissions"`       // what the recipients can do.
+	Created         int64                `json:"created"`           // the creation time in unix seconds.
+}
+
+// storedShare is the persisted form of a Share, including its owner.
+type storedShare struct {
+	Share
+	Owner *auth.AuthResource `json:"owner"`
+}
+
+// ShareStore keeps the resources shared by the users with other users.
+// It implements the storage.ShareResolver interface so the recipients can access the resources
+// through the storage multiplexer. The store is persisted to a JSON file after every change.
+type ShareStore struct {
+	sync.Mutex
+	filename string
+	shares   map[string]*storedShare // id => share
+}
+
+// NewShareStore creates a ShareStore loading the shares from the file.
+// If filename is empty the store is kept in memory only.
+func NewShareStore(filename string) (*ShareStore, error) {
+	s := &ShareStore{filename: filename, shares: map[string]*storedShare{}}
+	if err := authtoken.LoadJSON(filename, &s.shares); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create shares the resource with the storage URI of the owner with a user or a group of the auth provider
+// with the ID recipientAuthID, or of the auth provider of the owner if it is empty.
+// The permissions must include read, and write and delete can only be granted by owners with write access.
+func (s *ShareStore) Create(owner *auth.AuthResource, uri string, isCol bool, recipientType, recipient, recipientAuthID string, perm *storage.Permissions) (*Share, error) {
+	if recipientType != RecipientUser && recipientType != RecipientGroup {
+		return nil, errors.New(fmt.Sprintf("recipient type '%s' is not supported", recipientType))
+	}
+	if recipientAuthID == "" {
+		recipientAuthID = owner.AuthID
+	}
+	if recipient == "" || (recipientType == RecipientUser && recipient == owner.Username && recipientAuthID == owner.AuthID) {
+		return nil, errors.New(fmt.Sprintf("invalid recipient '%s'", recipient))
+	}
+	if perm == nil || !perm.Read {
+		return nil, errors.New("shares must grant read permission")
+	}
+	if owner.Actor != "" {
+		return nil, errors.New("shares cannot be created while impersonating a user")
+	}
+	if owner.Scope.IsSignedURL() {
+		return nil, errors.New("signed url credentials cannot create shares")
+	}
+	if (perm.Write || perm.Delete) && !owner.Scope.AllowsWrite() {
+		return nil, errors.New("read only credentials cannot grant write or delete permission")
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Scheme == storage.SharedScheme {
+		return nil, errors.New(fmt.Sprintf("'%s' is not a storage uri", uri))
+	}
+
+	id, err := authtoken.NewID()
+	if err != nil {
+		return nil, err
+	}
+	p := *perm
+	ss := &storedShare{Share: Share{
+		ID:              id,
+		URI:             uri,
+		IsCol:           isCol,
+		RecipientType:   recipientType,
+		Recipient:       recipient,
+		RecipientAuthID: recipientAuthID,
+		Permissions:     &p,
+		Created:         time.Now().Unix(),
+	}, Owner: owner.Identity()}
+
+	s.Lock()
+	defer s.Unlock()
+	s.shares[id] = ss
+	if err := authtoken.SaveJSON(s.filename, s.shares); err != nil {
+		delete(s.shares, id)
+		return nil, err
+	}
+	return ss.share(), nil
+}
+
+// List returns the shares created by the user sorted by creation time.
+func (s *ShareStore) List(owner *auth.AuthResource) []*Share {
+	s.Lock()
+	defer s.Unlock()
+	list := []*Share{}
+	for _, ss := range s.shares {
+		if sameUser(ss.Owner, owner) {
+			list = append(list, ss.share())
+		}
+	}
+	sort.Sort(sharesByCreated(list))
+	return list
+}
+
+// Received returns the shares with the user, directly or through its groups, sorted by creation time.
+func (s *ShareStore) Received(authRes *auth.AuthResource) []*Share {
+	s.Lock()
+	defer s.Unlock()
+	list := []*Share{}
+	for _, ss := range s.shares {
+		if ss.isRecipient(authRes) {
+			list = append(list, ss.share())
+		}
+	}
+	sort.Sort(sharesByCreated(list))
+	return list
+}
+
+// Delete removes the share with the id created by the user.
+func (s *ShareStore) Delete(owner *auth.AuthResource, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	ss, ok := s.shares[id]
+	if !ok || !sameUser(ss.Owner, owner) {
+		return &ShareNotFoundError{ID: id}
+	}
+	delete(s.shares, id)
+	return authtoken.SaveJSON(s.filename, s.shares)
+}
+
+// ResolveShare returns the resource with the id shared with the user.
+func (s *ShareStore) ResolveShare(authRes *auth.AuthResource, id string) (*storage.SharedResource, error) {
+	s.Lock()
+	defer s.Unlock()
+	ss, ok := s.shares[id]
+	if !ok || !ss.isRecipient(authRes) {
+		return nil, &storage.NotExistError{Err: fmt.Sprintf("share %s not found", id)}
+	}
+	return ss.resource(), nil
+}
+
+// ListShares returns the resources shared with the user.
+func (s *ShareStore) ListShares(authRes *auth.AuthResource) []*storage.SharedResource {
+	list := []*storage.SharedResource{}
+	for _, sh := range s.Received(authRes) {
+		list = append(list, (&storedShare{Share: *sh, Owner: sh.Owner}).resource())
+	}
+	return list
+}
+
+// isRecipient checks if the resource is shared with the user. The users and the groups of different auth
+// providers are different, even if they have the same name.
+// Users never receive their own shares, even if they are in the group.
+func (ss *storedShare) isRecipient(authRes *auth.AuthResource) bool {
+	if sameUser(ss.Owner, authRes) {
+		return false
+	}
+	if ss.RecipientAuthID != authRes.AuthID {
+		return false
+	}
+	if ss.RecipientType == RecipientGroup {
+		return authRes.HasGroup(ss.Recipient)
+	}
+	return ss.Recipient == authRes.Username
+}
+
+// sameUser checks if both users are the same user of the same auth provider.
+func sameUser(a, b *auth.AuthResource) bool {
+	return a.Username == b.Username && a.AuthID == b.AuthID
+}
+
+// share returns a copy of the share with its owner.
+func (ss *storedShare) share() *Share {
+	sh := ss.Share
+	sh.Owner = ss.Owner
+	return &sh
+}
+
+func (ss *storedShare) resource() *storage.SharedResource {
+	perm := *ss.Permissions
+	return &storage.SharedResource{ID: ss.ID, Owner: ss.Owner, URI: ss.URI, IsCol: ss.IsCol, Permissions: &perm}
+}
+
+// IsShareNotFoundError checks if the error is a ShareNotFoundError.
+func IsShareNotFoundError(err error) bool {
+	_, ok := err.(*ShareNotFoundError)
+	return ok
+}
+
+type sharesByCreated []*Share
+
+func (l sharesByCreated) Len() int           { return len(l) }
+func (l sharesByCreated) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l sharesByCreated) Less(i, j int) bool { return l[i].Created < l[j].Created }
@@ -0,0 +1,105 @@
+package share
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+	"github.com/syncato/lib/storage/storagetest"
+
+	. "gopkg.in/check.v1"
+)
+
+type ShareSuite struct {
+	store      *ShareStore
+	fs         *storagetest.MemStorage
+	storageMux *storagemux.StorageMux
+	alice      *auth.AuthResource
+	bob        *auth.AuthResource
+}
+
+var _ = Suite(&ShareSuite{})
+
+func (s *ShareSuite) SetUpTest(c *C) {
+	var err error
+	s.store, err = NewShareStore("")
+	c.Assert(err, IsNil)
+	s.fs = storagetest.NewMemStorage("local")
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(`{}`), 0600), IsNil)
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	s.storageMux, err = storagemux.NewStorageMux(cfg, log)
+	c.Assert(err, IsNil)
+	c.Assert(s.storageMux.AddStorageProvider(s.fs), IsNil)
+	s.storageMux.SetShareResolver(s.store)
+	s.alice = &auth.AuthResource{Username: "alice", AuthID: "json"}
+	s.bob = &auth.AuthResource{Username: "bob", AuthID: "json", Groups: []string{"staff"}}
+	ctx := context.Background()
+	c.Assert(s.fs.CreateUserHome(ctx, s.alice), IsNil)
+	c.Assert(s.storageMux.CreateCol(ctx, s.alice, "local:///beach", false), IsNil)
+	s.fs.Ops = nil
+}
+
+func (s *ShareSuite) TestCreate(c *C) {
+	_, err := s.store.Create(s.alice, "local://photos", true, RecipientUser, "alice", "", &storage.Permissions{Read: true})
+	c.Assert(err, NotNil)
+	_, err = s.store.Create(s.alice, "local://photos", true, RecipientUser, "bob", "", &storage.Permissions{Write: true})
+	c.Assert(err, NotNil)
+	_, err = s.store.Create(s.alice, "shared://x", true, RecipientUser, "bob", "", &storage.Permissions{Read: true})
+	c.Assert(err, NotNil)
+	signed := &auth.AuthResource{Username: "alice", AuthID: "json", Scope: &auth.Scope{SignedURLPath: "/api/shares", SignedURLOp: "upload"}}
+	_, err = s.store.Create(signed, "local://photos", true, RecipientUser, "bob", "", &storage.Permissions{Read: true})
+	c.Assert(err, NotNil)
+
+	impersonated := &auth.AuthResource{Username: "alice", AuthID: "json", Actor: "admin"}
+	_, err = s.store.Create(impersonated, "local://photos", true, RecipientUser, "bob", "", &storage.Permissions{Read: true})
+	c.Assert(err, NotNil)
+
+	// only the identity of the owner is kept, not the scope of its credentials.
+	scoped := &auth.AuthResource{Username: "alice", AuthID: "json", Scope: &auth.Scope{ReadOnly: true}}
+	sh, err := s.store.Create(scoped, "local://photos", true, RecipientGroup, "staff", "", &storage.Permissions{Read: true})
+	c.Assert(err, IsNil)
+	c.Assert(sh.Owner.Scope, IsNil)
+	c.Assert(s.store.List(s.alice), HasLen, 1)
+	c.Assert(s.store.Received(s.bob), HasLen, 1)
+	c.Assert(s.store.Received(s.alice), HasLen, 0)
+	// users and groups with the same name in other auth providers are not recipients nor owners.
+	c.Assert(s.store.Received(&auth.AuthResource{Username: "bob", AuthID: "ldap", Groups: []string{"staff"}}), HasLen, 0)
+	otherAlice := &auth.AuthResource{Username: "alice", AuthID: "ldap"}
+	c.Assert(s.store.List(otherAlice), HasLen, 0)
+	c.Assert(IsShareNotFoundError(s.store.Delete(otherAlice, sh.ID)), Equals, true)
+	c.Assert(IsShareNotFoundError(s.store.Delete(s.bob, sh.ID)), Equals, true)
+	c.Assert(s.store.Delete(s.alice, sh.ID), IsNil)
+	c.Assert(s.store.Received(s.bob), HasLen, 0)
+}
+
+func (s *ShareSuite) TestStorageMux(c *C) {
+	ctx := context.Background()
+	sh, err := s.store.Create(s.alice, "local://photos", true, RecipientUser, "bob", "", &storage.Permissions{Read: true, Write: true})
+	c.Assert(err, IsNil)
+
+	root, err := s.storageMux.Stat(ctx, s.bob, "shared://", true)
+	c.Assert(err, IsNil)
+	c.Assert(root.Children, HasLen, 1)
+	c.Assert(root.Children[0].Path, Equals, "shared://"+sh.ID)
+
+	meta, err := s.storageMux.Stat(ctx, s.bob, "shared://"+sh.ID+"/beach", false)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Path, Equals, "shared://"+sh.ID+"/beach")
+
+	c.Assert(s.storageMux.PutFile(ctx, s.bob, "shared://"+sh.ID+"/beach/sun.png", strings.NewReader(""), 0), IsNil)
+	err = s.storageMux.Remove(ctx, s.bob, "shared://"+sh.ID+"/beach/sun.png", false)
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+	_, err = s.storageMux.Stat(ctx, s.alice, "shared://"+sh.ID, false)
+	c.Assert(storage.IsNotExistError(err), Equals, true)
+
+	c.Assert(s.fs.Ops, DeepEquals, []string{"stat alice /beach", "put alice /beach/sun.png"})
+}
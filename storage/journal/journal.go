@@ -0,0 +1,248 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package journal defines the change journal used by the storage providers to record the changes of the
+// resources of the users, so sync clients can ask for the changes since their last sync instead of
+// stating the whole tree.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/atomicfile"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/storage"
+)
+
+// Journal keeps the changes of the resources of every user in an append only file, a JSON change per line.
+// The cursors of the changes have the epoch of the file, the time in seconds when its first change was
+// recorded, in the high 32 bits and the number of the change, starting at 1, in the low 32 bits. So the
+// cursors of a file that has been removed are expired in the new file, instead of pointing to other changes.
+// The epoch is in whole seconds, so a file removed and created again within the same second reuses the
+// cursors of the removed file.
+// When a file has twice the StorageChangeJournalSize of the configuration, the oldest changes are dropped.
+type Journal struct {
+	mu    sync.Mutex
+	files map[string]*fileState // filename => state
+	cfg   *config.Config
+}
+
+const (
+	// epochShift is the position of the epoch of the file in the cursors, see Journal.
+	epochShift = 32
+	// indexInterval is the number of changes between the offsets kept in the index of a file.
+	indexInterval = 64
+)
+
+// fileState is the range of cursors of the changes in a file and the index of their offsets.
+type fileState struct {
+	oldest uint64  // the cursor before the first change kept, the changes after it are complete.
+	latest uint64  // the cursor of the last change.
+	count  int     // the number of changes in the file.
+	size   int64   // the size of the file.
+	index  []int64 // the offsets of the changes 0, indexInterval, 2*indexInterval... of the file.
+}
+
+// NewJournal returns a Journal object.
+func NewJournal(cfg *config.Config) *Journal {
+	return &Journal{files: map[string]*fileState{}, cfg: cfg}
+}
+
+// Append records the changes in the file, setting their cursors.
+func (j *Journal) Append(filename string, changes ...*storage.Change) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st, err := j.state(filename)
+	if err != nil {
+		return err
+	}
+	if st.latest == 0 {
+		st.oldest = uint64(time.Now().Unix()) << epochShift
+		st.latest = st.oldest
+	}
+	var lines []string
+	for i, change := range changes {
+		change.Cursor = st.latest + uint64(i) + 1
+		data, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(data)+"\n")
+	}
+	fd, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fd.WriteString(strings.Join(lines, ""))
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// the file could have a partial line, it is removed when the file is read again on the next use.
+		delete(j.files, filename)
+		return err
+	}
+	for _, line := range lines {
+		st.add(int64(len(line)))
+	}
+	st.latest += uint64(len(changes))
+	if st.count < 2*j.cfg.StorageChangeJournalSize() {
+		return nil
+	}
+	return j.compact(filename, st)
+}
+
+// Since returns at most limit changes after the cursor. A cursor of 0 returns the changes since the first
+// change of the file, unless it has been dropped. It returns a CursorExpiredError if the changes after the
+// cursor have been dropped or the cursor is after the last change.
+func (j *Journal) Since(filename string, cursor uint64, limit int) (*storage.ChangeList, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st, err := j.state(filename)
+	if err != nil {
+		return nil, err
+	}
+	if cursor == 0 && st.oldest&(1<<epochShift-1) == 0 {
+		cursor = st.oldest
+	}
+	if cursor < st.oldest || cursor > st.latest {
+		return nil, &storage.CursorExpiredError{Cursor: cursor, Latest: st.latest}
+	}
+	list := &storage.ChangeList{Changes: []*storage.Change{}, Cursor: cursor}
+	if cursor == st.latest {
+		return list, nil
+	}
+	offset := st.index[int(cursor-st.oldest)/indexInterval]
+	_, _, err = readChanges(filename, offset, func(change *storage.Change, size int64) bool {
+		if change.Cursor <= cursor {
+			return true
+		}
+		if len(list.Changes) == limit {
+			list.HasMore = true
+			return false
+		}
+		list.Changes = append(list.Changes, change)
+		list.Cursor = change.Cursor
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// state returns the state of the file, reading it the first time.
+// A partial last line, left by a failed write, is removed so the next changes start on a new line.
+func (j *Journal) state(filename string) (*fileState, error) {
+	if st, ok := j.files[filename]; ok {
+		return st, nil
+	}
+	st := &fileState{}
+	end, partial, err := readChanges(filename, 0, func(change *storage.Change, size int64) bool {
+		if st.count == 0 {
+			st.oldest = change.Cursor - 1
+		}
+		st.latest = change.Cursor
+		st.add(size)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if partial {
+		if err := os.Truncate(filename, end); err != nil {
+			return nil, err
+		}
+	}
+	j.files[filename] = st
+	return st, nil
+}
+
+// compact drops the oldest changes of the file, keeping StorageChangeJournalSize changes.
+func (j *Journal) compact(filename string, st *fileState) error {
+	skip := st.count - j.cfg.StorageChangeJournalSize()
+	first := st.oldest + uint64(skip) + 1
+	var kept []*storage.Change
+	_, _, err := readChanges(filename, st.index[skip/indexInterval], func(change *storage.Change, size int64) bool {
+		if change.Cursor >= first {
+			kept = append(kept, change)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, change := range kept {
+		data, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(data)+"\n")
+	}
+	if err := atomicfile.WriteFile(filename, []byte(strings.Join(lines, "")), 0600); err != nil {
+		return err
+	}
+	*st = fileState{oldest: kept[0].Cursor - 1, latest: st.latest}
+	for _, line := range lines {
+		st.add(int64(len(line)))
+	}
+	return nil
+}
+
+// add adds a change with a line of size bytes at the end of the file.
+func (st *fileState) add(size int64) {
+	if st.count%indexInterval == 0 {
+		st.index = append(st.index, st.size)
+	}
+	st.count++
+	st.size += size
+}
+
+// readChanges calls fn with every change of the file from the offset, and the size of its line, until it
+// returns false. A missing file has no changes.
+// It returns the offset after the last line read and if the file ends with a partial line, left by a failed
+// write, which is not read. The other lines that are not a change are an error.
+func readChanges(filename string, offset int64, fn func(change *storage.Change, size int64) bool) (int64, bool, error) {
+	fd, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer fd.Close()
+	if _, err := fd.Seek(offset, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	r := bufio.NewReader(fd)
+	end := offset
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return end, len(line) > 0, nil
+		}
+		if err != nil {
+			return end, false, err
+		}
+		change := &storage.Change{}
+		if err := json.Unmarshal(line, change); err != nil {
+			return end, false, fmt.Errorf("invalid change at offset %d of %s: %s", end, filename, err.Error())
+		}
+		end += int64(len(line))
+		if !fn(change, int64(len(line))) {
+			return end, false, nil
+		}
+	}
+}
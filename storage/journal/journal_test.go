@@ -0,0 +1,152 @@
+package journal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir string
+	cfg *config.Config
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	cfgFile := filepath.Join(s.dir, "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(`{"storage_change_journal_size": 2}`), 0600), IsNil)
+	var err error
+	s.cfg, err = config.New(cfgFile, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestCompact(c *C) {
+	filename := filepath.Join(s.dir, "changes.jsonl")
+
+	j := NewJournal(s.cfg)
+	for _, p := range []string{"/a", "/b", "/c"} {
+		c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeCreate, Path: p}), IsNil)
+	}
+	list, err := j.Since(filename, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.Changes, HasLen, 3)
+	first := list.Changes[0].Cursor
+	c.Assert(first>>epochShift > 0, Equals, true)
+	c.Assert(list.Changes[2].Cursor, Equals, first+2)
+
+	// the fourth change drops the two oldest ones.
+	c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeDelete, Path: "/a"}), IsNil)
+	_, err = j.Since(filename, first, 10)
+	c.Assert(storage.IsCursorExpiredError(err), Equals, true)
+	c.Assert(err.(*storage.CursorExpiredError).Latest, Equals, first+3)
+	_, err = j.Since(filename, 0, 10)
+	c.Assert(storage.IsCursorExpiredError(err), Equals, true)
+
+	// the state is read again from the file.
+	j = NewJournal(s.cfg)
+	list, err = j.Since(filename, first+1, 1)
+	c.Assert(err, IsNil)
+	c.Assert(list.Changes, HasLen, 1)
+	c.Assert(list.Changes[0].Path, Equals, "/c")
+	c.Assert(list.HasMore, Equals, true)
+	c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeCreate, Path: "/d"}), IsNil)
+	list, err = j.Since(filename, list.Cursor, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.Cursor, Equals, first+4)
+}
+
+func (s *TestSuite) TestIndex(c *C) {
+	cfgFile := filepath.Join(s.dir, "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(`{"storage_change_journal_size": 100}`), 0600), IsNil)
+	cfg, err := config.New(cfgFile, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	filename := filepath.Join(s.dir, "changes.jsonl")
+
+	// the changes are read from the offsets of the index, before and after compacting the file.
+	j := NewJournal(cfg)
+	var cursors []uint64
+	for i := 0; i < 250; i++ {
+		change := &storage.Change{Type: storage.ChangeCreate, Path: fmt.Sprintf("/%d", i)}
+		c.Assert(j.Append(filename, change), IsNil)
+		cursors = append(cursors, change.Cursor)
+	}
+	for _, i := range []int{99, 163, 200, 248} {
+		list, err := j.Since(filename, cursors[i], 1)
+		c.Assert(err, IsNil)
+		c.Assert(list.Changes, HasLen, 1)
+		c.Assert(list.Changes[0].Path, Equals, fmt.Sprintf("/%d", i+1))
+	}
+	_, err = j.Since(filename, cursors[98], 1)
+	c.Assert(storage.IsCursorExpiredError(err), Equals, true)
+}
+
+func (s *TestSuite) TestPartialLine(c *C) {
+	filename := filepath.Join(s.dir, "changes.jsonl")
+	j := NewJournal(s.cfg)
+	c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeCreate, Path: "/a"}), IsNil)
+
+	// a failed write left a partial line, it is removed before the next change.
+	fd, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	c.Assert(err, IsNil)
+	_, err = fd.WriteString(`{"type":"create","pa`)
+	c.Assert(err, IsNil)
+	c.Assert(fd.Close(), IsNil)
+	j = NewJournal(s.cfg)
+	c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeCreate, Path: "/b"}), IsNil)
+
+	j = NewJournal(s.cfg)
+	list, err := j.Since(filename, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.Changes, HasLen, 2)
+	c.Assert(list.Changes[1].Path, Equals, "/b")
+
+	// a complete line that is not a change is an error.
+	data, err := ioutil.ReadFile(filename)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filename, append([]byte("not a change\n"), data...), 0600), IsNil)
+	_, err = NewJournal(s.cfg).Since(filename, 0, 10)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestRemovedFile(c *C) {
+	filename := filepath.Join(s.dir, "changes.jsonl")
+	j := NewJournal(s.cfg)
+	for _, p := range []string{"/a", "/b"} {
+		c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeCreate, Path: p}), IsNil)
+	}
+	list, err := j.Since(filename, 0, 1)
+	c.Assert(err, IsNil)
+	cursor := list.Cursor
+
+	// the cursors of a removed file are expired in the new file.
+	c.Assert(os.Remove(filename), IsNil)
+	j = NewJournal(s.cfg)
+	list, err = j.Since(filename, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.Changes, HasLen, 0)
+	// the new file is created in a later second.
+	state := j.files[filename]
+	state.oldest = (cursor>>epochShift + 1) << epochShift
+	state.latest = state.oldest
+	for _, p := range []string{"/c", "/d"} {
+		c.Assert(j.Append(filename, &storage.Change{Type: storage.ChangeCreate, Path: p}), IsNil)
+	}
+	_, err = j.Since(filename, cursor, 10)
+	c.Assert(storage.IsCursorExpiredError(err), Equals, true)
+	list, err = j.Since(filename, 0, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.Changes, HasLen, 2)
+	c.Assert(list.Changes[0].Path, Equals, "/c")
+}
@@ -0,0 +1,202 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/syncato/lib/auth"
+)
+
+// LegacyHomeLayout is the layout of the home directories of the storages without a layout in the configuration.
+// The homes are at {authid}/{username} with the values used as they are, like before the layouts were
+// configurable, so the existing homes keep their paths. The users whose auth ID or username is not a single
+// element of a path, like an empty value, . or .., or a value with slashes, have no home in this layout.
+const LegacyHomeLayout = "legacy"
+
+// placeholderRegexp matches the placeholders of a home layout template.
+var placeholderRegexp = regexp.MustCompile(`^\{(authid|username)(?:\[(\d+)\])?\}$`)
+
+// HomeLayout is the layout of the home directories of the users in a storage, defined by a template like
+// {authid}/{username[0]}/{username} where every element of the path is a literal text or a placeholder:
+//
+// 1. {authid} is the ID of the auth provider of the user.
+//
+// 2. {username} is the username.
+//
+// 3. {username[N]} and {authid[N]} are the character N, starting at 0, of the value, or _ if it is shorter.
+// They are used to shard the homes in several directories.
+//
+// The values are sanitized so they are always a single safe element of the path: the characters that are not
+// letters, digits or one of ._@+- and a leading dot are escaped like in URLs, so a/b becomes a%2Fb.
+type HomeLayout struct {
+	template string
+	elements []layoutElement
+	legacy   bool // the values are not sanitized, see LegacyHomeLayout.
+}
+
+// layoutElement is an element of the path of a home layout.
+type layoutElement struct {
+	literal string // the literal text if this is not a placeholder.
+	name    string // the name of the placeholder, authid or username.
+	index   int    // the index of the character of the placeholder, or -1 for the whole value.
+}
+
+// NewHomeLayout parses a home layout template. An empty template is the LegacyHomeLayout.
+func NewHomeLayout(template string) (*HomeLayout, error) {
+	if template == "" || template == LegacyHomeLayout {
+		return &HomeLayout{
+			template: LegacyHomeLayout,
+			elements: []layoutElement{{name: "authid", index: -1}, {name: "username", index: -1}},
+			legacy:   true,
+		}, nil
+	}
+	l := &HomeLayout{template: template}
+	hasUsername := false
+	for _, e := range strings.Split(strings.Trim(template, "/"), "/") {
+		if e == "" || e == "." || e == ".." {
+			return nil, errors.New(fmt.Sprintf("home layout '%s' has an invalid path element '%s'", template, e))
+		}
+		if !strings.ContainsAny(e, "{}") {
+			l.elements = append(l.elements, layoutElement{literal: e})
+			continue
+		}
+		m := placeholderRegexp.FindStringSubmatch(e)
+		if m == nil {
+			return nil, errors.New(fmt.Sprintf("home layout '%s' has an invalid placeholder '%s'", template, e))
+		}
+		le := layoutElement{name: m[1], index: -1}
+		if m[2] != "" {
+			le.index, _ = strconv.Atoi(m[2])
+		}
+		if le.name == "username" && le.index == -1 {
+			hasUsername = true
+		}
+		l.elements = append(l.elements, le)
+	}
+	if !hasUsername {
+		return nil, errors.New(fmt.Sprintf("home layout '%s' does not contain {username}", template))
+	}
+	return l, nil
+}
+
+// String returns the template of the layout.
+func (l *HomeLayout) String() string {
+	return l.template
+}
+
+// Depth returns the number of elements of the paths of the home directories.
+func (l *HomeLayout) Depth() int {
+	return len(l.elements)
+}
+
+// HomePath returns the path of the home directory of the user, relative to the root of the storage
+// and with slashes as separators.
+func (l *HomeLayout) HomePath(authRes *auth.AuthResource) (string, error) {
+	if authRes.Username == "" {
+		return "", errors.New("user without username has no home directory")
+	}
+	values := map[string]string{"authid": authRes.AuthID, "username": authRes.Username}
+	elements := make([]string, len(l.elements))
+	for i, le := range l.elements {
+		if le.name == "" {
+			elements[i] = le.literal
+			continue
+		}
+		v := values[le.name]
+		if l.legacy {
+			if !isPathElement(v) {
+				return "", errors.New(fmt.Sprintf("%s '%s' cannot be a home directory in the legacy home layout", le.name, v))
+			}
+			elements[i] = v
+			continue
+		}
+		if le.index >= 0 {
+			v = nthChar(v, le.index)
+		}
+		elements[i] = sanitizePathElement(v)
+	}
+	return path.Join(elements...), nil
+}
+
+// ParseHomePath returns the auth ID and the username of a home directory path created by HomePath,
+// or false if the path is not a home directory of this layout.
+func (l *HomeLayout) ParseHomePath(homePath string) (string, string, bool) {
+	elements := strings.Split(strings.Trim(homePath, "/"), "/")
+	if len(elements) != len(l.elements) {
+		return "", "", false
+	}
+	values := map[string]string{}
+	for i, le := range l.elements {
+		if le.name == "" {
+			if elements[i] != le.literal {
+				return "", "", false
+			}
+			continue
+		}
+		if le.index >= 0 {
+			continue
+		}
+		v := elements[i]
+		if !l.legacy {
+			var err error
+			if v, err = url.PathUnescape(v); err != nil {
+				return "", "", false
+			}
+		}
+		values[le.name] = v
+	}
+	authRes := &auth.AuthResource{AuthID: values["authid"], Username: values["username"]}
+	// the shards must match the values, so the path is the one created by HomePath.
+	if p, err := l.HomePath(authRes); err != nil || p != strings.Trim(homePath, "/") {
+		return "", "", false
+	}
+	return authRes.AuthID, authRes.Username, true
+}
+
+// nthChar returns the character n of s, or _ if s is shorter.
+func nthChar(s string, n int) string {
+	for i := 0; len(s) > 0; i++ {
+		r, size := utf8.DecodeRuneInString(s)
+		if i == n {
+			return string(r)
+		}
+		s = s[size:]
+	}
+	return "_"
+}
+
+// isPathElement checks if v can be used as it is as a single element of a path.
+func isPathElement(v string) bool {
+	return v != "" && v != "." && v != ".." && !strings.ContainsRune(v, '/') && !strings.ContainsRune(v, filepath.Separator)
+}
+
+// sanitizePathElement escapes the characters of v that are not safe in an element of a path.
+// An empty value is replaced by _.
+func sanitizePathElement(v string) string {
+	if v == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		safe := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '_' || c == '@' || c == '+' || c == '-' || (c == '.' && i > 0)
+		if safe {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/syncato/lib/auth"
+)
+
+// LockScope is the scope of a lock.
+type LockScope string
+
+const (
+	// LockExclusive locks can only be held by one client.
+	LockExclusive LockScope = "exclusive"
+	// LockShared locks can be held by several clients at the same time, any of them can modify the resource.
+	LockShared LockScope = "shared"
+)
+
+// LockDepthInfinity is the depth of the locks that also lock all the resources inside a collection.
+// The locks with depth 0 only lock the resource itself.
+const LockDepthInfinity = -1
+
+// Lock is a lock on a resource, like a WebDAV lock or the check out of a file.
+// While a resource is locked, it can only be modified by the requests with the token of the lock.
+type Lock struct {
+	Token     string    `json:"token"`      // the lock token, like opaquelocktoken:<uuid>.
+	Owner     string    `json:"owner"`      // the owner of the resource, as AuthID/Username.
+	URI       string    `json:"uri"`        // the URI of the resource in the storage of the owner, see LockURI.
+	Scope     LockScope `json:"scope"`      // exclusive or shared.
+	Depth     int       `json:"depth"`      // 0 or LockDepthInfinity.
+	Holder    string    `json:"holder"`     // the username of the user holding the lock.
+	HolderID  string    `json:"holder_id"`  // the AuthID of the user holding the lock.
+	OwnerInfo string    `json:"owner_info"` // information about the holder sent by the client, like the WebDAV owner.
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Covers checks if the lock applies to the resource, because it is the locked resource or the lock has
+// depth infinity and the resource is inside the locked collection.
+func (l *Lock) Covers(owner, uri string) bool {
+	if l.Owner != owner {
+		return false
+	}
+	return l.URI == uri || (l.Depth == LockDepthInfinity && strings.HasPrefix(uri, strings.TrimSuffix(l.URI, "/")+"/"))
+}
+
+// HeldBy checks if the lock is held by the user, with the same Username and AuthID.
+func (l *Lock) HeldBy(authRes *auth.AuthResource) bool {
+	return l.Holder == authRes.Username && l.HolderID == authRes.AuthID
+}
+
+// Locker is the interface used by the storage multiplexer to lock resources and to check the locks
+// before modifying them.
+// The resources are identified by their owner, see LockOwner, and their URI in the storage of the owner,
+// see LockURI, so the locks of shared resources are the locks of the resource of the owner.
+type Locker interface {
+	// Lock locks the resource for the holder. It returns a LockedError if it conflicts with another lock.
+	// If the timeout is zero or greater than the maximum allowed, the maximum is used.
+	Lock(owner, holder *auth.AuthResource, uri string, scope LockScope, depth int, timeout time.Duration, ownerInfo string) (*Lock, error)
+
+	// Refresh extends the timeout of the lock with the token on the resource.
+	Refresh(owner, holder *auth.AuthResource, uri, token string, timeout time.Duration) (*Lock, error)
+
+	// Unlock removes the lock with the token on the resource. Only the holder of the lock and the owner of
+	// the resource can remove it.
+	Unlock(owner, holder *auth.AuthResource, uri, token string) error
+
+	// Locks returns the locks applying to the resource. The tokens of the locks not held by the user
+	// are removed, so only their holders can use them.
+	Locks(owner, user *auth.AuthResource, uri string) []*Lock
+
+	// CheckWrite returns a LockedError if the resource is locked and none of the tokens is the token of
+	// one of its locks held by the user. If recursive is true, the locks of the resources inside it are
+	// checked too.
+	CheckWrite(owner, user *auth.AuthResource, uri string, tokens []string, recursive bool) error
+
+	// RemoveLocks removes the locks of a resource that has been removed and of the resources inside it.
+	RemoveLocks(owner *auth.AuthResource, uri string) error
+}
+
+// LockOwner returns the identifier of the owner of the resources in the locks.
+func LockOwner(authRes *auth.AuthResource) string {
+	return authRes.AuthID + "/" + authRes.Username
+}
+
+// LockURI returns the URI identifying a resource in the locks, with the scheme and the clean path.
+func LockURI(uri *url.URL) string {
+	return uri.Scheme + "://" + path.Clean("/"+uri.Path)
+}
+
+// LockedError is returned when a resource cannot be modified or locked because of a lock.
+type LockedError struct {
+	URI  string
+	Lock *Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("resource %s is locked by %s until %s", e.URI, e.Lock.Holder, e.Lock.Expires.Format(time.RFC3339))
+}
+
+// IsLockedError checks if the error is a LockedError.
+func IsLockedError(err error) bool {
+	_, ok := err.(*LockedError)
+	return ok
+}
+
+type lockTokensContextKey struct{}
+
+// NewLockTokenContext returns a context with the lock tokens submitted by the client, like the tokens in the
+// WebDAV If header, so the operations done with the context can modify the resources locked with them.
+func NewLockTokenContext(ctx context.Context, tokens ...string) context.Context {
+	all := append([]string{}, LockTokensFromContext(ctx)...)
+	return context.WithValue(ctx, lockTokensContextKey{}, append(all, tokens...))
+}
+
+// LockTokensFromContext returns the lock tokens in the context.
+func LockTokensFromContext(ctx context.Context) []string {
+	tokens, _ := ctx.Value(lockTokensContextKey{}).([]string)
+	return tokens
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package lock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/syncato/lib/atomicfile"
+	"github.com/syncato/lib/storage"
+)
+
+// MemoryBackend is the implementation of the Backend interface that keeps the locks in memory,
+// so they are lost when the daemon restarts.
+type MemoryBackend struct {
+	locks []*storage.Lock
+}
+
+// NewMemoryBackend returns a MemoryBackend object.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+func (b *MemoryBackend) Load() ([]*storage.Lock, error) {
+	return b.locks, nil
+}
+
+func (b *MemoryBackend) Save(locks []*storage.Lock) error {
+	b.locks = locks
+	return nil
+}
+
+// FileBackend is the implementation of the Backend interface that saves the locks in a JSON file,
+// so they survive the restarts of the daemon.
+type FileBackend struct {
+	filename string
+}
+
+// NewFileBackend returns a FileBackend object saving the locks in the file.
+func NewFileBackend(filename string) *FileBackend {
+	return &FileBackend{filename: filename}
+}
+
+// Load returns the locks in the file. A missing file has no locks.
+func (b *FileBackend) Load() ([]*storage.Lock, error) {
+	var locks []*storage.Lock
+	data, err := ioutil.ReadFile(b.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return locks, json.Unmarshal(data, &locks)
+}
+
+// Save writes the locks to the file.
+func (b *FileBackend) Save(locks []*storage.Lock) error {
+	data, err := json.Marshal(locks)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(b.filename, data, 0600)
+}
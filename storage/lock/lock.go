@@ -0,0 +1,285 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package lock defines the lock manager that keeps the locks of the resources of the storages.
+package lock
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/storage"
+)
+
+// LockNotFoundError is returned when a lock token does not exist, has expired or is not a lock of the resource.
+type LockNotFoundError struct {
+	Token string
+}
+
+func (e *LockNotFoundError) Error() string {
+	return fmt.Sprintf("lock %s not found", e.Token)
+}
+
+// IsLockNotFoundError checks if the error is a LockNotFoundError.
+func IsLockNotFoundError(err error) bool {
+	_, ok := err.(*LockNotFoundError)
+	return ok
+}
+
+// Backend is the interface used by the Manager to persist the locks.
+type Backend interface {
+	// Load returns all the locks saved.
+	Load() ([]*storage.Lock, error)
+
+	// Save replaces all the locks saved.
+	Save(locks []*storage.Lock) error
+}
+
+// Manager implements the storage.Locker interface keeping the locks in memory and saving them to a backend
+// after every change. The expired locks are ignored and removed on the next change.
+// The maximum timeout of the locks is read from the StorageLockMaxTimeout of the configuration.
+type Manager struct {
+	mu      sync.Mutex
+	backend Backend
+	locks   map[string]*storage.Lock // token => lock
+	cfg     *config.Config
+}
+
+// NewManager creates a Manager with the locks of the backend.
+func NewManager(backend Backend, cfg *config.Config) (*Manager, error) {
+	locks, err := backend.Load()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{backend: backend, locks: map[string]*storage.Lock{}, cfg: cfg}
+	for _, l := range locks {
+		m.locks[l.Token] = l
+	}
+	return m, nil
+}
+
+// Lock locks the resource for the holder. An exclusive lock conflicts with any other lock applying to the
+// resource or, with depth infinity, to the resources inside it. A shared lock only conflicts with exclusive locks.
+func (m *Manager) Lock(owner, holder *auth.AuthResource, uri string, scope storage.LockScope, depth int, timeout time.Duration, ownerInfo string) (*storage.Lock, error) {
+	if scope != storage.LockExclusive && scope != storage.LockShared {
+		return nil, fmt.Errorf("invalid lock scope '%s'", scope)
+	}
+	if depth != 0 && depth != storage.LockDepthInfinity {
+		return nil, fmt.Errorf("invalid lock depth %d", depth)
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	l := &storage.Lock{
+		Token:     token,
+		Owner:     storage.LockOwner(owner),
+		URI:       uri,
+		Scope:     scope,
+		Depth:     depth,
+		Holder:    holder.Username,
+		HolderID:  holder.AuthID,
+		OwnerInfo: ownerInfo,
+		Created:   now,
+		Expires:   now.Add(m.timeout(timeout)),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.active(now) {
+		overlaps := other.Covers(l.Owner, l.URI) || l.Covers(other.Owner, other.URI)
+		if overlaps && (scope == storage.LockExclusive || other.Scope == storage.LockExclusive) {
+			return nil, &storage.LockedError{URI: uri, Lock: visible(other, holder)}
+		}
+	}
+	m.locks[l.Token] = l
+	if err := m.save(now); err != nil {
+		delete(m.locks, l.Token)
+		return nil, err
+	}
+	return l, nil
+}
+
+// Refresh extends the timeout of the lock with the token on the resource. Only the holder can refresh it.
+func (m *Manager) Refresh(owner, holder *auth.AuthResource, uri, token string, timeout time.Duration) (*storage.Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	l, err := m.get(owner, uri, token, now)
+	if err != nil {
+		return nil, err
+	}
+	if !l.HeldBy(holder) {
+		return nil, &storage.PermissionDeniedError{fmt.Sprintf("lock %s is held by another user", token)}
+	}
+	refreshed := *l
+	refreshed.Expires = now.Add(m.timeout(timeout))
+	m.locks[token] = &refreshed
+	if err := m.save(now); err != nil {
+		m.locks[token] = l
+		return nil, err
+	}
+	return &refreshed, nil
+}
+
+// Unlock removes the lock with the token on the resource. Only the holder of the lock and the owner of
+// the resource can remove it.
+func (m *Manager) Unlock(owner, holder *auth.AuthResource, uri, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	l, err := m.get(owner, uri, token, now)
+	if err != nil {
+		return err
+	}
+	if !l.HeldBy(holder) && storage.LockOwner(holder) != l.Owner {
+		return &storage.PermissionDeniedError{fmt.Sprintf("lock %s is held by another user", token)}
+	}
+	delete(m.locks, token)
+	if err := m.save(now); err != nil {
+		m.locks[token] = l
+		return err
+	}
+	return nil
+}
+
+// Locks returns the locks applying to the resource, without the tokens of the locks not held by the user.
+func (m *Manager) Locks(owner, user *auth.AuthResource, uri string) []*storage.Lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var locks []*storage.Lock
+	for _, l := range m.active(time.Now()) {
+		if l.Covers(storage.LockOwner(owner), uri) {
+			locks = append(locks, visible(l, user))
+		}
+	}
+	return locks
+}
+
+// CheckWrite returns a LockedError if the resource is locked and none of the tokens is the token of one of
+// its locks held by the user. If recursive is true, the locks of the resources inside it are checked too.
+// The tokens of the locks held by other users are ignored, a token alone does not allow to modify a resource.
+func (m *Manager) CheckWrite(owner, user *auth.AuthResource, uri string, tokens []string, recursive bool) error {
+	ownerKey := storage.LockOwner(owner)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	submitted := map[string]bool{}
+	for _, token := range tokens {
+		if l, ok := m.locks[token]; ok && l.HeldBy(user) {
+			submitted[token] = true
+		}
+	}
+	for _, l := range m.active(now) {
+		applies := l.Covers(ownerKey, uri) || (recursive && l.Owner == ownerKey && isInside(l.URI, uri))
+		if applies && !submitted[l.Token] && !m.unlockedByShared(l, ownerKey, uri, submitted, now) {
+			return &storage.LockedError{URI: uri, Lock: visible(l, user)}
+		}
+	}
+	return nil
+}
+
+// RemoveLocks removes the locks of a resource that has been removed and of the resources inside it.
+func (m *Manager) RemoveLocks(owner *auth.AuthResource, uri string) error {
+	ownerKey := storage.LockOwner(owner)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := false
+	for token, l := range m.locks {
+		if l.Owner == ownerKey && (l.URI == uri || isInside(l.URI, uri)) {
+			delete(m.locks, token)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return m.save(time.Now())
+}
+
+// unlockedByShared checks if the lock is a shared lock and the token of another shared lock applying to the
+// resource has been submitted, because any holder of a shared lock can modify the resource.
+func (m *Manager) unlockedByShared(l *storage.Lock, ownerKey, uri string, submitted map[string]bool, now time.Time) bool {
+	if l.Scope != storage.LockShared {
+		return false
+	}
+	for token := range submitted {
+		other, ok := m.locks[token]
+		if ok && other.Scope == storage.LockShared && now.Before(other.Expires) && other.Covers(ownerKey, uri) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the active lock with the token on the resource.
+func (m *Manager) get(owner *auth.AuthResource, uri, token string, now time.Time) (*storage.Lock, error) {
+	l, ok := m.locks[token]
+	if !ok || !now.Before(l.Expires) || !l.Covers(storage.LockOwner(owner), uri) {
+		return nil, &LockNotFoundError{token}
+	}
+	return l, nil
+}
+
+// active returns the locks that have not expired.
+func (m *Manager) active(now time.Time) []*storage.Lock {
+	locks := make([]*storage.Lock, 0, len(m.locks))
+	for _, l := range m.locks {
+		if now.Before(l.Expires) {
+			locks = append(locks, l)
+		}
+	}
+	return locks
+}
+
+// save removes the expired locks and saves the rest to the backend.
+func (m *Manager) save(now time.Time) error {
+	for token, l := range m.locks {
+		if !now.Before(l.Expires) {
+			delete(m.locks, token)
+		}
+	}
+	return m.backend.Save(m.active(now))
+}
+
+// timeout returns the timeout of a lock, limited to the maximum of the configuration.
+func (m *Manager) timeout(timeout time.Duration) time.Duration {
+	max := time.Duration(m.cfg.StorageLockMaxTimeout()) * time.Second
+	if timeout <= 0 || timeout > max {
+		return max
+	}
+	return timeout
+}
+
+// visible returns the lock as seen by the user, without the token if the user does not hold it.
+func visible(l *storage.Lock, user *auth.AuthResource) *storage.Lock {
+	if l.HeldBy(user) {
+		return l
+	}
+	cp := *l
+	cp.Token = ""
+	return &cp
+}
+
+// isInside checks if the resource with the URI child is inside the collection with the URI parent.
+func isInside(child, parent string) bool {
+	return strings.HasPrefix(child, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// newToken returns a new lock token as a random UUID URI, see RFC 4918 appendix C.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
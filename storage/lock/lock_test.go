@@ -0,0 +1,110 @@
+package lock
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	cfg   *config.Config
+	alice *auth.AuthResource
+	bob   *auth.AuthResource
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(`{"storage_lock_max_timeout": 60}`), 0600), IsNil)
+	var err error
+	s.cfg, err = config.New(cfgFile, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	s.alice = &auth.AuthResource{AuthID: "json", Username: "alice"}
+	s.bob = &auth.AuthResource{AuthID: "json", Username: "bob"}
+}
+
+func (s *TestSuite) TestLock(c *C) {
+	m, err := NewManager(NewMemoryBackend(), s.cfg)
+	c.Assert(err, IsNil)
+
+	l, err := m.Lock(s.alice, s.alice, "local:///docs", storage.LockExclusive, storage.LockDepthInfinity, time.Hour, "alice's laptop")
+	c.Assert(err, IsNil)
+	c.Assert(l.Expires.Sub(l.Created), Equals, time.Minute)
+
+	// the resources inside the locked collection are locked too.
+	_, err = m.Lock(s.alice, s.bob, "local:///docs/a.txt", storage.LockShared, 0, 0, "")
+	c.Assert(storage.IsLockedError(err), Equals, true)
+	c.Assert(storage.IsLockedError(m.CheckWrite(s.alice, s.alice, "local:///docs/a.txt", nil, false)), Equals, true)
+	c.Assert(m.CheckWrite(s.alice, s.alice, "local:///docs/a.txt", []string{l.Token}, false), IsNil)
+	c.Assert(m.CheckWrite(s.alice, s.alice, "local:///other", nil, false), IsNil)
+	c.Assert(m.CheckWrite(s.bob, s.bob, "local:///docs/a.txt", nil, false), IsNil)
+
+	// the token only allows the holder to modify the resource and it is hidden to other users.
+	c.Assert(storage.IsLockedError(m.CheckWrite(s.alice, s.bob, "local:///docs/a.txt", []string{l.Token}, false)), Equals, true)
+	otherAlice := &auth.AuthResource{AuthID: "ldap", Username: "alice"}
+	c.Assert(storage.IsLockedError(m.CheckWrite(s.alice, otherAlice, "local:///docs/a.txt", []string{l.Token}, false)), Equals, true)
+	locks := m.Locks(s.alice, s.bob, "local:///docs/a.txt")
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].Token, Equals, "")
+	c.Assert(l.Token, Not(Equals), "")
+	c.Assert(m.Locks(s.alice, s.alice, "local:///docs/a.txt")[0].Token, Equals, l.Token)
+	c.Assert(storage.IsPermissionDeniedError(m.Unlock(s.alice, otherAlice, "local:///docs", l.Token)), Equals, true)
+
+	// only the holder and the owner of the resource can unlock it.
+	c.Assert(IsLockNotFoundError(m.Unlock(s.alice, s.alice, "local:///other", l.Token)), Equals, true)
+	c.Assert(storage.IsPermissionDeniedError(m.Unlock(s.alice, s.bob, "local:///docs", l.Token)), Equals, true)
+	c.Assert(m.Unlock(s.alice, s.alice, "local:///docs", l.Token), IsNil)
+	c.Assert(m.Locks(s.alice, s.alice, "local:///docs"), HasLen, 0)
+}
+
+func (s *TestSuite) TestSharedLocks(c *C) {
+	m, err := NewManager(NewMemoryBackend(), s.cfg)
+	c.Assert(err, IsNil)
+
+	l1, err := m.Lock(s.alice, s.alice, "local:///a.txt", storage.LockShared, 0, 0, "")
+	c.Assert(err, IsNil)
+	l2, err := m.Lock(s.alice, s.bob, "local:///a.txt", storage.LockShared, 0, 0, "")
+	c.Assert(err, IsNil)
+	_, err = m.Lock(s.alice, s.bob, "local:///a.txt", storage.LockExclusive, 0, 0, "")
+	c.Assert(storage.IsLockedError(err), Equals, true)
+
+	// any of the shared locks allows to modify the resource.
+	c.Assert(m.CheckWrite(s.alice, s.alice, "local:///a.txt", []string{l1.Token}, false), IsNil)
+	c.Assert(m.CheckWrite(s.alice, s.bob, "local:///a.txt", []string{l2.Token}, false), IsNil)
+	c.Assert(storage.IsLockedError(m.CheckWrite(s.alice, s.bob, "local:///a.txt", []string{l1.Token}, false)), Equals, true)
+	c.Assert(m.Locks(s.alice, s.alice, "local:///a.txt"), HasLen, 2)
+
+	// removing the parent collection is blocked by the locks inside it.
+	c.Assert(m.CheckWrite(s.alice, s.alice, "local:///", nil, false), IsNil)
+	c.Assert(storage.IsLockedError(m.CheckWrite(s.alice, s.alice, "local:///", nil, true)), Equals, true)
+	c.Assert(m.RemoveLocks(s.alice, "local:///"), IsNil)
+	c.Assert(m.Locks(s.alice, s.alice, "local:///a.txt"), HasLen, 0)
+}
+
+func (s *TestSuite) TestFileBackend(c *C) {
+	filename := filepath.Join(c.MkDir(), "locks.json")
+	m, err := NewManager(NewFileBackend(filename), s.cfg)
+	c.Assert(err, IsNil)
+	l, err := m.Lock(s.alice, s.alice, "local:///a.txt", storage.LockExclusive, 0, 0, "")
+	c.Assert(err, IsNil)
+
+	m, err = NewManager(NewFileBackend(filename), s.cfg)
+	c.Assert(err, IsNil)
+	locks := m.Locks(s.alice, s.alice, "local:///a.txt")
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].Token, Equals, l.Token)
+	refreshed, err := m.Refresh(s.alice, s.alice, "local:///a.txt", l.Token, 0)
+	c.Assert(err, IsNil)
+	c.Assert(refreshed.Expires.After(l.Expires) || refreshed.Expires.Equal(l.Expires), Equals, true)
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// SetLocker sets the locker used to lock the resources. The operations modifying a locked resource fail
+// with a LockedError unless the token of the lock is in their context, see storage.NewLockTokenContext.
+// Removing or renaming a resource removes its locks.
+// If no locker is set, the resources cannot be locked.
+func (mux *StorageMux) SetLocker(locker storage.Locker) {
+	mux.locker = locker
+}
+
+// Lock locks a resource for the user, who needs write permission on it.
+// If the resource does not exist an empty file is created, like WebDAV does to reserve a name before uploading.
+func (mux *StorageMux) Lock(ctx context.Context, authRes *auth.AuthResource, rawUri string, scope storage.LockScope, depth int, timeout time.Duration, ownerInfo string) (*storage.Lock, error) {
+	if mux.locker == nil {
+		return nil, &storage.PermissionDeniedError{"locks are not enabled"}
+	}
+	s, owner, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessLock)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.Stat(ctx, owner, uri, false)
+	if err != nil && !storage.IsNotExistError(err) {
+		return nil, err
+	}
+	l, lerr := mux.locker.Lock(owner, authRes, storage.LockURI(uri), scope, depth, timeout, ownerInfo)
+	if lerr != nil {
+		return nil, lerr
+	}
+	if err != nil {
+		if err := s.PutFile(ctx, owner, uri, emptyReader{}, 0); err != nil {
+			mux.locker.Unlock(owner, authRes, l.URI, l.Token)
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// RefreshLock extends the timeout of a lock of the user on the resource.
+func (mux *StorageMux) RefreshLock(ctx context.Context, authRes *auth.AuthResource, rawUri, token string, timeout time.Duration) (*storage.Lock, error) {
+	if mux.locker == nil {
+		return nil, &storage.PermissionDeniedError{"locks are not enabled"}
+	}
+	_, owner, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessLock)
+	if err != nil {
+		return nil, err
+	}
+	return mux.locker.Refresh(owner, authRes, storage.LockURI(uri), token, timeout)
+}
+
+// Unlock removes a lock on the resource. The owner of the resource can remove the locks of other users.
+func (mux *StorageMux) Unlock(ctx context.Context, authRes *auth.AuthResource, rawUri, token string) error {
+	if mux.locker == nil {
+		return &storage.PermissionDeniedError{"locks are not enabled"}
+	}
+	_, owner, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessLock)
+	if err != nil {
+		return err
+	}
+	return mux.locker.Unlock(owner, authRes, storage.LockURI(uri), token)
+}
+
+// GetLocks returns the locks applying to the resource. Only the tokens of the locks held by the user are returned.
+func (mux *StorageMux) GetLocks(ctx context.Context, authRes *auth.AuthResource, rawUri string) ([]*storage.Lock, error) {
+	if mux.locker == nil {
+		return nil, nil
+	}
+	_, owner, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return mux.locker.Locks(owner, authRes, storage.LockURI(uri)), nil
+}
+
+// removeLocks removes the locks of a resource that no longer exists.
+func (mux *StorageMux) removeLocks(owner *auth.AuthResource, uri *url.URL) error {
+	if mux.locker == nil {
+		return nil
+	}
+	return mux.locker.RemoveLocks(owner, storage.LockURI(uri))
+}
+
+// emptyReader is the data of the empty files created when locking a resource that does not exist.
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) { return 0, io.EOF }
@@ -7,13 +7,30 @@
 package mux
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/auth/authz"
+	"github.com/syncato/lib/config"
 	"github.com/syncato/lib/logger"
 	"github.com/syncato/lib/storage"
 	"io"
+	"mime"
 	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// access is the permission an operation needs on a resource.
+type access int
+
+const (
+	accessRead   access = iota // get and stat.
+	accessWrite                // put, create collections and destination of copies and renames.
+	accessDelete               // remove and source of renames.
+	accessLock                 // lock and unlock, like write but without checking the locks.
 )
 
 // StorageMux is a multiplexer responsible for routing storage operations to the
@@ -21,19 +38,34 @@ import (
 // It keeps a map of all the storage providers registered.
 type StorageMux struct {
 	storageProviders map[string]storage.StorageProvider
+	authorizer       storage.Authorizer
+	shares           storage.ShareResolver
+	quota            storage.Quota
+	locker           storage.Locker
 	log              *logger.Logger
 }
 
-// NewStorageMux creates a StorageMux or returns an error
-func NewStorageMux(log *logger.Logger) (*StorageMux, error) {
+// NewStorageMux creates a StorageMux or returns an error.
+// The storages are only accessed by the users allowed by the authorization policies of the configuration,
+// see authz.Authorizer.
+func NewStorageMux(cfg *config.Config, log *logger.Logger) (*StorageMux, error) {
 	m := StorageMux{}
 	m.storageProviders = make(map[string]storage.StorageProvider)
 	m.log = log
+	authorizer, err := authz.NewAuthorizer(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	m.authorizer = authorizer
 	return &m, nil
 }
 
 // AddStorageProvider adds a storage provider to be used by the multiplexer.
+// The shared scheme is reserved for the resources shared with the user, see SetShareResolver.
 func (mux *StorageMux) AddStorageProvider(s storage.StorageProvider) error {
+	if s.GetScheme() == storage.SharedScheme {
+		return errors.New(fmt.Sprintf("storage scheme %s is reserved", s.GetScheme()))
+	}
 	if _, ok := mux.storageProviders[s.GetScheme()]; ok {
 		return errors.New(fmt.Sprintf("storage %s already registered", s.GetScheme()))
 	}
@@ -41,18 +73,52 @@ func (mux *StorageMux) AddStorageProvider(s storage.StorageProvider) error {
 	return nil
 }
 
+// SetAuthorizer replaces the authorizer used to check that users can access a storage.
+// If it is nil, all the users can access all the storages.
+func (mux *StorageMux) SetAuthorizer(authorizer storage.Authorizer) {
+	mux.authorizer = authorizer
+}
+
+// SetShareResolver sets the resolver of the resources shared with the users, so they can be accessed with
+// URIs like shared://<share id>/<path>. The operations are done as the owner of the resource and they are
+// only allowed if the share grants the permission. Stat of shared:// lists the resources shared with the user.
+// If no resolver is set, the shared scheme does not exist.
+func (mux *StorageMux) SetShareResolver(shares storage.ShareResolver) {
+	mux.shares = shares
+}
+
 func (mux *StorageMux) GetStorageProvider(storageScheme string) (storage.StorageProvider, bool) {
 	sp, ok := mux.storageProviders[storageScheme]
 	return sp, ok
 }
 
+// GetStorageSchemes returns the sorted schemes of the registered storage providers.
+func (mux *StorageMux) GetStorageSchemes() []string {
+	schemes := make([]string, 0, len(mux.storageProviders))
+	for scheme := range mux.storageProviders {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
 // IsUserHomeCreated checks if the user home directory has been created in the specified storage.
-func (mux *StorageMux) IsUserHomeCreated(authRes *auth.AuthResource, storageScheme string) (bool, error) {
+// If the storageScheme is empty, it checks if the home directory has been created in all storages.
+func (mux *StorageMux) IsUserHomeCreated(ctx context.Context, authRes *auth.AuthResource, storageScheme string) (bool, error) {
+	if storageScheme == "" {
+		for _, scheme := range mux.GetStorageSchemes() {
+			ok, err := mux.IsUserHomeCreated(ctx, authRes, scheme)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
 	storage, ok := mux.GetStorageProvider(storageScheme)
 	if !ok {
 		return false, errors.New(fmt.Sprintf("storage '%s' not registered", storageScheme))
 	}
-	ok, err := storage.IsUserHomeCreated(authRes)
+	ok, err := storage.IsUserHomeCreated(ctx, authRes)
 	if err != nil {
 		return false, err
 	}
@@ -64,110 +130,432 @@ func (mux *StorageMux) IsUserHomeCreated(authRes *auth.AuthResource, storageSche
 
 // CreateUserHome routes the creation of the user home directory to the correct storage provider implementation.
 // If the storageScheme is empty, the creation of the home directory will be propagated to all storages.
-func (mux *StorageMux) CreateUserHome(authRes *auth.AuthResource, storageScheme string) error {
+func (mux *StorageMux) CreateUserHome(ctx context.Context, authRes *auth.AuthResource, storageScheme string) error {
+	if storageScheme == "" {
+		for _, scheme := range mux.GetStorageSchemes() {
+			if err := mux.CreateUserHome(ctx, authRes, scheme); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	storage, ok := mux.GetStorageProvider(storageScheme)
 	if !ok {
 		return errors.New(fmt.Sprintf("storage '%s' not registered", storageScheme))
 	}
-	return storage.CreateUserHome(authRes)
+	return storage.CreateUserHome(ctx, authRes)
 }
 
 // PutFile routes the put operation to the correct storage provider implementation.
-func (mux *StorageMux) PutFile(authRes *auth.AuthResource, rawUri string, r io.Reader, size int64) error {
-	s, uri, err := mux.getStorageAndURIFromPath(rawUri)
+func (mux *StorageMux) PutFile(ctx context.Context, authRes *auth.AuthResource, rawUri string, r io.Reader, size int64) error {
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessWrite)
 	if err != nil {
 		return err
 	}
-	return s.PutFile(authRes, uri, r, size)
+	if mux.quota != nil {
+		return mux.putFileWithQuota(ctx, s, authRes, uri, r, size)
+	}
+	return s.PutFile(ctx, authRes, uri, r, size)
 }
 
 // GetFile routes the get operation to the correct storage provider implementation.
-func (mux *StorageMux) GetFile(authRes *auth.AuthResource, rawUri string) (io.Reader, error) {
-	s, uri, err := mux.getStorageAndURIFromPath(rawUri)
+func (mux *StorageMux) GetFile(ctx context.Context, authRes *auth.AuthResource, rawUri string) (io.Reader, error) {
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessRead)
 	if err != nil {
 		return nil, err
 	}
-	return s.GetFile(authRes, uri)
+	return s.GetFile(ctx, authRes, uri)
 }
 
 // Stat routes the stat operation to the correct storage provider implementation.
-func (mux *StorageMux) Stat(authRes *auth.AuthResource, rawUri string, children bool) (*storage.MetaData, error) {
-	mux.log.Debug(fmt.Sprintf("%+v", children), nil)
-	s, uri, err := mux.getStorageAndURIFromPath(rawUri)
+// The paths of the metadata of shared resources are shared URIs.
+// The metadata of the home collection, the root of a storage, contains the quota of the user.
+func (mux *StorageMux) Stat(ctx context.Context, authRes *auth.AuthResource, rawUri string, children bool) (*storage.MetaData, error) {
+	if uri, err := url.Parse(rawUri); err == nil && uri.Scheme == storage.SharedScheme {
+		return mux.statShared(ctx, authRes, uri, children)
+	}
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := s.Stat(ctx, authRes, uri, children)
 	if err != nil {
 		return nil, err
 	}
-	return s.Stat(authRes, uri, children)
+	if mux.quota != nil && (uri.Path == "" || uri.Path == "/") {
+		quota, used := mux.quota.Get(authRes)
+		meta.Quota = &storage.QuotaInfo{Quota: quota, Used: used}
+	}
+	return meta, nil
 }
 
 // Remove routes the remove operation to the correct storage provider implementation.
-func (mux *StorageMux) Remove(authRes *auth.AuthResource, rawUri string, recursive bool) error {
-	s, uri, err := mux.getStorageAndURIFromPath(rawUri)
+func (mux *StorageMux) Remove(ctx context.Context, authRes *auth.AuthResource, rawUri string, recursive bool) error {
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessDelete)
 	if err != nil {
 		return err
 	}
-	return s.Remove(authRes, uri, recursive)
+	var size int64
+	if mux.quota != nil {
+		if size, err = mux.treeSize(ctx, s, authRes, uri); err != nil {
+			return err
+		}
+	}
+	if err := s.Remove(ctx, authRes, uri, recursive); err != nil {
+		return err
+	}
+	if err := mux.removeLocks(authRes, uri); err != nil {
+		return err
+	}
+	if mux.quota == nil {
+		return nil
+	}
+	return mux.quota.Add(authRes, -size)
 }
 
 // CreateCol routes the create collection operation to the correct storage provider implementation.
-func (mux *StorageMux) CreateCol(authRes *auth.AuthResource, rawUri string, recursive bool) error {
-	s, uri, err := mux.getStorageAndURIFromPath(rawUri)
+func (mux *StorageMux) CreateCol(ctx context.Context, authRes *auth.AuthResource, rawUri string, recursive bool) error {
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessWrite)
 	if err != nil {
 		return err
 	}
-	return s.CreateCol(authRes, uri, recursive)
+	return s.CreateCol(ctx, authRes, uri, recursive)
 }
 
 // Copy routes the copy operation to the correct storage provider implementation.
-func (mux *StorageMux) Copy(authRes *auth.AuthResource, fromRawUri, toRawUri string) error {
-	fromStorage, fromUri, err := mux.getStorageAndURIFromPath(fromRawUri)
+// Copies between the resources of different users are cross-storage copies.
+func (mux *StorageMux) Copy(ctx context.Context, authRes *auth.AuthResource, fromRawUri, toRawUri string) error {
+	fromStorage, fromAuthRes, fromUri, err := mux.getStorageAndURIFromPath(ctx, authRes, fromRawUri, accessRead)
 	if err != nil {
 		return err
 	}
 
-	toStorage, toUri, err := mux.getStorageAndURIFromPath(toRawUri)
+	toStorage, toAuthRes, toUri, err := mux.getStorageAndURIFromPath(ctx, authRes, toRawUri, accessWrite)
 	if err != nil {
 		return err
 	}
 
-	if fromStorage.GetScheme() != toStorage.GetScheme() {
+	if fromStorage.GetScheme() != toStorage.GetScheme() || !sameUser(fromAuthRes, toAuthRes) {
 		return &storage.CrossStorageCopyNotImplemented{}
 	}
 
-	return fromStorage.Copy(authRes, fromUri, toUri)
+	if mux.quota == nil {
+		return fromStorage.Copy(ctx, fromAuthRes, fromUri, toUri)
+	}
+	size, err := mux.treeSize(ctx, fromStorage, fromAuthRes, fromUri)
+	if err != nil {
+		return err
+	}
+	old, err := mux.existingSize(ctx, toStorage, toAuthRes, toUri)
+	if err != nil {
+		return err
+	}
+	reserved, err := mux.reserve(toAuthRes, size-old)
+	if err != nil {
+		return err
+	}
+	defer mux.quota.Release(toAuthRes, reserved)
+	if err := fromStorage.Copy(ctx, fromAuthRes, fromUri, toUri); err != nil {
+		return err
+	}
+	return mux.quota.Add(toAuthRes, size-old)
 }
 
 // Rename routes the rename operation to the correct storage provider implementation.
-func (mux *StorageMux) Rename(authRes *auth.AuthResource, fromRawUri, toRawUri string) error {
-	fromStorage, fromUri, err := mux.getStorageAndURIFromPath(fromRawUri)
+// Renames between the resources of different users are cross-storage renames.
+func (mux *StorageMux) Rename(ctx context.Context, authRes *auth.AuthResource, fromRawUri, toRawUri string) error {
+	fromStorage, fromAuthRes, fromUri, err := mux.getStorageAndURIFromPath(ctx, authRes, fromRawUri, accessDelete)
 	if err != nil {
 		return err
 	}
 
-	toStorage, toUri, err := mux.getStorageAndURIFromPath(toRawUri)
+	toStorage, toAuthRes, toUri, err := mux.getStorageAndURIFromPath(ctx, authRes, toRawUri, accessWrite)
 	if err != nil {
 		return err
 	}
 
-	if fromStorage.GetScheme() != toStorage.GetScheme() {
+	if fromStorage.GetScheme() != toStorage.GetScheme() || !sameUser(fromAuthRes, toAuthRes) {
 		return &storage.CrossStorageMoveNotImplemented{}
 	}
 
+	// the resource replaced by the rename frees its space.
+	var old int64
+	if mux.quota != nil {
+		if old, err = mux.existingSize(ctx, toStorage, toAuthRes, toUri); err != nil {
+			return err
+		}
+	}
 	// we could use toStorage too, are the same in this step
-	return fromStorage.Rename(authRes, fromUri, toUri)
+	if err := fromStorage.Rename(ctx, fromAuthRes, fromUri, toUri); err != nil {
+		return err
+	}
+	// the locks are not moved with the resources.
+	if err := mux.removeLocks(fromAuthRes, fromUri); err != nil {
+		return err
+	}
+	if mux.quota == nil {
+		return nil
+	}
+	return mux.quota.Add(toAuthRes, -old)
+}
+
+// GetProperty routes the get property operation to the correct storage provider implementation.
+func (mux *StorageMux) GetProperty(ctx context.Context, authRes *auth.AuthResource, rawUri, name string) (string, error) {
+	if err := storage.ValidateProperty(name, ""); err != nil {
+		return "", err
+	}
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessRead)
+	if err != nil {
+		return "", err
+	}
+	return s.GetProperty(ctx, authRes, uri, name)
+}
+
+// SetProperty routes the set property operation to the correct storage provider implementation.
+// Setting properties needs the write permission on the resource.
+func (mux *StorageMux) SetProperty(ctx context.Context, authRes *auth.AuthResource, rawUri, name, value string) error {
+	if err := storage.ValidateProperty(name, value); err != nil {
+		return err
+	}
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessWrite)
+	if err != nil {
+		return err
+	}
+	return s.SetProperty(ctx, authRes, uri, name, value)
 }
 
-// getStorageFromPath returns the storage provider adn the URI associated with the resourceUrl passsed or an error.
+// RemoveProperty routes the remove property operation to the correct storage provider implementation.
+// Removing properties needs the write permission on the resource.
+func (mux *StorageMux) RemoveProperty(ctx context.Context, authRes *auth.AuthResource, rawUri, name string) error {
+	if err := storage.ValidateProperty(name, ""); err != nil {
+		return err
+	}
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessWrite)
+	if err != nil {
+		return err
+	}
+	return s.RemoveProperty(ctx, authRes, uri, name)
+}
+
+// ListProperties routes the list properties operation to the correct storage provider implementation.
+func (mux *StorageMux) ListProperties(ctx context.Context, authRes *auth.AuthResource, rawUri string) (map[string]string, error) {
+	s, authRes, uri, err := mux.getStorageAndURIFromPath(ctx, authRes, rawUri, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListProperties(ctx, authRes, uri)
+}
+
+// GetChanges returns the changes of the resources of the user in the storage after the cursor, so sync
+// clients do not need to stat the whole tree. The changes are returned in pages of at most limit changes,
+// or MaxChangesPageSize if limit is not positive or greater.
+// When the cursor has expired, the client must stat the tree and continue from the latest cursor of the
+// CursorExpiredError.
+// Only the changes of the resources of the user are returned, not of the resources shared with the user.
+func (mux *StorageMux) GetChanges(ctx context.Context, authRes *auth.AuthResource, storageScheme string, cursor uint64, limit int) (*storage.ChangeList, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s, ok := mux.storageProviders[storageScheme]
+	if !ok {
+		return nil, &storage.NotExistError{fmt.Sprintf("storage %s not registered", storageScheme)}
+	}
+	if err := mux.checkScheme(authRes, storageScheme, accessRead); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > storage.MaxChangesPageSize {
+		limit = storage.MaxChangesPageSize
+	}
+	return s.GetChanges(ctx, authRes, cursor, limit)
+}
+
+// GetPathByID returns the URI of the resource of the user with the ID, see storage.JoinFileID.
+// Only the resources in the storages of the user are found, not the resources shared with the user.
+func (mux *StorageMux) GetPathByID(ctx context.Context, authRes *auth.AuthResource, fileID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	scheme, id, ok := storage.SplitFileID(fileID)
+	if !ok {
+		return "", &storage.NotExistError{fmt.Sprintf("invalid id %s", fileID)}
+	}
+	s, ok := mux.storageProviders[scheme]
+	if !ok {
+		return "", &storage.NotExistError{fmt.Sprintf("storage %s not registered", scheme)}
+	}
+	if err := mux.checkScheme(authRes, scheme, accessRead); err != nil {
+		return "", err
+	}
+	return s.GetPathByID(ctx, authRes, id)
+}
+
+// getStorageFromPath returns the storage provider, the user and the URI associated with the resourceUrl passsed or an error.
 // the resourceUrl must be a well-formed URI like local://photos/beach.png or eos://data/big.dat
-func (mux *StorageMux) getStorageAndURIFromPath(resourceUrl string) (storage.StorageProvider, *url.URL, error) {
+// The acc parameter indicates the permission needed by the operation.
+// If the context has been cancelled its error is returned.
+// If the user is not allowed to access the storage, or to modify it with the scope of its credentials,
+// a PermissionDeniedError is returned.
+// If the operation modifies a locked resource without the token of the lock in the context, a LockedError
+// is returned. Deleting checks the locks of the resources inside the resource too.
+// Shared URIs are resolved to the URI of the resource in the storage of its owner, and the user returned is the owner.
+func (mux *StorageMux) getStorageAndURIFromPath(ctx context.Context, authRes *auth.AuthResource, resourceUrl string, acc access) (storage.StorageProvider, *auth.AuthResource, *url.URL, error) {
+	return mux.getStorageAndURI(ctx, authRes, authRes, resourceUrl, acc)
+}
+
+// getStorageAndURI is getStorageAndURIFromPath for the resources of authRes accessed by user, who is
+// authRes itself or a recipient of a share of authRes. The locks are checked against the user.
+func (mux *StorageMux) getStorageAndURI(ctx context.Context, user, authRes *auth.AuthResource, resourceUrl string, acc access) (storage.StorageProvider, *auth.AuthResource, *url.URL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
 	uri, err := url.Parse(resourceUrl)
 	if err != nil {
-		return nil, nil, &storage.NotExistError{err.Error()}
+		return nil, nil, nil, &storage.NotExistError{err.Error()}
+	}
+	if uri.Scheme == storage.SharedScheme {
+		return mux.getSharedStorageAndURI(ctx, authRes, uri, acc)
 	}
 	s, ok := mux.GetStorageProvider(uri.Scheme)
 	if !ok {
-		return nil, nil, &storage.NotExistError{fmt.Sprintf("storage %s not registered", uri.Scheme)}
+		return nil, nil, nil, &storage.NotExistError{fmt.Sprintf("storage %s not registered", uri.Scheme)}
+	}
+	if err := mux.checkScheme(authRes, uri.Scheme, acc); err != nil {
+		return nil, nil, nil, err
+	}
+	if mux.locker != nil && (acc == accessWrite || acc == accessDelete) {
+		err := mux.locker.CheckWrite(authRes, user, storage.LockURI(uri), storage.LockTokensFromContext(ctx), acc == accessDelete)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
 	mux.log.Debug("get storage and uri from url", map[string]interface{}{"url": resourceUrl, "uri": fmt.Sprintf("%+v", *uri)})
-	return s, uri, nil
+	return s, authRes, uri, nil
+}
+
+// getSharedStorageAndURI resolves a shared URI, checking the permissions of the share.
+// The recipients cannot remove the shared resource itself, only the resources inside a shared collection.
+func (mux *StorageMux) getSharedStorageAndURI(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, acc access) (storage.StorageProvider, *auth.AuthResource, *url.URL, error) {
+	if mux.shares == nil {
+		return nil, nil, nil, &storage.NotExistError{fmt.Sprintf("storage %s not registered", storage.SharedScheme)}
+	}
+	if err := mux.checkScheme(authRes, storage.SharedScheme, acc); err != nil {
+		return nil, nil, nil, err
+	}
+	if uri.Host == "" {
+		return nil, nil, nil, &storage.PermissionDeniedError{"the collection of shared resources is read only"}
+	}
+	res, err := mux.shares.ResolveShare(authRes, uri.Host)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rel := strings.TrimSuffix(path.Clean("/"+uri.Path), "/")
+	if !res.IsCol && rel != "" {
+		return nil, nil, nil, &storage.NotExistError{fmt.Sprintf("share %s is not a collection", res.ID)}
+	}
+	allowed := false
+	switch acc {
+	case accessRead:
+		allowed = res.Permissions.Read
+	case accessWrite, accessLock:
+		allowed = res.Permissions.Write
+	case accessDelete:
+		allowed = res.Permissions.Delete && rel != ""
+	}
+	if !allowed {
+		return nil, nil, nil, &storage.PermissionDeniedError{fmt.Sprintf("share %s does not allow the operation to user %s", res.ID, authRes.Username)}
+	}
+
+	target, err := url.Parse(strings.TrimSuffix(res.URI, "/") + rel)
+	if err != nil || target.Scheme == storage.SharedScheme {
+		return nil, nil, nil, &storage.NotExistError{fmt.Sprintf("share %s has an invalid uri", res.ID)}
+	}
+	mux.log.Debug("resolved shared uri", map[string]interface{}{"uri": uri.String(), "owner": res.Owner.Username, "target": target.String()})
+	return mux.getStorageAndURI(ctx, authRes, res.Owner, target.String(), acc)
+}
+
+// statShared returns the metadata of a shared resource with the paths as shared URIs, or the collection
+// of the resources shared with the user for the URI shared://.
+func (mux *StorageMux) statShared(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, children bool) (*storage.MetaData, error) {
+	if uri.Host != "" {
+		s, ownerAuthRes, ownerUri, err := mux.getStorageAndURIFromPath(ctx, authRes, uri.String(), accessRead)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := s.Stat(ctx, ownerAuthRes, ownerUri, children)
+		if err != nil {
+			return nil, err
+		}
+		// the storage paths of the owner are not shown.
+		rel := path.Clean("/" + uri.Path)
+		base := storage.SharedScheme + "://" + uri.Host
+		meta.Id = base + strings.TrimSuffix(rel, "/")
+		meta.Path = meta.Id
+		for _, child := range meta.Children {
+			child.Id = base + path.Join(rel, path.Base(child.Path))
+			child.Path = child.Id
+		}
+		return meta, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if mux.shares == nil {
+		return nil, &storage.NotExistError{fmt.Sprintf("storage %s not registered", storage.SharedScheme)}
+	}
+	if err := mux.checkScheme(authRes, storage.SharedScheme, accessRead); err != nil {
+		return nil, err
+	}
+	meta := &storage.MetaData{
+		Id:       storage.SharedScheme + "://",
+		Path:     storage.SharedScheme + "://",
+		IsCol:    true,
+		MimeType: "inode/directory",
+	}
+	if !children {
+		return meta, nil
+	}
+	meta.Children = []*storage.MetaData{}
+	for _, res := range mux.shares.ListShares(authRes) {
+		mimeType := "inode/directory"
+		if !res.IsCol {
+			mimeType = mime.TypeByExtension(path.Ext(res.URI))
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+		}
+		meta.Children = append(meta.Children, &storage.MetaData{
+			Id:       storage.SharedScheme + "://" + res.ID,
+			Path:     storage.SharedScheme + "://" + res.ID,
+			IsCol:    res.IsCol,
+			MimeType: mimeType,
+			Extra:    map[string]interface{}{"name": path.Base(res.URI), "owner": res.Owner.Username, "permissions": res.Permissions},
+		})
+	}
+	return meta, nil
+}
+
+// checkScheme checks that the user can access the storage with the scheme with the scope of its credentials.
+func (mux *StorageMux) checkScheme(authRes *auth.AuthResource, scheme string, acc access) error {
+	if mux.authorizer != nil && !mux.authorizer.CanAccessScheme(authRes, scheme) {
+		return &storage.PermissionDeniedError{fmt.Sprintf("user %s cannot access storage %s", authRes.Username, scheme)}
+	}
+	if !authRes.Scope.AllowsScheme(scheme) {
+		return &storage.PermissionDeniedError{fmt.Sprintf("credentials of user %s are not valid for storage %s", authRes.Username, scheme)}
+	}
+	if acc != accessRead && !authRes.Scope.AllowsWrite() {
+		return &storage.PermissionDeniedError{fmt.Sprintf("credentials of user %s are read only", authRes.Username)}
+	}
+	// the path of a signed url is checked against the request by the auth multiplexer, as the URI it
+	// accesses depends on the API. Here the credentials can only read and write the resources.
+	if (acc == accessDelete || acc == accessLock) && authRes.Scope.IsSignedURL() {
+		return &storage.PermissionDeniedError{fmt.Sprintf("signed url credentials of user %s cannot remove, rename or lock resources", authRes.Username)}
+	}
+	return nil
+}
+
+// sameUser checks if two users are the same user.
+func sameUser(a, b *auth.AuthResource) bool {
+	return a.Username == b.Username && a.AuthID == b.AuthID
 }
@@ -0,0 +1,66 @@
+package mux
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	"github.com/syncato/lib/storage/storagetest"
+
+	. "gopkg.in/check.v1"
+)
+
+type AuthzSuite struct{}
+
+var _ = Suite(&AuthzSuite{})
+
+// newStorageMux returns a StorageMux with the configuration and a mem storage.
+func newStorageMux(c *C, cfgData string) (*StorageMux, *storagetest.MemStorage) {
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(cfgData), 0600), IsNil)
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	storageMux, err := NewStorageMux(cfg, log)
+	c.Assert(err, IsNil)
+	mem := storagetest.NewMemStorage("mem")
+	c.Assert(storageMux.AddStorageProvider(mem), IsNil)
+	return storageMux, mem
+}
+
+func (s *AuthzSuite) TestSchemeGroups(c *C) {
+	storageMux, mem := newStorageMux(c, `{"authz_scheme_groups": {"mem": ["physics"]}}`)
+
+	// the policies of the configuration are enforced without setting an authorizer.
+	ctx := context.Background()
+	alice := &auth.AuthResource{AuthID: "json", Username: "alice"}
+	bob := &auth.AuthResource{AuthID: "json", Username: "bob", Groups: []string{"physics"}}
+	c.Assert(mem.CreateUserHome(ctx, alice), IsNil)
+	c.Assert(mem.CreateUserHome(ctx, bob), IsNil)
+	_, err := storageMux.Stat(ctx, alice, "mem:///", false)
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+	_, err = storageMux.Stat(ctx, bob, "mem:///", false)
+	c.Assert(err, IsNil)
+}
+
+func (s *AuthzSuite) TestSignedURL(c *C) {
+	storageMux, mem := newStorageMux(c, `{}`)
+	ctx := context.Background()
+	alice := &auth.AuthResource{AuthID: "json", Username: "alice"}
+	c.Assert(mem.CreateUserHome(ctx, alice), IsNil)
+	c.Assert(storageMux.PutFile(ctx, alice, "mem:///a", strings.NewReader("a"), 1), IsNil)
+
+	// the credentials of an upload url can write the resources but not remove or rename them.
+	signed := &auth.AuthResource{AuthID: "json", Username: "alice", Scope: &auth.Scope{SignedURLPath: "/api/files/mem/a", SignedURLOp: "upload"}}
+	c.Assert(storageMux.PutFile(ctx, signed, "mem:///a", strings.NewReader("b"), 1), IsNil)
+	err := storageMux.Remove(ctx, signed, "mem:///a", false)
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+	err = storageMux.Rename(ctx, signed, "mem:///a", "mem:///b")
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+	c.Assert(storageMux.Remove(ctx, alice, "mem:///a", false), IsNil)
+}
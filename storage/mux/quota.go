@@ -0,0 +1,148 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// SetQuota sets the quota used to limit the space used by the users.
+// The space is accounted to the owner of the resources, so files put in a shared collection use the quota
+// of the user sharing it. Renames only free the space of the resources they replace because they are never
+// between users.
+// If no quota is set, the users have no limit.
+func (mux *StorageMux) SetQuota(quota storage.Quota) {
+	mux.quota = quota
+}
+
+// RecalculateQuotaUsage sets the usage of the user to the size of all its resources in all the storages.
+// This is needed when the quota is enabled for existing users or the data has been modified outside the daemon.
+func (mux *StorageMux) RecalculateQuotaUsage(ctx context.Context, authRes *auth.AuthResource) error {
+	if mux.quota == nil {
+		return nil
+	}
+	var used int64
+	for scheme, s := range mux.storageProviders {
+		n, err := mux.treeSize(ctx, s, authRes, &url.URL{Scheme: scheme, Path: "/"})
+		if err != nil && !storage.IsNotExistError(err) {
+			return err
+		}
+		used += n
+	}
+	mux.log.Info("quota usage recalculated", map[string]interface{}{"username": authRes.Username, "used": used})
+	return mux.quota.Set(authRes, used)
+}
+
+// putFileWithQuota puts a file checking that the quota of the user is not exceeded.
+// A known size is reserved before writing, so the upload fails early. The data is read through a reader that
+// reserves the bytes read beyond the reservation and fails when the quota is exceeded, so the storage does not
+// commit the file even if the size is unknown.
+func (mux *StorageMux) putFileWithQuota(ctx context.Context, s storage.StorageProvider, authRes *auth.AuthResource, uri *url.URL, r io.Reader, size int64) error {
+	old, err := mux.existingSize(ctx, s, authRes, uri)
+	if err != nil {
+		return err
+	}
+	// the bytes of the file replaced can be written without reserving them.
+	qr := &quotaReader{r: r, quota: mux.quota, authRes: authRes, allowed: old}
+	defer func() { mux.quota.Release(authRes, qr.reserved) }()
+	if size > old {
+		if err := qr.reserve(size - old); err != nil {
+			return err
+		}
+	}
+	if err := s.PutFile(ctx, authRes, uri, qr, size); err != nil {
+		if qr.err != nil {
+			return qr.err
+		}
+		return err
+	}
+	return mux.quota.Add(authRes, qr.n-old)
+}
+
+// reserve reserves size bytes of the quota of the user if size is positive, returning the bytes reserved
+// that must be released when the operation ends.
+func (mux *StorageMux) reserve(authRes *auth.AuthResource, size int64) (int64, error) {
+	if size <= 0 {
+		return 0, nil
+	}
+	if err := mux.quota.Reserve(authRes, size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// existingSize returns the size of the resource, or zero if it does not exist.
+func (mux *StorageMux) existingSize(ctx context.Context, s storage.StorageProvider, authRes *auth.AuthResource, uri *url.URL) (int64, error) {
+	n, err := mux.treeSize(ctx, s, authRes, uri)
+	if storage.IsNotExistError(err) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// treeSize returns the size of a file, or the size of all the files in a collection.
+func (mux *StorageMux) treeSize(ctx context.Context, s storage.StorageProvider, authRes *auth.AuthResource, uri *url.URL) (int64, error) {
+	meta, err := s.Stat(ctx, authRes, uri, true)
+	if err != nil {
+		return 0, err
+	}
+	if !meta.IsCol {
+		return int64(meta.Size), nil
+	}
+	var total int64
+	for _, child := range meta.Children {
+		if !child.IsCol {
+			total += int64(child.Size)
+			continue
+		}
+		childUri := *uri
+		childUri.Path = path.Join(uri.Path, path.Base(child.Path))
+		n, err := mux.treeSize(ctx, s, authRes, &childUri)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// quotaReader counts the bytes read and reserves the bytes read beyond the allowed bytes in the quota of the
+// user. It fails with the error of the quota when the bytes cannot be reserved.
+type quotaReader struct {
+	r        io.Reader
+	quota    storage.Quota
+	authRes  *auth.AuthResource
+	n        int64
+	allowed  int64 // the bytes that can be read without reserving more.
+	reserved int64
+	err      error
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	qr.n += int64(n)
+	if qr.n > qr.allowed {
+		if rerr := qr.reserve(qr.n - qr.allowed); rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, err
+}
+
+func (qr *quotaReader) reserve(size int64) error {
+	if err := qr.quota.Reserve(qr.authRes, size); err != nil {
+		qr.err = err
+		return err
+	}
+	qr.reserved += size
+	qr.allowed += size
+	return nil
+}
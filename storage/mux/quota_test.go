@@ -0,0 +1,111 @@
+package mux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	"github.com/syncato/lib/storage/quota"
+	"github.com/syncato/lib/storage/storagetest"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type QuotaSuite struct {
+	manager    *quota.Manager
+	storageMux *StorageMux
+	alice      *auth.AuthResource
+}
+
+var _ = Suite(&QuotaSuite{})
+
+func (s *QuotaSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	cfgFile := filepath.Join(dir, "config.json")
+	data, err := json.Marshal(map[string]interface{}{"storage_quota_default": 10})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+
+	s.manager, err = quota.NewManager("", cfg)
+	c.Assert(err, IsNil)
+	s.storageMux, err = NewStorageMux(cfg, log)
+	c.Assert(err, IsNil)
+	mem := storagetest.NewMemStorage("mem")
+	c.Assert(s.storageMux.AddStorageProvider(mem), IsNil)
+	s.storageMux.SetQuota(s.manager)
+	s.alice = &auth.AuthResource{AuthID: "json", Username: "alice"}
+	c.Assert(mem.CreateUserHome(context.Background(), s.alice), IsNil)
+}
+
+func (s *QuotaSuite) TestQuota(c *C) {
+	ctx := context.Background()
+	c.Assert(s.storageMux.PutFile(ctx, s.alice, "mem:///a", strings.NewReader("123456"), 6), IsNil)
+	// the size is unknown, the reader fails when the quota is exceeded.
+	err := s.storageMux.PutFile(ctx, s.alice, "mem:///b", strings.NewReader("123456"), -1)
+	c.Assert(storage.IsQuotaExceededError(err), Equals, true)
+	// overwriting frees the space of the old file.
+	c.Assert(s.storageMux.PutFile(ctx, s.alice, "mem:///a", strings.NewReader("1234567890"), 10), IsNil)
+	err = s.storageMux.Copy(ctx, s.alice, "mem:///a", "mem:///c")
+	c.Assert(storage.IsQuotaExceededError(err), Equals, true)
+
+	meta, err := s.storageMux.Stat(ctx, s.alice, "mem:///", false)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Quota, DeepEquals, &storage.QuotaInfo{Quota: 10, Used: 10})
+
+	c.Assert(s.storageMux.Remove(ctx, s.alice, "mem:///a", false), IsNil)
+	_, used := s.manager.Get(s.alice)
+	c.Assert(used, Equals, int64(0))
+}
+
+// nestedReader returns its data and then calls fn before returning io.EOF, to run an operation while
+// the upload is in progress.
+type nestedReader struct {
+	data string
+	fn   func()
+}
+
+func (r *nestedReader) Read(p []byte) (int, error) {
+	if r.data != "" {
+		n := copy(p, r.data)
+		r.data = r.data[n:]
+		return n, nil
+	}
+	if r.fn != nil {
+		r.fn()
+		r.fn = nil
+	}
+	return 0, io.EOF
+}
+
+func (s *QuotaSuite) TestConcurrentUploads(c *C) {
+	ctx := context.Background()
+	var nestedErr error
+	r := &nestedReader{data: "123456", fn: func() {
+		nestedErr = s.storageMux.PutFile(ctx, s.alice, "mem:///b", strings.NewReader("123456"), -1)
+	}}
+	c.Assert(s.storageMux.PutFile(ctx, s.alice, "mem:///a", r, -1), IsNil)
+	// the bytes read by the upload in progress were reserved.
+	c.Assert(storage.IsQuotaExceededError(nestedErr), Equals, true)
+	_, used := s.manager.Get(s.alice)
+	c.Assert(used, Equals, int64(6))
+
+	// a failed upload releases its reservation.
+	broken := io.MultiReader(strings.NewReader("12"), iotest.ErrReader(errors.New("connection reset")))
+	c.Assert(s.storageMux.PutFile(ctx, s.alice, "mem:///c", broken, 3), NotNil)
+	c.Assert(s.storageMux.PutFile(ctx, s.alice, "mem:///c", strings.NewReader("1234"), 4), IsNil)
+}
@@ -0,0 +1,262 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package namespace defines the namespace seen by the users, a single tree of paths with the storages
+// mounted on it, over the storage multiplexer.
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+)
+
+// Namespace routes the operations on the paths of the namespace to the storage multiplexer.
+//
+// The mount points are the StorageMounts of the configuration. A path is resolved with the longest mount point
+// containing it, so with /home mounted on local:///, the path /home/photos/beach.png is the URI
+// local:///photos/beach.png. The resources shared with the user are resolved with the shared scheme, where
+// the first element of the path is the ID of the share.
+//
+// The collections above the mount points, like /, are virtual: they only contain the mount points and
+// they cannot be modified. A mount point nested in another one, like /home/archive in /home, is listed with
+// the resources of the collection containing it.
+// The mount points are read from the configuration on every operation, so changes apply without restarting the daemon.
+type Namespace struct {
+	storageMux *storagemux.StorageMux
+	cfg        *config.Config
+	log        *logger.Logger
+}
+
+// NewNamespace returns a Namespace object or an error.
+func NewNamespace(storageMux *storagemux.StorageMux, cfg *config.Config, log *logger.Logger) (*Namespace, error) {
+	return &Namespace{storageMux: storageMux, cfg: cfg, log: log}, nil
+}
+
+// Resolve returns the storage URI of a path of the namespace.
+// It returns a NotExistError if the path is not in a mount point.
+func (ns *Namespace) Resolve(nsPath string) (string, error) {
+	nsPath = path.Clean("/" + nsPath)
+	var mount *config.StorageMount
+	for _, m := range ns.cfg.StorageMounts() {
+		mp := path.Clean("/" + m.Path)
+		if (nsPath == mp || strings.HasPrefix(nsPath, mp+"/")) && (mount == nil || len(mp) > len(path.Clean("/"+mount.Path))) {
+			m := m
+			mount = &m
+		}
+	}
+	if mount == nil {
+		return "", &storage.NotExistError{fmt.Sprintf("path %s is not in a mount point", nsPath)}
+	}
+
+	rel := strings.TrimPrefix(nsPath, path.Clean("/"+mount.Path))
+	u := &url.URL{Scheme: mount.Scheme}
+	if mount.Scheme == storage.SharedScheme {
+		parts := strings.SplitN(strings.TrimPrefix(rel, "/"), "/", 2)
+		u.Host = parts[0]
+		if len(parts) == 2 {
+			u.Path = "/" + parts[1]
+		}
+		return u.String(), nil
+	}
+	u.Path = path.Join("/", mount.Prefix, rel)
+	return u.String(), nil
+}
+
+// PutFile puts a file at the path.
+func (ns *Namespace) PutFile(ctx context.Context, authRes *auth.AuthResource, nsPath string, r io.Reader, size int64) error {
+	uri, err := ns.resolveWritable(nsPath)
+	if err != nil {
+		return err
+	}
+	return ns.storageMux.PutFile(ctx, authRes, uri, r, size)
+}
+
+// GetFile gets the file at the path.
+func (ns *Namespace) GetFile(ctx context.Context, authRes *auth.AuthResource, nsPath string) (io.Reader, error) {
+	uri, err := ns.Resolve(nsPath)
+	if err != nil {
+		return nil, err
+	}
+	return ns.storageMux.GetFile(ctx, authRes, uri)
+}
+
+// Stat returns the metadata of the resource at the path, with the paths of the resource and its
+// children in the namespace.
+func (ns *Namespace) Stat(ctx context.Context, authRes *auth.AuthResource, nsPath string, children bool) (*storage.MetaData, error) {
+	nsPath = path.Clean("/" + nsPath)
+	mounts := ns.mountsBelow(nsPath)
+	if len(mounts) > 0 && !ns.inMount(nsPath) {
+		return ns.statVirtual(ctx, nsPath, mounts, children)
+	}
+	uri, err := ns.Resolve(nsPath)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ns.storageMux.Stat(ctx, authRes, uri, children)
+	if err != nil {
+		return nil, err
+	}
+	// the IDs of the storage are kept, so they do not change when the resources are renamed.
+	meta.Path = nsPath
+	for _, child := range meta.Children {
+		child.Path = path.Join(nsPath, path.Base(child.Path))
+	}
+	if children {
+		meta.Children = addMountPoints(meta.Children, nsPath, mounts)
+	}
+	return meta, nil
+}
+
+// Remove removes the resource at the path. Mount points cannot be removed.
+func (ns *Namespace) Remove(ctx context.Context, authRes *auth.AuthResource, nsPath string, recursive bool) error {
+	uri, err := ns.resolveWritable(nsPath)
+	if err != nil {
+		return err
+	}
+	if ns.isMountPoint(nsPath) {
+		return &storage.PermissionDeniedError{fmt.Sprintf("mount point %s cannot be removed", path.Clean("/"+nsPath))}
+	}
+	return ns.storageMux.Remove(ctx, authRes, uri, recursive)
+}
+
+// CreateCol creates a collection at the path.
+func (ns *Namespace) CreateCol(ctx context.Context, authRes *auth.AuthResource, nsPath string, recursive bool) error {
+	uri, err := ns.resolveWritable(nsPath)
+	if err != nil {
+		return err
+	}
+	return ns.storageMux.CreateCol(ctx, authRes, uri, recursive)
+}
+
+// Copy copies a resource from one path to another.
+func (ns *Namespace) Copy(ctx context.Context, authRes *auth.AuthResource, fromPath, toPath string) error {
+	fromUri, err := ns.Resolve(fromPath)
+	if err != nil {
+		return err
+	}
+	toUri, err := ns.resolveWritable(toPath)
+	if err != nil {
+		return err
+	}
+	return ns.storageMux.Copy(ctx, authRes, fromUri, toUri)
+}
+
+// Rename renames a resource from one path to another. Mount points cannot be renamed.
+func (ns *Namespace) Rename(ctx context.Context, authRes *auth.AuthResource, fromPath, toPath string) error {
+	fromUri, err := ns.resolveWritable(fromPath)
+	if err != nil {
+		return err
+	}
+	if ns.isMountPoint(fromPath) {
+		return &storage.PermissionDeniedError{fmt.Sprintf("mount point %s cannot be renamed", path.Clean("/"+fromPath))}
+	}
+	toUri, err := ns.resolveWritable(toPath)
+	if err != nil {
+		return err
+	}
+	return ns.storageMux.Rename(ctx, authRes, fromUri, toUri)
+}
+
+// resolveWritable is like Resolve but it fails with a PermissionDeniedError for the virtual collections
+// and the resources outside the mount points inside them.
+func (ns *Namespace) resolveWritable(nsPath string) (string, error) {
+	nsPath = path.Clean("/" + nsPath)
+	if ns.isVirtual(nsPath) || ns.isVirtual(path.Dir(nsPath)) {
+		return "", &storage.PermissionDeniedError{fmt.Sprintf("virtual collection %s cannot be modified", nsPath)}
+	}
+	return ns.Resolve(nsPath)
+}
+
+// isMountPoint checks if the path is a mount point.
+func (ns *Namespace) isMountPoint(nsPath string) bool {
+	nsPath = path.Clean("/" + nsPath)
+	for _, m := range ns.cfg.StorageMounts() {
+		if path.Clean("/"+m.Path) == nsPath {
+			return true
+		}
+	}
+	return false
+}
+
+// isVirtual checks if the path is a virtual collection: it contains mount points and it is not inside a
+// mount point.
+func (ns *Namespace) isVirtual(nsPath string) bool {
+	return len(ns.mountsBelow(nsPath)) > 0 && !ns.inMount(nsPath)
+}
+
+// inMount checks if the path is a mount point or it is inside a mount point.
+func (ns *Namespace) inMount(nsPath string) bool {
+	for _, m := range ns.cfg.StorageMounts() {
+		mp := path.Clean("/" + m.Path)
+		if mp == nsPath || strings.HasPrefix(nsPath, mp+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// mountsBelow returns the names of the children of the path that are mount points or contain mount points.
+// A path inside a mount point can contain the nested mount points too, like /home/shared in /home.
+func (ns *Namespace) mountsBelow(nsPath string) []string {
+	prefix := strings.TrimSuffix(nsPath, "/") + "/"
+	names := map[string]bool{}
+	for _, m := range ns.cfg.StorageMounts() {
+		mp := path.Clean("/" + m.Path)
+		if strings.HasPrefix(mp, prefix) {
+			names[strings.SplitN(strings.TrimPrefix(mp, prefix), "/", 2)[0]] = true
+		}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// addMountPoints adds to the children of the collection at the path the nested mount points with the names
+// that are not already children, so they can be browsed from the collection of the storage.
+func addMountPoints(children []*storage.MetaData, nsPath string, names []string) []*storage.MetaData {
+	for _, name := range names {
+		p := path.Join(nsPath, name)
+		found := false
+		for _, child := range children {
+			if child.Path == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			children = append(children, &storage.MetaData{Id: p, Path: p, IsCol: true, MimeType: "inode/directory"})
+		}
+	}
+	return children
+}
+
+// statVirtual returns the metadata of a virtual collection.
+func (ns *Namespace) statVirtual(ctx context.Context, nsPath string, names []string, children bool) (*storage.MetaData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	meta := &storage.MetaData{Id: nsPath, Path: nsPath, IsCol: true, MimeType: "inode/directory"}
+	if !children {
+		return meta, nil
+	}
+	for _, name := range names {
+		p := path.Join(nsPath, name)
+		meta.Children = append(meta.Children, &storage.MetaData{Id: p, Path: p, IsCol: true, MimeType: "inode/directory"})
+	}
+	return meta, nil
+}
@@ -0,0 +1,108 @@
+package namespace
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+	"github.com/syncato/lib/storage/storagetest"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	ns  *Namespace
+	mem *storagetest.MemStorage
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	data, err := json.Marshal(map[string]interface{}{"storage_mounts": []map[string]string{
+		{"path": "/home", "scheme": "local", "prefix": "/"},
+		{"path": "/home/archive", "scheme": "tape"},
+		{"path": "/projects/physics", "scheme": "eos", "prefix": "/physics"},
+		{"path": "/projects/physics/archive", "scheme": "tape"},
+		{"path": "/shared", "scheme": "shared"},
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+	log := logger.NewLogger("test", 0)
+	cfg, err := config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+	storageMux, err := storagemux.NewStorageMux(cfg, log)
+	c.Assert(err, IsNil)
+	s.mem = storagetest.NewMemStorage("local")
+	c.Assert(storageMux.AddStorageProvider(s.mem), IsNil)
+	s.ns, err = NewNamespace(storageMux, cfg, log)
+	c.Assert(err, IsNil)
+}
+
+var resolveTests = []struct {
+	path     string
+	expected string
+}{
+	{"/home", "local:///"},
+	{"/home/photos/../beach.png", "local:///beach.png"},
+	{"/home/archive/2015", "tape:///2015"},
+	{"/projects/physics/data", "eos:///physics/data"},
+	{"/projects/physics/archive/2015", "tape:///2015"},
+	{"/shared/abc/docs", "shared://abc/docs"},
+}
+
+func (s *TestSuite) TestResolve(c *C) {
+	for _, t := range resolveTests {
+		uri, err := s.ns.Resolve(t.path)
+		c.Assert(err, IsNil)
+		c.Assert(uri, Equals, t.expected)
+	}
+	_, err := s.ns.Resolve("/other")
+	c.Assert(storage.IsNotExistError(err), Equals, true)
+}
+
+func (s *TestSuite) TestVirtualCollections(c *C) {
+	ctx := context.Background()
+	authRes := &auth.AuthResource{Username: "alice"}
+
+	meta, err := s.ns.Stat(ctx, authRes, "/", true)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Children, HasLen, 3)
+	c.Assert(meta.Children[1].Path, Equals, "/projects")
+
+	meta, err = s.ns.Stat(ctx, authRes, "/projects", true)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Children, HasLen, 1)
+	c.Assert(meta.Children[0].Path, Equals, "/projects/physics")
+
+	err = s.ns.CreateCol(ctx, authRes, "/projects/new", false)
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+	err = s.ns.Remove(ctx, authRes, "/home", true)
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+}
+
+func (s *TestSuite) TestNestedMountPoints(c *C) {
+	ctx := context.Background()
+	authRes := &auth.AuthResource{Username: "alice", AuthID: "json"}
+	c.Assert(s.mem.CreateUserHome(ctx, authRes), IsNil)
+
+	// the collection of the storage lists its resources and the nested mount points.
+	c.Assert(s.ns.CreateCol(ctx, authRes, "/home/photos", false), IsNil)
+	meta, err := s.ns.Stat(ctx, authRes, "/home", true)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Children, HasLen, 2)
+	c.Assert(meta.Children[0].Path, Equals, "/home/photos")
+	c.Assert(meta.Children[1].Path, Equals, "/home/archive")
+
+	err = s.ns.Remove(ctx, authRes, "/home/archive", true)
+	c.Assert(storage.IsPermissionDeniedError(err), Equals, true)
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// changesName is the name of the file in the metadata directory of a home with the change journal of
+// the resources of the home, see config.RootMetaDir. Every mutating operation of the storage records its changes in the journal,
+// the changes done directly in the filesystem are not recorded.
+const changesName = ".syncato-changes.jsonl"
+
+func (s *StorageLocal) GetChanges(ctx context.Context, authRes *auth.AuthResource, cursor uint64, limit int) (*storage.ChangeList, error) {
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return nil, err
+	}
+	list, err := s.journal.Since(filepath.Join(metaDir, changesName), cursor, limit)
+	if err != nil {
+		return nil, s.ConvertError(err)
+	}
+	return list, nil
+}
+
+// newChange returns a change of the resource with the path p relative to the home directory, with its ID
+// in the index of the metadata directory.
+func (s *StorageLocal) newChange(metaDir string, typ storage.ChangeType, p string, isCol bool) (*storage.Change, error) {
+	ids, err := s.ids.ids(metaDir, []string{p})
+	if err != nil {
+		return nil, s.ConvertError(err)
+	}
+	return &storage.Change{
+		Type:  typ,
+		Id:    storage.JoinFileID(s.scheme, ids[p]),
+		Path:  s.uriString(p),
+		IsCol: isCol,
+		Time:  uint64(time.Now().Unix()),
+	}, nil
+}
+
+// replacedChange returns the deletion of the resource at absPath, if it exists, because it is going to be
+// replaced by a copy or a rename.
+func (s *StorageLocal) replacedChange(metaDir, absPath string, uri *url.URL) ([]*storage.Change, error) {
+	finfo, err := os.Lstat(absPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, s.ConvertError(err)
+	}
+	change, err := s.newChange(metaDir, storage.ChangeDelete, relPath(uri), finfo.IsDir())
+	if err != nil {
+		return nil, err
+	}
+	return []*storage.Change{change}, nil
+}
+
+// record records the changes in the change journal of the metadata directory.
+func (s *StorageLocal) record(metaDir string, changes ...*storage.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(metaDir, 0700); err != nil {
+		return err
+	}
+	return s.ConvertError(s.journal.Append(filepath.Join(metaDir, changesName), changes...))
+}
+
+// uriString returns the URI of the path p relative to the home directory.
+func (s *StorageLocal) uriString(p string) string {
+	return (&url.URL{Scheme: s.scheme, Path: p}).String()
+}
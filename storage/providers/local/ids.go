@@ -0,0 +1,286 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/syncato/lib/atomicfile"
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// idIndexName is the name of the file in the metadata directory of a home with the IDs of the resources of
+// the home, see config.RootMetaDir. The file is not in the home itself, so the users cannot reach it.
+// The IDs are allocated the first time a resource is stated and they follow the resource when it is renamed
+// through the storage. Resources renamed directly in the filesystem get new IDs.
+const idIndexName = ".syncato-ids.json"
+
+func (s *StorageLocal) GetPathByID(ctx context.Context, authRes *auth.AuthResource, id string) (string, error) {
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return "", err
+	}
+	p, err := s.ids.lookup(metaDir, id)
+	if err != nil {
+		return "", s.ConvertError(err)
+	}
+	if p == "" {
+		return "", &storage.NotExistError{fmt.Sprintf("resource with id %s not found", id)}
+	}
+	// the resource could have been removed directly in the filesystem.
+	absPath, err := s.absPath(authRes, &url.URL{Path: p})
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(absPath); err != nil {
+		return "", s.ConvertError(err)
+	}
+	return s.uriString(p), nil
+}
+
+// setIDs sets the IDs of the resource of the URI and its children.
+func (s *StorageLocal) setIDs(authRes *auth.AuthResource, uri *url.URL, meta *storage.MetaData) error {
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return err
+	}
+	p := relPath(uri)
+	paths := []string{p}
+	for _, child := range meta.Children {
+		paths = append(paths, path.Join(p, path.Base(child.Path)))
+	}
+	ids, err := s.ids.ids(metaDir, paths)
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	meta.Id = storage.JoinFileID(s.scheme, ids[p])
+	for i, child := range meta.Children {
+		child.Id = storage.JoinFileID(s.scheme, ids[paths[i+1]])
+	}
+	return nil
+}
+
+// removeIDs removes the IDs of the resource of the URI and of the resources inside it.
+func (s *StorageLocal) removeIDs(authRes *auth.AuthResource, uri *url.URL) error {
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return err
+	}
+	return s.ConvertError(s.ids.remove(metaDir, relPath(uri)))
+}
+
+// relPath returns the path of the URI relative to the home directory, as used in the ID index.
+func relPath(uri *url.URL) string {
+	return path.Clean("/" + uri.Path)
+}
+
+// isHidden checks if a file is only used by the storage, like the sidecar files, so it is hidden to the users
+// and cannot be accessed through the storage.
+func isHidden(name string) bool {
+	return isSidecar(name)
+}
+
+// idStore keeps the IDs of the resources in the ID index of the metadata directory of every home.
+// The index maps the IDs to the paths relative to the home directory. The indexes are cached after
+// reading them and every index has its own lock, so the operations on different homes do not wait
+// for each other.
+type idStore struct {
+	sync.Mutex
+	indexes map[string]*idIndex // metadata directory => index
+}
+
+// idIndex is the ID index of a home.
+type idIndex struct {
+	sync.Mutex
+	loaded bool
+	byID   map[string]string // ID => path
+	byPath map[string]string // path => ID
+}
+
+// ids returns the IDs of the resources with the paths, allocating new IDs for the resources without one.
+func (st *idStore) ids(metaDir string, paths []string) (map[string]string, error) {
+	idx, err := st.lock(metaDir)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Unlock()
+	ids := make(map[string]string, len(paths))
+	allocated := false
+	for _, p := range paths {
+		id, ok := idx.byPath[p]
+		if !ok {
+			if id, err = newID(); err != nil {
+				idx.loaded = false
+				return nil, err
+			}
+			idx.byID[id] = p
+			idx.byPath[p] = id
+			allocated = true
+		}
+		ids[p] = id
+	}
+	if allocated {
+		if err := idx.save(metaDir); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// lookup returns the path of the resource with the ID, or an empty path if the ID does not exist.
+func (st *idStore) lookup(metaDir, id string) (string, error) {
+	idx, err := st.lock(metaDir)
+	if err != nil {
+		return "", err
+	}
+	defer idx.Unlock()
+	return idx.byID[id], nil
+}
+
+// rename moves the IDs of a resource that has been renamed and of the resources inside it.
+// The IDs of the resources replaced by the rename are removed.
+func (st *idStore) rename(metaDir, from, to string) error {
+	idx, err := st.lock(metaDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Unlock()
+	changed := false
+	for id, p := range idx.byID {
+		if isInsideOrEqual(p, to) {
+			idx.delete(id)
+			changed = true
+		}
+	}
+	moved := map[string]string{}
+	for id, p := range idx.byID {
+		if isInsideOrEqual(p, from) {
+			moved[id] = to + strings.TrimPrefix(p, from)
+		}
+	}
+	for id, p := range moved {
+		idx.delete(id)
+		idx.byID[id] = p
+		idx.byPath[p] = id
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return idx.save(metaDir)
+}
+
+// remove removes the IDs of a resource that has been removed or replaced and of the resources inside it.
+func (st *idStore) remove(metaDir, p string) error {
+	idx, err := st.lock(metaDir)
+	if err != nil {
+		return err
+	}
+	defer idx.Unlock()
+	changed := false
+	for id, q := range idx.byID {
+		if isInsideOrEqual(q, p) {
+			idx.delete(id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return idx.save(metaDir)
+}
+
+// lock returns the locked index of the metadata directory, reading it if it is not cached.
+func (st *idStore) lock(metaDir string) (*idIndex, error) {
+	st.Lock()
+	if st.indexes == nil {
+		st.indexes = map[string]*idIndex{}
+	}
+	idx, ok := st.indexes[metaDir]
+	if !ok {
+		idx = &idIndex{}
+		st.indexes[metaDir] = idx
+	}
+	st.Unlock()
+
+	idx.Lock()
+	if idx.loaded {
+		return idx, nil
+	}
+	if err := idx.load(metaDir); err != nil {
+		idx.Unlock()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// delete removes the ID from the index.
+func (idx *idIndex) delete(id string) {
+	if p, ok := idx.byID[id]; ok && idx.byPath[p] == id {
+		delete(idx.byPath, p)
+	}
+	delete(idx.byID, id)
+}
+
+// load reads the index from the metadata directory. A missing index has no IDs.
+func (idx *idIndex) load(metaDir string) error {
+	idx.byID = map[string]string{}
+	idx.byPath = map[string]string{}
+	data, err := ioutil.ReadFile(filepath.Join(metaDir, idIndexName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &idx.byID); err != nil {
+			return err
+		}
+	}
+	for id, p := range idx.byID {
+		idx.byPath[p] = id
+	}
+	idx.loaded = true
+	return nil
+}
+
+// save writes the index to the metadata directory. If it fails, the index is read again on the next use
+// so the cache does not keep the IDs that were not saved.
+func (idx *idIndex) save(metaDir string) error {
+	data, err := json.Marshal(idx.byID)
+	if err == nil {
+		if err = os.MkdirAll(metaDir, 0700); err == nil {
+			err = atomicfile.WriteFile(filepath.Join(metaDir, idIndexName), data, 0600)
+		}
+	}
+	if err != nil {
+		idx.loaded = false
+	}
+	return err
+}
+
+// isInsideOrEqual checks if the path p is the path parent or is inside it.
+func isInsideOrEqual(p, parent string) bool {
+	return p == parent || parent == "/" || strings.HasPrefix(p, parent+"/")
+}
+
+// newID returns a random URL safe identifier with 128 bits of entropy.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
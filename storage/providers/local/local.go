@@ -6,16 +6,22 @@
 package local
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/syncato/lib/auth"
 	"github.com/syncato/lib/config"
 	"github.com/syncato/lib/logger"
 	"github.com/syncato/lib/storage"
+	"github.com/syncato/lib/storage/journal"
 	"io"
 	"mime"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // StorageLocal is the implementation of the StorageProvider interface to use a local
@@ -26,13 +32,44 @@ type StorageLocal struct {
 	log         *logger.Logger
 	rootDataDir string
 	rootTmpDir  string
+	rootMetaDir string
+	layout      *storage.HomeLayout
+	dirMode     os.FileMode
+	fileMode    os.FileMode
+	sidecars    *sidecarStore
+	ids         *idStore
+	journal     *journal.Journal
 }
 
 // NewStorageLocal creates a StorageLocal object or returns an error.
+// The home directories of the users are created with the layout of the scheme in StorageHomeLayouts and
+// the modes of the files and directories are restricted by the StorageLocalUmask.
+// The temporary files left by uploads interrupted by a previous run are removed.
+// The resources have stable IDs kept in an index, see idIndexName, and their changes are recorded in a
+// change journal, see changesName. Both are kept in the metadata directories of the homes under RootMetaDir,
+// which must be configured.
 func NewStorageLocal(scheme string, cfg *config.Config, log *logger.Logger) (*StorageLocal, error) {
-	s := &StorageLocal{scheme: scheme, cfg: cfg, log: log}
+	s := &StorageLocal{scheme: scheme, cfg: cfg, log: log, sidecars: &sidecarStore{}, ids: &idStore{}, journal: journal.NewJournal(cfg)}
 	s.rootDataDir = cfg.RootDataDir()
 	s.rootTmpDir = cfg.RootTmpDir()
+	s.rootMetaDir = cfg.RootMetaDir()
+	if s.rootMetaDir == "" {
+		return nil, errors.New("root_meta_dir is needed by the local storage")
+	}
+	layout, err := storage.NewHomeLayout(cfg.StorageHomeLayouts()[scheme])
+	if err != nil {
+		return nil, err
+	}
+	s.layout = layout
+	umask, err := strconv.ParseUint(cfg.StorageLocalUmask(), 8, 32)
+	if err != nil || umask > 0777 {
+		return nil, errors.New(fmt.Sprintf("invalid umask '%s' for local storage", cfg.StorageLocalUmask()))
+	}
+	s.dirMode = 0777 &^ os.FileMode(umask)
+	s.fileMode = 0666 &^ os.FileMode(umask)
+	if err := s.removeTmpFiles(); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
@@ -40,21 +77,27 @@ func (s *StorageLocal) GetScheme() string {
 	return s.scheme
 }
 
-func (s *StorageLocal) CreateUserHome(authRes *auth.AuthResource) error {
-	exists, err := s.IsUserHomeCreated(authRes)
+func (s *StorageLocal) CreateUserHome(ctx context.Context, authRes *auth.AuthResource) error {
+	exists, err := s.IsUserHomeCreated(ctx, authRes)
 	if err != nil {
 		return err
 	}
 	if exists {
 		return nil
 	}
-	homeDir := filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username)
-	return os.MkdirAll(homeDir, 0666)
+	homeDir, err := s.homeDir(s.rootDataDir, authRes)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(homeDir, s.dirMode)
 }
 
-func (s *StorageLocal) IsUserHomeCreated(authRes *auth.AuthResource) (bool, error) {
-	homeDir := filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username)
-	_, err := os.Stat(homeDir)
+func (s *StorageLocal) IsUserHomeCreated(ctx context.Context, authRes *auth.AuthResource) (bool, error) {
+	homeDir, err := s.homeDir(s.rootDataDir, authRes)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(homeDir)
 	if err == nil {
 		return true, nil
 	}
@@ -64,23 +107,41 @@ func (s *StorageLocal) IsUserHomeCreated(authRes *auth.AuthResource) (bool, erro
 	return false, err
 }
 
-func (s *StorageLocal) PutFile(authRes *auth.AuthResource, uri *url.URL, r io.Reader, size int64) error {
-	tmpPath := filepath.Join(s.rootTmpDir, authRes.AuthID, authRes.Username, filepath.Base(uri.Path))
-
-	fd, err := os.Create(tmpPath)
-	defer fd.Close()
+// PutFile writes the file to a temporary file of the user and then commits it, so the file is either
+// completely written or not modified at all, see commitFile. The custom properties of the file it
+// replaces are kept.
+func (s *StorageLocal) PutFile(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, r io.Reader, size int64) error {
+	absPath, err := s.absPath(authRes, uri)
 	if err != nil {
+		return err
+	}
+	props, err := s.listProperties(absPath)
+	if err != nil && !os.IsNotExist(err) {
 		return s.ConvertError(err)
 	}
-	_, err = io.Copy(fd, r)
+	typ := storage.ChangeModify
+	if os.IsNotExist(err) {
+		typ = storage.ChangeCreate
+	}
+	if err := s.writeFile(ctx, authRes, absPath, r, props); err != nil {
+		return err
+	}
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
 	if err != nil {
-		return s.ConvertError(err)
+		return err
 	}
-	return s.commitPutFile(tmpPath, uri.Path)
+	change, err := s.newChange(metaDir, typ, relPath(uri), false)
+	if err != nil {
+		return err
+	}
+	return s.record(metaDir, change)
 }
 
-func (s *StorageLocal) Stat(authRes *auth.AuthResource, uri *url.URL, children bool) (*storage.MetaData, error) {
-	absPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, uri.Path))
+func (s *StorageLocal) Stat(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, children bool) (*storage.MetaData, error) {
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return nil, err
+	}
 
 	finfo, err := os.Stat(absPath)
 	if err != nil {
@@ -95,7 +156,6 @@ func (s *StorageLocal) Stat(authRes *auth.AuthResource, uri *url.URL, children b
 		mimeType = "inode/directory"
 	}
 	meta := storage.MetaData{
-		Id:       uri.String(),
 		Path:     uri.String(),
 		Size:     uint64(finfo.Size()),
 		IsCol:    finfo.IsDir(),
@@ -103,11 +163,14 @@ func (s *StorageLocal) Stat(authRes *auth.AuthResource, uri *url.URL, children b
 		ETag:     fmt.Sprintf("\"%d\"", finfo.ModTime().Unix()),
 		MimeType: mimeType,
 	}
-
-	if meta.IsCol == false {
-		return &meta, nil
+	if meta.Properties, err = s.listProperties(absPath); err != nil {
+		return nil, s.ConvertError(err)
 	}
-	if children == false {
+
+	if meta.IsCol == false || children == false {
+		if err := s.setIDs(authRes, uri, &meta); err != nil {
+			return nil, err
+		}
 		return &meta, nil
 	}
 
@@ -122,8 +185,11 @@ func (s *StorageLocal) Stat(authRes *auth.AuthResource, uri *url.URL, children b
 		return nil, s.ConvertError(err)
 	}
 
-	meta.Children = make([]*storage.MetaData, len(finfos))
-	for i, f := range finfos {
+	meta.Children = make([]*storage.MetaData, 0, len(finfos))
+	for _, f := range finfos {
+		if isHidden(f.Name()) {
+			continue
+		}
 		uri.Fragment = ""
 		uri.RawQuery = ""
 		childPath := filepath.Join(uri.String(), f.Name())
@@ -135,7 +201,6 @@ func (s *StorageLocal) Stat(authRes *auth.AuthResource, uri *url.URL, children b
 			mimeType = "inode/directory"
 		}
 		m := storage.MetaData{
-			Id:       childPath,
 			Path:     childPath,
 			Size:     uint64(f.Size()),
 			IsCol:    f.IsDir(),
@@ -143,14 +208,23 @@ func (s *StorageLocal) Stat(authRes *auth.AuthResource, uri *url.URL, children b
 			ETag:     fmt.Sprintf("\"%d\"", f.ModTime().Unix()),
 			MimeType: mimeType,
 		}
-		meta.Children[i] = &m
+		if m.Properties, err = s.listProperties(filepath.Join(absPath, f.Name())); err != nil {
+			return nil, s.ConvertError(err)
+		}
+		meta.Children = append(meta.Children, &m)
 	}
 
+	if err := s.setIDs(authRes, uri, &meta); err != nil {
+		return nil, err
+	}
 	return &meta, nil
 }
 
-func (s *StorageLocal) GetFile(authRes *auth.AuthResource, uri *url.URL) (io.Reader, error) {
-	absPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, uri.Path))
+func (s *StorageLocal) GetFile(ctx context.Context, authRes *auth.AuthResource, uri *url.URL) (io.Reader, error) {
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return nil, err
+	}
 	file, err := os.Open(absPath)
 	if err != nil {
 		return nil, s.ConvertError(err)
@@ -158,43 +232,157 @@ func (s *StorageLocal) GetFile(authRes *auth.AuthResource, uri *url.URL) (io.Rea
 	return file, nil
 }
 
-func (s *StorageLocal) Remove(authRes *auth.AuthResource, uri *url.URL, recursive bool) error {
-	absPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, uri.Path))
+func (s *StorageLocal) Remove(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, recursive bool) error {
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return err
+	}
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return err
+	}
+	finfo, err := os.Lstat(absPath)
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	// the ID is needed before the resource is removed.
+	change, err := s.newChange(metaDir, storage.ChangeDelete, relPath(uri), finfo.IsDir())
+	if err != nil {
+		return err
+	}
 	if !recursive {
-		return s.ConvertError(os.Remove(absPath))
+		err = os.Remove(absPath)
+	} else {
+		err = os.RemoveAll(absPath)
+	}
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	if err := s.sidecars.remove(absPath); err != nil {
+		return err
 	}
-	return s.ConvertError(os.RemoveAll(absPath))
+	if err := s.removeIDs(authRes, uri); err != nil {
+		return err
+	}
+	return s.record(metaDir, change)
 }
 
-func (s *StorageLocal) CreateCol(authRes *auth.AuthResource, uri *url.URL, recursive bool) error {
-	absPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, uri.Path))
+func (s *StorageLocal) CreateCol(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, recursive bool) error {
+	homeDir, err := s.homeDir(s.rootDataDir, authRes)
+	if err != nil {
+		return err
+	}
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return err
+	}
+	created := []string{relPath(uri)}
 	if recursive == false {
-		return s.ConvertError(os.Mkdir(absPath, 0666))
+		err = os.Mkdir(absPath, s.dirMode)
+	} else {
+		// the missing parents are created too.
+		for p := path.Dir(created[0]); p != "/"; p = path.Dir(p) {
+			if _, err := os.Lstat(filepath.Join(homeDir, filepath.FromSlash(p))); err == nil {
+				break
+			}
+			created = append([]string{p}, created...)
+		}
+		err = os.MkdirAll(absPath, s.dirMode)
+	}
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return err
 	}
-	return s.ConvertError(os.MkdirAll(absPath, 0666))
+	var changes []*storage.Change
+	for _, p := range created {
+		change, err := s.newChange(metaDir, storage.ChangeCreate, p, true)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change)
+	}
+	return s.record(metaDir, changes...)
 }
 
-func (s *StorageLocal) Copy(authRes *auth.AuthResource, fromUri, toUri *url.URL) error {
-	fromabsPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, fromUri.Path))
-	toabsPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, toUri.Path))
+func (s *StorageLocal) Copy(ctx context.Context, authRes *auth.AuthResource, fromUri, toUri *url.URL) error {
+	fromabsPath, err := s.absPath(authRes, fromUri)
+	if err != nil {
+		return err
+	}
+	toabsPath, err := s.absPath(authRes, toUri)
+	if err != nil {
+		return err
+	}
 	src, err := os.Open(fromabsPath)
+	if err != nil {
+		return s.ConvertError(err)
+	}
 	defer src.Close()
+	props, err := s.listProperties(fromabsPath)
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
 	if err != nil {
 		return err
 	}
-	dst, err := os.Create(toabsPath)
-	defer dst.Close()
+	replaced, err := s.replacedChange(metaDir, toabsPath, toUri)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(dst, src)
-	return err
+	if err := s.writeFile(ctx, authRes, toabsPath, src, props); err != nil {
+		return err
+	}
+	// the copy is a new resource, the file it replaces loses its ID.
+	if err := s.removeIDs(authRes, toUri); err != nil {
+		return err
+	}
+	change, err := s.newChange(metaDir, storage.ChangeCreate, relPath(toUri), false)
+	if err != nil {
+		return err
+	}
+	return s.record(metaDir, append(replaced, change)...)
 }
 
-func (s *StorageLocal) Rename(authRes *auth.AuthResource, fromUri, toUri *url.URL) error {
-	fromabsPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, fromUri.Path))
-	toabsPath := filepath.Clean(filepath.Join(s.rootDataDir, authRes.AuthID, authRes.Username, toUri.Path))
-	return s.ConvertError(os.Rename(fromabsPath, toabsPath))
+func (s *StorageLocal) Rename(ctx context.Context, authRes *auth.AuthResource, fromUri, toUri *url.URL) error {
+	fromabsPath, err := s.absPath(authRes, fromUri)
+	if err != nil {
+		return err
+	}
+	toabsPath, err := s.absPath(authRes, toUri)
+	if err != nil {
+		return err
+	}
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return err
+	}
+	replaced, err := s.replacedChange(metaDir, toabsPath, toUri)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(fromabsPath, toabsPath); err != nil {
+		return s.ConvertError(err)
+	}
+	if err := s.sidecars.rename(fromabsPath, toabsPath); err != nil {
+		return err
+	}
+	if err := s.ConvertError(s.ids.rename(metaDir, relPath(fromUri), relPath(toUri))); err != nil {
+		return err
+	}
+	finfo, err := os.Lstat(toabsPath)
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	change, err := s.newChange(metaDir, storage.ChangeMove, relPath(toUri), finfo.IsDir())
+	if err != nil {
+		return err
+	}
+	change.OldPath = s.uriString(relPath(fromUri))
+	return s.record(metaDir, append(replaced, change)...)
 }
 
 func (s *StorageLocal) ConvertError(err error) error {
@@ -214,7 +402,27 @@ func (s *StorageLocal) GetCapabilities() *storage.Capabilities {
 	return &cap
 }
 
-func (s *StorageLocal) commitPutFile(from, to string) error {
-	toabsPath := filepath.Join(s.rootDataDir, to)
-	return os.Rename(from, toabsPath)
+// homeDir returns the home directory of the user under root, following the home layout.
+func (s *StorageLocal) homeDir(root string, authRes *auth.AuthResource) (string, error) {
+	homePath, err := s.layout.HomePath(authRes)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(homePath)), nil
+}
+
+// absPath returns the path in the local filesystem of the URI in the home directory of the user.
+// The paths with the name of a hidden file are rejected, so the users cannot access them.
+func (s *StorageLocal) absPath(authRes *auth.AuthResource, uri *url.URL) (string, error) {
+	homeDir, err := s.homeDir(s.rootDataDir, authRes)
+	if err != nil {
+		return "", err
+	}
+	p := path.Clean("/" + uri.Path)
+	for _, name := range strings.Split(p, "/") {
+		if isHidden(name) {
+			return "", &storage.PermissionDeniedError{fmt.Sprintf("the name %s is reserved", name)}
+		}
+	}
+	return filepath.Join(homeDir, filepath.FromSlash(p)), nil
 }
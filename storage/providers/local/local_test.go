@@ -0,0 +1,325 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	storagepkg "github.com/syncato/lib/storage"
+
+	. "gopkg.in/check.v1"
+)
+
+// failingReader returns an error after returning its data.
+type failingReader struct {
+	r io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+// cancellingReader cancels the context after returning its first read.
+type cancellingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:5])
+	r.cancel()
+	return n, err
+}
+
+type LocalSuite struct {
+	dataDir string
+	tmpDir  string
+	metaDir string
+	cfg     *config.Config
+	alice   *auth.AuthResource
+}
+
+var _ = Suite(&LocalSuite{})
+
+func (s *LocalSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	s.dataDir = filepath.Join(dir, "data")
+	s.tmpDir = filepath.Join(dir, "tmp")
+	s.metaDir = filepath.Join(dir, "meta")
+	cfgFile := filepath.Join(dir, "config.json")
+	data, err := json.Marshal(map[string]interface{}{"root_data_dir": s.dataDir, "root_tmp_dir": s.tmpDir, "root_meta_dir": s.metaDir, "storage_local_umask": "0027"})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+	s.cfg, err = config.New(cfgFile, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	s.alice = &auth.AuthResource{AuthID: "json", Username: "alice"}
+}
+
+func (s *LocalSuite) TestPutFile(c *C) {
+	// an upload interrupted by a previous run.
+	c.Assert(os.MkdirAll(filepath.Join(s.tmpDir, "json", "alice"), 0700), IsNil)
+	orphan := filepath.Join(s.tmpDir, "json", "alice", tmpFilePrefix+"123")
+	c.Assert(ioutil.WriteFile(orphan, []byte("partial"), 0600), IsNil)
+
+	storage, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	_, err = os.Stat(orphan)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	ctx := context.Background()
+	c.Assert(storage.CreateUserHome(ctx, s.alice), IsNil)
+	c.Assert(storage.CreateCol(ctx, s.alice, &url.URL{Path: "/docs"}, false), IsNil)
+	uri := &url.URL{Path: "/docs/notes.txt"}
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("first"), 5), IsNil)
+
+	home := filepath.Join(s.dataDir, "json", "alice")
+	finfo, err := os.Stat(filepath.Join(home, "docs"))
+	c.Assert(err, IsNil)
+	c.Assert(finfo.Mode().Perm(), Equals, os.FileMode(0750))
+	finfo, err = os.Stat(filepath.Join(home, "docs", "notes.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(finfo.Mode().Perm(), Equals, os.FileMode(0640))
+
+	// a failed upload does not modify the file and leaves no temporary file.
+	err = storage.PutFile(ctx, s.alice, uri, &failingReader{strings.NewReader("second")}, 6)
+	c.Assert(err, NotNil)
+	data, err := ioutil.ReadFile(filepath.Join(home, "docs", "notes.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "first")
+	tmpFiles, err := ioutil.ReadDir(filepath.Join(s.tmpDir, "json", "alice"))
+	c.Assert(err, IsNil)
+	c.Assert(tmpFiles, HasLen, 0)
+
+	c.Assert(storage.Copy(ctx, s.alice, uri, &url.URL{Path: "/notes-copy.txt"}), IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(home, "notes-copy.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "first")
+}
+
+func (s *LocalSuite) TestInvalidUmask(c *C) {
+	cfgFile := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(`{"storage_local_umask": "0999"}`), 0600), IsNil)
+	cfg, err := config.New(cfgFile, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	_, err = NewStorageLocal("local", cfg, logger.NewLogger("test", 0))
+	c.Assert(err, NotNil)
+}
+
+func (s *LocalSuite) TestProperties(c *C) {
+	storage, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+	c.Assert(storage.CreateUserHome(ctx, s.alice), IsNil)
+	uri := &url.URL{Path: "/notes.txt"}
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("first"), 5), IsNil)
+
+	c.Assert(storage.SetProperty(ctx, s.alice, uri, "{DAV:}displayname", "Notes"), IsNil)
+	c.Assert(storage.SetProperty(ctx, s.alice, uri, "favorite", "1"), IsNil)
+	value, err := storage.GetProperty(ctx, s.alice, uri, "favorite")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "1")
+
+	// the properties are kept when the file is overwritten, copied and renamed.
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("second"), 6), IsNil)
+	c.Assert(storage.Copy(ctx, s.alice, uri, &url.URL{Path: "/copy.txt"}), IsNil)
+	c.Assert(storage.Rename(ctx, s.alice, &url.URL{Path: "/copy.txt"}, &url.URL{Path: "/renamed.txt"}), IsNil)
+	props, err := storage.ListProperties(ctx, s.alice, &url.URL{Path: "/renamed.txt"})
+	c.Assert(err, IsNil)
+	c.Assert(props, DeepEquals, map[string]string{"{DAV:}displayname": "Notes", "favorite": "1"})
+
+	c.Assert(storage.RemoveProperty(ctx, s.alice, uri, "favorite"), IsNil)
+	c.Assert(storage.RemoveProperty(ctx, s.alice, uri, "favorite"), IsNil)
+	_, err = storage.GetProperty(ctx, s.alice, uri, "favorite")
+	c.Assert(err, NotNil)
+
+	meta, err := storage.Stat(ctx, s.alice, &url.URL{Path: "/"}, true)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Children, HasLen, 2)
+	for _, child := range meta.Children {
+		c.Assert(child.Properties["{DAV:}displayname"], Equals, "Notes")
+	}
+}
+
+func (s *LocalSuite) TestHiddenFiles(c *C) {
+	storage, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+	c.Assert(storage.CreateUserHome(ctx, s.alice), IsNil)
+	uri := &url.URL{Path: "/notes.txt"}
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("first"), 5), IsNil)
+	c.Assert(storage.SetProperty(ctx, s.alice, uri, "favorite", "1"), IsNil)
+
+	// the sidecar files cannot be read, written, removed or used as the source or target of a rename.
+	sidecar := &url.URL{Path: "/" + sidecarPrefix + "notes.txt" + sidecarSuffix}
+	_, err = storage.GetFile(ctx, s.alice, sidecar)
+	c.Assert(storagepkg.IsPermissionDeniedError(err), Equals, true)
+	err = storage.PutFile(ctx, s.alice, sidecar, strings.NewReader("{}"), 2)
+	c.Assert(storagepkg.IsPermissionDeniedError(err), Equals, true)
+	c.Assert(storagepkg.IsPermissionDeniedError(storage.Remove(ctx, s.alice, sidecar, false)), Equals, true)
+	c.Assert(storagepkg.IsPermissionDeniedError(storage.Rename(ctx, s.alice, sidecar, &url.URL{Path: "/props.json"})), Equals, true)
+	c.Assert(storagepkg.IsPermissionDeniedError(storage.Rename(ctx, s.alice, uri, sidecar)), Equals, true)
+	c.Assert(storagepkg.IsPermissionDeniedError(storage.CreateCol(ctx, s.alice, &url.URL{Path: sidecar.Path + "/docs"}, true)), Equals, true)
+
+	// the names of the index and the journal are not reserved, they are outside the home.
+	c.Assert(storage.PutFile(ctx, s.alice, &url.URL{Path: "/" + idIndexName}, strings.NewReader("{}"), 2), IsNil)
+	value, err := storage.GetProperty(ctx, s.alice, uri, "favorite")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "1")
+}
+
+func (s *LocalSuite) TestSidecarStore(c *C) {
+	dir := c.MkDir()
+	file := filepath.Join(dir, "notes.txt")
+	c.Assert(ioutil.WriteFile(file, []byte("notes"), 0600), IsNil)
+	st := &sidecarStore{}
+
+	c.Assert(st.update(file, func(props map[string]string) { props["favorite"] = "1" }), IsNil)
+	c.Assert(st.rename(file, filepath.Join(dir, "renamed.txt")), IsNil)
+	c.Assert(os.Rename(file, filepath.Join(dir, "renamed.txt")), IsNil)
+	props, err := st.read(filepath.Join(dir, "renamed.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(props, DeepEquals, map[string]string{"favorite": "1"})
+	_, err = st.read(file)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(st.replace(filepath.Join(dir, "renamed.txt"), nil), IsNil)
+	finfos, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(finfos, HasLen, 1)
+}
+
+func (s *LocalSuite) TestIDs(c *C) {
+	storage, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+	c.Assert(storage.CreateUserHome(ctx, s.alice), IsNil)
+	c.Assert(storage.CreateCol(ctx, s.alice, &url.URL{Path: "/docs"}, false), IsNil)
+	uri := &url.URL{Path: "/docs/notes.txt"}
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("first"), 5), IsNil)
+
+	meta, err := storage.Stat(ctx, s.alice, &url.URL{Path: "/docs"}, true)
+	c.Assert(err, IsNil)
+	c.Assert(meta.Children, HasLen, 1)
+	fileID := meta.Children[0].Id
+	c.Assert(strings.HasPrefix(fileID, "local:"), Equals, true)
+
+	// the ID is kept when the file is overwritten and when its collection is renamed.
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("second"), 6), IsNil)
+	c.Assert(storage.Rename(ctx, s.alice, &url.URL{Path: "/docs"}, &url.URL{Path: "/archive"}), IsNil)
+	renamed, err := storage.Stat(ctx, s.alice, &url.URL{Path: "/archive/notes.txt"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(renamed.Id, Equals, fileID)
+	c.Assert(renamed.Id, Not(Equals), meta.Id)
+	p, err := storage.GetPathByID(ctx, s.alice, strings.TrimPrefix(fileID, "local:"))
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "local:///archive/notes.txt")
+
+	// a copy is a new resource.
+	c.Assert(storage.Copy(ctx, s.alice, &url.URL{Path: "/archive/notes.txt"}, &url.URL{Path: "/copy.txt"}), IsNil)
+	copied, err := storage.Stat(ctx, s.alice, &url.URL{Path: "/copy.txt"}, false)
+	c.Assert(err, IsNil)
+	c.Assert(copied.Id, Not(Equals), fileID)
+
+	// the index is kept outside the home and read again by a new storage.
+	root, err := storage.Stat(ctx, s.alice, &url.URL{Path: "/"}, true)
+	c.Assert(err, IsNil)
+	c.Assert(root.Children, HasLen, 2)
+	_, err = os.Stat(filepath.Join(s.metaDir, "json", "alice", idIndexName))
+	c.Assert(err, IsNil)
+	reopened, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	p, err = reopened.GetPathByID(ctx, s.alice, strings.TrimPrefix(fileID, "local:"))
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, "local:///archive/notes.txt")
+
+	// the IDs of removed resources are not found.
+	c.Assert(storage.Remove(ctx, s.alice, &url.URL{Path: "/archive"}, true), IsNil)
+	_, err = storage.GetPathByID(ctx, s.alice, strings.TrimPrefix(fileID, "local:"))
+	c.Assert(err, NotNil)
+}
+
+func (s *LocalSuite) TestChanges(c *C) {
+	storage, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+	c.Assert(storage.CreateUserHome(ctx, s.alice), IsNil)
+	c.Assert(storage.CreateCol(ctx, s.alice, &url.URL{Path: "/docs/2015"}, true), IsNil)
+	uri := &url.URL{Path: "/docs/notes.txt"}
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("first"), 5), IsNil)
+	c.Assert(storage.PutFile(ctx, s.alice, uri, strings.NewReader("second"), 6), IsNil)
+	c.Assert(storage.SetProperty(ctx, s.alice, uri, "favorite", "1"), IsNil)
+	c.Assert(storage.Rename(ctx, s.alice, uri, &url.URL{Path: "/notes.txt"}), IsNil)
+	c.Assert(storage.Remove(ctx, s.alice, &url.URL{Path: "/docs"}, true), IsNil)
+
+	list, err := storage.GetChanges(ctx, s.alice, 0, 4)
+	c.Assert(err, IsNil)
+	c.Assert(list.HasMore, Equals, true)
+	c.Assert(list.Changes, HasLen, 4)
+	first := list.Changes[0].Cursor
+	c.Assert(list.Cursor, Equals, first+3)
+	c.Assert(string(list.Changes[0].Type), Equals, "create")
+	c.Assert(list.Changes[0].Path, Equals, "local:///docs")
+	c.Assert(list.Changes[1].Path, Equals, "local:///docs/2015")
+	c.Assert(string(list.Changes[2].Type), Equals, "create")
+	c.Assert(string(list.Changes[3].Type), Equals, "modify")
+	fileID := list.Changes[2].Id
+
+	list, err = storage.GetChanges(ctx, s.alice, list.Cursor, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.HasMore, Equals, false)
+	c.Assert(list.Changes, HasLen, 3)
+	c.Assert(string(list.Changes[0].Type), Equals, "modify")
+	c.Assert(string(list.Changes[1].Type), Equals, "move")
+	c.Assert(list.Changes[1].Id, Equals, fileID)
+	c.Assert(list.Changes[1].OldPath, Equals, "local:///docs/notes.txt")
+	c.Assert(string(list.Changes[2].Type), Equals, "delete")
+	c.Assert(list.Changes[2].IsCol, Equals, true)
+
+	list, err = storage.GetChanges(ctx, s.alice, list.Cursor, 10)
+	c.Assert(err, IsNil)
+	c.Assert(list.Changes, HasLen, 0)
+	c.Assert(list.Cursor, Equals, first+6)
+	_, err = storage.GetChanges(ctx, s.alice, first+7, 10)
+	c.Assert(err, NotNil)
+}
+
+func (s *LocalSuite) TestCopyContext(c *C) {
+	var buf bytes.Buffer
+	n, err := storagepkg.CopyContext(context.Background(), &buf, strings.NewReader("notes"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(5))
+
+	// the copy stops with the error of the context when it is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	buf.Reset()
+	n, err = storagepkg.CopyContext(ctx, &buf, &cancellingReader{strings.NewReader("first second"), cancel})
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(n, Equals, int64(5))
+	c.Assert(buf.String(), Equals, "first")
+
+	// an upload with a cancelled context does not modify the file.
+	storage, err := NewStorageLocal("local", s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	c.Assert(storage.CreateUserHome(context.Background(), s.alice), IsNil)
+	uri := &url.URL{Path: "/notes.txt"}
+	c.Assert(storage.PutFile(context.Background(), s.alice, uri, strings.NewReader("first"), 5), IsNil)
+	err = storage.PutFile(ctx, s.alice, uri, strings.NewReader("second"), 6)
+	c.Assert(err, Equals, context.Canceled)
+	data, err := ioutil.ReadFile(filepath.Join(s.dataDir, "json", "alice", "notes.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "first")
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+)
+
+// HomeMove is the relocation of the home directory of a user from one layout to another.
+type HomeMove struct {
+	AuthID   string `json:"authid"`
+	Username string `json:"username"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// MigrateHomes relocates the home directories under root from one layout to another and returns the moves done.
+// If dryRun is true, the moves are only returned.
+//
+// The homes are found walking root to the depth of the old layout, so the directories that are not homes
+// of the old layout are ignored. The directories of the old layout that become empty are removed.
+// If the new path of a home already exists or a move fails, the migration stops and the homes not moved yet
+// are left in a staging directory under root, named in the error.
+// The daemon must be stopped while migrating and the layout in the configuration changed before starting it again.
+func MigrateHomes(root string, from, to *storage.HomeLayout, dryRun bool, log *logger.Logger) ([]*HomeMove, error) {
+	var moves []*HomeMove
+	var walk func(rel string, depth int) error
+	walk = func(rel string, depth int) error {
+		if depth == from.Depth() {
+			authID, username, ok := from.ParseHomePath(rel)
+			if !ok {
+				log.Warn("directory is not a home of the layout", map[string]interface{}{"path": rel, "layout": from.String()})
+				return nil
+			}
+			newRel, err := to.HomePath(&auth.AuthResource{AuthID: authID, Username: username})
+			if err != nil {
+				return err
+			}
+			if newRel != rel {
+				moves = append(moves, &HomeMove{AuthID: authID, Username: username, From: rel, To: newRel})
+			}
+			return nil
+		}
+		finfos, err := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		for _, f := range finfos {
+			if f.IsDir() {
+				if err := walk(path.Join(rel, f.Name()), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk("", 0); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return moves, nil
+	}
+
+	// the homes are first moved to a staging directory, so a home is never moved inside another
+	// home that has not been moved yet, like the home of the user a and the shard a of the new layout.
+	stagingDir, err := ioutil.TempDir(root, ".home-migration-")
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range moves {
+		if err := os.Rename(filepath.Join(root, filepath.FromSlash(m.From)), filepath.Join(stagingDir, fmt.Sprint(i))); err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot stage home of %s in %s: %s", m.Username, stagingDir, err.Error()))
+		}
+		removeEmptyParents(root, path.Dir(m.From))
+	}
+	done := make([]*HomeMove, 0, len(moves))
+	for i, m := range moves {
+		toPath := filepath.Join(root, filepath.FromSlash(m.To))
+		if _, err := os.Stat(toPath); err == nil {
+			return done, errors.New(fmt.Sprintf("cannot move home of %s to %s: it already exists, the home is in %s", m.Username, m.To, stagingDir))
+		}
+		if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+			return done, err
+		}
+		if err := os.Rename(filepath.Join(stagingDir, fmt.Sprint(i)), toPath); err != nil {
+			return done, err
+		}
+		log.Info("home moved", map[string]interface{}{"username": m.Username, "authid": m.AuthID, "from": m.From, "to": m.To})
+		done = append(done, m)
+	}
+	return done, os.Remove(stagingDir)
+}
+
+// removeEmptyParents removes the directory rel under root and its parents while they are empty.
+func removeEmptyParents(root, rel string) {
+	for rel != "." && rel != "/" && rel != "" {
+		if os.Remove(filepath.Join(root, filepath.FromSlash(rel))) != nil {
+			return
+		}
+		rel = path.Dir(rel)
+	}
+}
@@ -0,0 +1,107 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+var homePathTests = []struct {
+	layout   string
+	authID   string
+	username string
+	expected string
+}{
+	{"", "ldap", "alice", "ldap/alice"},
+	{"legacy", "ldap", "a b@c", "ldap/a b@c"},
+	{"{authid}/{username}", "ldap", "a b@c", "ldap/a%20b@c"},
+	{"{authid}/{username[0]}/{username}", "ldap", "alice", "ldap/a/alice"},
+	{"homes/{authid}/{username[1]}/{username}", "json", "a", "homes/json/_/a"},
+	{"{authid}/{username}", "ldap", "../etc/passwd", "ldap/%2E.%2Fetc%2Fpasswd"},
+	{"{authid}/{username[0]}/{username}", "", "dept/bob", "_/d/dept%2Fbob"},
+}
+
+func (s *TestSuite) TestHomePath(c *C) {
+	for _, t := range homePathTests {
+		layout, err := storage.NewHomeLayout(t.layout)
+		c.Assert(err, IsNil)
+		p, err := layout.HomePath(&auth.AuthResource{AuthID: t.authID, Username: t.username})
+		c.Assert(err, IsNil)
+		c.Assert(p, Equals, t.expected)
+		authID, username, ok := layout.ParseHomePath(p)
+		c.Assert(ok, Equals, true)
+		c.Assert(username, Equals, t.username)
+		if t.authID != "" {
+			c.Assert(authID, Equals, t.authID)
+		}
+	}
+	for _, layout := range []string{"{authid}", "{authid}/../{username}", "{user}/{username}", "u-{username}"} {
+		_, err := storage.NewHomeLayout(layout)
+		c.Assert(err, NotNil)
+	}
+	// the legacy layout uses the values as they are, so they must be valid path elements.
+	legacy, err := storage.NewHomeLayout("")
+	c.Assert(err, IsNil)
+	for _, username := range []string{"", "..", "../etc/passwd", "dept/bob"} {
+		_, err := legacy.HomePath(&auth.AuthResource{AuthID: "ldap", Username: username})
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *TestSuite) TestMigrateHomes(c *C) {
+	root := c.MkDir()
+	// the homes created before the layouts were configurable, with raw usernames.
+	homes := []string{"ldap/a", "ldap/alice", "ldap/a@b", "ldap/a b", "ldap/dept%2Fbob"}
+	for _, home := range homes {
+		c.Assert(os.MkdirAll(filepath.Join(root, home), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(root, home, "file"), []byte(home), 0644), IsNil)
+	}
+	from, err := storage.NewHomeLayout("")
+	c.Assert(err, IsNil)
+	to, err := storage.NewHomeLayout("{authid}/{username[0]}/{username}")
+	c.Assert(err, IsNil)
+	log := logger.NewLogger("test", 0)
+
+	moves, err := MigrateHomes(root, from, to, true, log)
+	c.Assert(err, IsNil)
+	c.Assert(moves, HasLen, 5)
+	_, err = os.Stat(filepath.Join(root, "ldap/alice"))
+	c.Assert(err, IsNil)
+
+	moves, err = MigrateHomes(root, from, to, false, log)
+	c.Assert(err, IsNil)
+	c.Assert(moves, HasLen, 5)
+	for _, home := range homes {
+		authID, username, ok := from.ParseHomePath(home)
+		c.Assert(ok, Equals, true)
+		newHome, err := to.HomePath(&auth.AuthResource{AuthID: authID, Username: username})
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadFile(filepath.Join(root, newHome, "file"))
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, home)
+	}
+	finfos, err := ioutil.ReadDir(root)
+	c.Assert(err, IsNil)
+	c.Assert(finfos, HasLen, 1)
+
+	// the raw home of a@b is found by the new layout.
+	data, err := ioutil.ReadFile(filepath.Join(root, "ldap", "a", "a@b", "file"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "ldap/a@b")
+	data, err = ioutil.ReadFile(filepath.Join(root, "ldap", "a", "a%20b", "file"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "ldap/a b")
+}
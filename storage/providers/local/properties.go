@@ -0,0 +1,262 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/syncato/lib/atomicfile"
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// The custom properties of the resources are kept in extended attributes named user.syncato.<name>.
+// If the filesystem does not support extended attributes, they are kept in a hidden sidecar JSON file
+// next to the resource, named .syncato-props.<name of the resource>.json, that moves with the resource.
+const (
+	xattrPrefix   = "user.syncato."
+	sidecarPrefix = ".syncato-props."
+	sidecarSuffix = ".json"
+)
+
+var (
+	errXattrNotSupported = errors.New("extended attributes not supported")
+	errXattrNotExist     = errors.New("extended attribute not set")
+)
+
+func (s *StorageLocal) GetProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name string) (string, error) {
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return "", err
+	}
+	value, err := getXattr(absPath, xattrPrefix+name)
+	if err == errXattrNotSupported {
+		props, err := s.sidecars.read(absPath)
+		if err != nil {
+			return "", s.ConvertError(err)
+		}
+		v, ok := props[name]
+		if !ok {
+			return "", &storage.NotExistError{fmt.Sprintf("property %s not set", name)}
+		}
+		return v, nil
+	}
+	if err == errXattrNotExist {
+		return "", &storage.NotExistError{fmt.Sprintf("property %s not set", name)}
+	}
+	return value, s.ConvertError(err)
+}
+
+func (s *StorageLocal) SetProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name, value string) error {
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return err
+	}
+	err = setXattr(absPath, xattrPrefix+name, value)
+	if err == errXattrNotSupported {
+		err = s.sidecars.update(absPath, func(props map[string]string) {
+			props[name] = value
+		})
+	}
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	return s.recordModify(authRes, uri, absPath)
+}
+
+func (s *StorageLocal) RemoveProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name string) error {
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return err
+	}
+	err = removeXattr(absPath, xattrPrefix+name)
+	if err == errXattrNotSupported {
+		err = s.sidecars.update(absPath, func(props map[string]string) {
+			delete(props, name)
+		})
+	}
+	if err == errXattrNotExist {
+		return nil
+	}
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	return s.recordModify(authRes, uri, absPath)
+}
+
+func (s *StorageLocal) ListProperties(ctx context.Context, authRes *auth.AuthResource, uri *url.URL) (map[string]string, error) {
+	absPath, err := s.absPath(authRes, uri)
+	if err != nil {
+		return nil, err
+	}
+	props, err := s.listProperties(absPath)
+	return props, s.ConvertError(err)
+}
+
+// recordModify records the modification of the custom properties of the resource in the change journal.
+func (s *StorageLocal) recordModify(authRes *auth.AuthResource, uri *url.URL, absPath string) error {
+	metaDir, err := s.homeDir(s.rootMetaDir, authRes)
+	if err != nil {
+		return err
+	}
+	finfo, err := os.Lstat(absPath)
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	change, err := s.newChange(metaDir, storage.ChangeModify, relPath(uri), finfo.IsDir())
+	if err != nil {
+		return err
+	}
+	return s.record(metaDir, change)
+}
+
+// listProperties returns the custom properties of the file at absPath.
+func (s *StorageLocal) listProperties(absPath string) (map[string]string, error) {
+	names, err := listXattrs(absPath)
+	if err == errXattrNotSupported {
+		return s.sidecars.read(absPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+		value, err := getXattr(absPath, name)
+		if err == errXattrNotExist {
+			// removed while listing.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		props[strings.TrimPrefix(name, xattrPrefix)] = value
+	}
+	return props, nil
+}
+
+// setXattrs sets the custom properties to the file at absPath with extended attributes.
+// It returns false if there are no properties or the filesystem does not support extended attributes.
+func setXattrs(absPath string, props map[string]string) (bool, error) {
+	for name, value := range props {
+		err := setXattr(absPath, xattrPrefix+name, value)
+		if err == errXattrNotSupported {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return len(props) > 0, nil
+}
+
+// isSidecar checks if the name of a file is the name of a sidecar file, which are hidden to the users.
+func isSidecar(name string) bool {
+	return strings.HasPrefix(name, sidecarPrefix)
+}
+
+// sidecarStore keeps the custom properties of the resources in sidecar files.
+type sidecarStore struct {
+	sync.Mutex
+}
+
+// path returns the path of the sidecar file of the file at absPath.
+func (st *sidecarStore) path(absPath string) string {
+	return filepath.Join(filepath.Dir(absPath), sidecarPrefix+filepath.Base(absPath)+sidecarSuffix)
+}
+
+// read returns the properties of the file at absPath. The file must exist.
+func (st *sidecarStore) read(absPath string) (map[string]string, error) {
+	st.Lock()
+	defer st.Unlock()
+	return st.load(absPath)
+}
+
+// update modifies the properties of the file at absPath. The file must exist.
+// The sidecar file is removed when the file has no properties.
+func (st *sidecarStore) update(absPath string, fn func(props map[string]string)) error {
+	st.Lock()
+	defer st.Unlock()
+	props, err := st.load(absPath)
+	if err != nil {
+		return err
+	}
+	fn(props)
+	if len(props) == 0 {
+		if err := os.Remove(st.path(absPath)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(st.path(absPath), data, 0600)
+}
+
+// replace replaces all the properties of the file at absPath.
+func (st *sidecarStore) replace(absPath string, props map[string]string) error {
+	return st.update(absPath, func(p map[string]string) {
+		for name := range p {
+			delete(p, name)
+		}
+		for name, value := range props {
+			p[name] = value
+		}
+	})
+}
+
+// load reads the sidecar file of the file at absPath.
+func (st *sidecarStore) load(absPath string) (map[string]string, error) {
+	if _, err := os.Lstat(absPath); err != nil {
+		return nil, err
+	}
+	props := map[string]string{}
+	data, err := ioutil.ReadFile(st.path(absPath))
+	if os.IsNotExist(err) {
+		return props, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return props, json.Unmarshal(data, &props)
+}
+
+// rename moves the sidecar file of a file that has been renamed.
+func (st *sidecarStore) rename(fromPath, toPath string) error {
+	st.Lock()
+	defer st.Unlock()
+	err := os.Rename(st.path(fromPath), st.path(toPath))
+	if os.IsNotExist(err) {
+		// the file has no properties, but the properties of the file it replaces must not be kept.
+		err = os.Remove(st.path(toPath))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// remove removes the sidecar file of a file that has been removed.
+func (st *sidecarStore) remove(absPath string) error {
+	st.Lock()
+	defer st.Unlock()
+	if err := os.Remove(st.path(absPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// tmpFilePrefix is the prefix of the temporary files of the uploads, so they are never confused with
+// other files in the temporary directory.
+const tmpFilePrefix = ".syncato-upload-"
+
+// writeFile writes the data of r to a new temporary file in the temporary directory of the user, created
+// on demand, and commits it to absPath with the custom properties props. The temporary file is removed if
+// anything fails.
+func (s *StorageLocal) writeFile(ctx context.Context, authRes *auth.AuthResource, absPath string, r io.Reader, props map[string]string) error {
+	tmpDir, err := s.homeDir(s.rootTmpDir, authRes)
+	if err != nil {
+		return err
+	}
+	// only the daemon reads the temporary files.
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return s.ConvertError(err)
+	}
+	fd, err := ioutil.TempFile(tmpDir, tmpFilePrefix)
+	if err != nil {
+		return s.ConvertError(err)
+	}
+	if err := s.fillFile(ctx, fd, r); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	usedXattrs, err := setXattrs(fd.Name(), props)
+	if err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := s.commitFile(fd.Name(), absPath); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	if !usedXattrs {
+		return s.sidecars.replace(absPath, props)
+	}
+	return nil
+}
+
+// fillFile writes the data of r to the file and flushes it to disk before closing it.
+func (s *StorageLocal) fillFile(ctx context.Context, fd *os.File, r io.Reader) error {
+	defer fd.Close()
+	if _, err := storage.CopyContext(ctx, fd, r); err != nil {
+		return s.ConvertError(err)
+	}
+	if err := fd.Chmod(s.fileMode); err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+// commitFile renames the temporary file to its final path and flushes the directory containing it, so after
+// a crash the file is either the old one or the new one but never partially written.
+// The temporary directory must be in the same filesystem as the data directory for the rename to be atomic.
+func (s *StorageLocal) commitFile(tmpPath, absPath string) error {
+	if err := os.Rename(tmpPath, absPath); err != nil {
+		return s.ConvertError(err)
+	}
+	return syncDir(filepath.Dir(absPath))
+}
+
+// syncDir flushes the entries of a directory to disk.
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
+
+// removeTmpFiles removes the temporary files of the uploads that were interrupted, like when the daemon is killed.
+// It must only be called on startup, before any upload starts.
+func (s *StorageLocal) removeTmpFiles() error {
+	if s.rootTmpDir == "" {
+		return nil
+	}
+	removed := 0
+	err := filepath.Walk(s.rootTmpDir, func(p string, finfo os.FileInfo, err error) error {
+		// the temporary directory can be shared with other programs, their directories are skipped.
+		if err != nil {
+			return nil
+		}
+		if finfo.Mode().IsRegular() && strings.HasPrefix(finfo.Name(), tmpFilePrefix) {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		s.log.Info("orphaned temporary files removed", map[string]interface{}{"scheme": s.scheme, "files": removed})
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package local
+
+import (
+	"strings"
+	"syscall"
+)
+
+// getXattr returns the value of the extended attribute of the file.
+func getXattr(absPath, name string) (string, error) {
+	size, err := syscall.Getxattr(absPath, name, nil)
+	if err != nil {
+		return "", convertXattrError(err)
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(absPath, name, buf)
+	if err != nil {
+		return "", convertXattrError(err)
+	}
+	return string(buf[:size]), nil
+}
+
+// setXattr sets the extended attribute of the file.
+func setXattr(absPath, name, value string) error {
+	return convertXattrError(syscall.Setxattr(absPath, name, []byte(value), 0))
+}
+
+// removeXattr removes the extended attribute of the file.
+func removeXattr(absPath, name string) error {
+	return convertXattrError(syscall.Removexattr(absPath, name))
+}
+
+// listXattrs returns the names of the extended attributes of the file.
+func listXattrs(absPath string) ([]string, error) {
+	size, err := syscall.Listxattr(absPath, nil)
+	if err != nil {
+		return nil, convertXattrError(err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(absPath, buf)
+	if err != nil {
+		return nil, convertXattrError(err)
+	}
+	// the names are separated by null characters.
+	return strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00"), nil
+}
+
+// convertXattrError converts the errors meaning that extended attributes are not supported or not set.
+func convertXattrError(err error) error {
+	switch err {
+	case syscall.ENOTSUP:
+		return errXattrNotSupported
+	case syscall.ENODATA:
+		return errXattrNotExist
+	}
+	return err
+}
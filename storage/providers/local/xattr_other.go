@@ -0,0 +1,26 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package local
+
+// Extended attributes are only used on Linux, the other platforms always use sidecar files.
+
+func getXattr(absPath, name string) (string, error) {
+	return "", errXattrNotSupported
+}
+
+func setXattr(absPath, name, value string) error {
+	return errXattrNotSupported
+}
+
+func removeXattr(absPath, name string) error {
+	return errXattrNotSupported
+}
+
+func listXattrs(absPath string) ([]string, error) {
+	return nil, errXattrNotSupported
+}
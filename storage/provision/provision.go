@@ -0,0 +1,249 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package provision defines the provisioner that prepares the home directories of the users when they log in.
+package provision
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/syncato/lib/auth"
+	authtoken "github.com/syncato/lib/auth/token"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+	storagemux "github.com/syncato/lib/storage/mux"
+	"github.com/syncato/lib/storage/quota"
+)
+
+// Hook is a function called after the home directories of a user have been created in the storages with
+// the schemes passed, like the creation of default shares or the notification of the administrators.
+type Hook func(ctx context.Context, authRes *auth.AuthResource, schemes []string) error
+
+// Provisioner creates the home directories of the users in the storages in CreateUserHomeInStorages when they
+// log in, if CreateUserHomeOnLogin is enabled. Provision is meant to be added as a login hook of the auth
+// multiplexer.
+//
+// For every new home directory the files of CreateUserHomeSkeletonDir are copied to it. When any home
+// directory has been created, the user gets the CreateUserHomeQuota if it has no quota in StorageQuotas,
+// the quota usage is recalculated and the hooks are run.
+//
+// Every step done is saved in CreateUserHomeStateFile, so a failed step, and the steps after it, are done on
+// the next login, and a user is provisioned only when all the steps have been done.
+// The storages are accessed as the user itself, without the restrictions of the credentials used to log in.
+type Provisioner struct {
+	storageMux *storagemux.StorageMux
+	cfg        *config.Config
+	log        *logger.Logger
+	hooks      []Hook
+	filename   string
+	states     map[string]*userState  // AuthID/Username => state
+	userLocks  map[string]*sync.Mutex // AuthID/Username => lock held while provisioning the user
+	sync.Mutex
+}
+
+// userState is the provisioning steps done for a user.
+type userState struct {
+	Created     []string        `json:"created"`     // the schemes of the storages where the home has been created.
+	Skeleton    map[string]bool `json:"skeleton"`    // scheme => the skeleton has been copied to the home.
+	Quota       bool            `json:"quota"`       // the quota has been set.
+	Hooks       int             `json:"hooks"`       // the number of hooks run, in the order they were added.
+	Provisioned bool            `json:"provisioned"` // all the steps have been done.
+}
+
+// NewProvisioner returns a Provisioner object or an error.
+func NewProvisioner(storageMux *storagemux.StorageMux, cfg *config.Config, log *logger.Logger) (*Provisioner, error) {
+	p := &Provisioner{
+		storageMux: storageMux,
+		cfg:        cfg,
+		log:        log,
+		filename:   cfg.CreateUserHomeStateFile(),
+		states:     map[string]*userState{},
+		userLocks:  map[string]*sync.Mutex{},
+	}
+	if err := authtoken.LoadJSON(p.filename, &p.states); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AddHook adds a hook run after provisioning a user. The hooks run in the order they were added.
+func (p *Provisioner) AddHook(hook Hook) {
+	p.Lock()
+	p.hooks = append(p.hooks, hook)
+	p.Unlock()
+}
+
+// Provision does the provisioning steps of the user that have not been done yet.
+// The users already provisioned are remembered so logging in again does not access the storages.
+func (p *Provisioner) Provision(ctx context.Context, authRes *auth.AuthResource) error {
+	if !p.cfg.CreateUserHomeOnLogin() {
+		return nil
+	}
+	key := authRes.AuthID + "/" + authRes.Username
+	// only the logins of the same user wait for each other.
+	userLock := p.userLock(key)
+	userLock.Lock()
+	defer userLock.Unlock()
+	st, hooks := p.state(key)
+	if st.Provisioned {
+		return nil
+	}
+
+	owner := authRes.Identity()
+	log := logger.FromContext(ctx, p.log)
+	schemes := p.cfg.CreateUserHomeInStorages()
+	if len(schemes) == 0 {
+		schemes = p.storageMux.GetStorageSchemes()
+	}
+	for _, scheme := range schemes {
+		if !contains(st.Created, scheme) {
+			ok, err := p.storageMux.IsUserHomeCreated(ctx, owner, scheme)
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+			if err := p.storageMux.CreateUserHome(ctx, owner, scheme); err != nil {
+				return err
+			}
+			log.Info("user home created", map[string]interface{}{"username": authRes.Username, "scheme": scheme})
+			st.Created = append(st.Created, scheme)
+			if err := p.save(key, st); err != nil {
+				return err
+			}
+		}
+		if !st.Skeleton[scheme] {
+			if err := p.copySkeleton(ctx, owner, scheme); err != nil {
+				return err
+			}
+			st.Skeleton[scheme] = true
+			if err := p.save(key, st); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(st.Created) > 0 {
+		if !st.Quota {
+			if err := p.setQuota(ctx, owner); err != nil {
+				return err
+			}
+			st.Quota = true
+			if err := p.save(key, st); err != nil {
+				return err
+			}
+		}
+		for st.Hooks < len(hooks) {
+			if err := hooks[st.Hooks](ctx, owner, st.Created); err != nil {
+				return err
+			}
+			st.Hooks++
+			if err := p.save(key, st); err != nil {
+				return err
+			}
+		}
+	}
+	st.Provisioned = true
+	return p.save(key, st)
+}
+
+// userLock returns the lock of the user.
+func (p *Provisioner) userLock(key string) *sync.Mutex {
+	p.Lock()
+	defer p.Unlock()
+	l, ok := p.userLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		p.userLocks[key] = l
+	}
+	return l
+}
+
+// state returns a copy of the state of the user and the hooks to run.
+func (p *Provisioner) state(key string) (*userState, []Hook) {
+	p.Lock()
+	defer p.Unlock()
+	st := &userState{}
+	if saved, ok := p.states[key]; ok {
+		st = saved
+	}
+	return st.clone(), append([]Hook(nil), p.hooks...)
+}
+
+// save saves the state of the user after a step has been done.
+func (p *Provisioner) save(key string, st *userState) error {
+	saved := st.clone()
+	p.Lock()
+	defer p.Unlock()
+	p.states[key] = saved
+	return authtoken.SaveJSON(p.filename, p.states)
+}
+
+// clone returns a copy of the state, so the state being modified while provisioning a user is not
+// shared with the states saved.
+func (st *userState) clone() *userState {
+	c := *st
+	c.Created = append([]string(nil), st.Created...)
+	c.Skeleton = map[string]bool{}
+	for scheme, done := range st.Skeleton {
+		c.Skeleton[scheme] = done
+	}
+	return &c
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// copySkeleton copies the files and collections of the skeleton directory to the home directory of the user.
+func (p *Provisioner) copySkeleton(ctx context.Context, authRes *auth.AuthResource, scheme string) error {
+	root := p.cfg.CreateUserHomeSkeletonDir()
+	if root == "" {
+		return nil
+	}
+	return filepath.Walk(root, func(absPath string, finfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, absPath)
+		if err != nil || rel == "." {
+			return err
+		}
+		uri := (&url.URL{Scheme: scheme, Path: "/" + filepath.ToSlash(rel)}).String()
+		if finfo.IsDir() {
+			// the collection exists if a previous copy failed.
+			if err := p.storageMux.CreateCol(ctx, authRes, uri, false); err != nil && !storage.IsExistError(err) {
+				return err
+			}
+			return nil
+		}
+		fd, err := os.Open(absPath)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		return p.storageMux.PutFile(ctx, authRes, uri, fd, finfo.Size())
+	})
+}
+
+// setQuota sets the CreateUserHomeQuota to a new user and recalculates its quota usage.
+func (p *Provisioner) setQuota(ctx context.Context, authRes *auth.AuthResource) error {
+	if homeQuota := p.cfg.CreateUserHomeQuota(); homeQuota != 0 {
+		if err := p.cfg.AddStorageQuota(quota.UserKey(authRes), homeQuota); err != nil {
+			return err
+		}
+	}
+	return p.storageMux.RecalculateQuotaUsage(ctx, authRes)
+}
@@ -0,0 +1,133 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	storagemux "github.com/syncato/lib/storage/mux"
+	"github.com/syncato/lib/storage/storagetest"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	provisioner *Provisioner
+	storageMux  *storagemux.StorageMux
+	storage     *storagetest.MemStorage
+	cfg         *config.Config
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	skeletonDir := filepath.Join(dir, "skeleton")
+	c.Assert(os.MkdirAll(filepath.Join(skeletonDir, "Documents"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(skeletonDir, "Documents", "welcome.txt"), []byte("welcome"), 0644), IsNil)
+
+	cfgFile := filepath.Join(dir, "config.json")
+	data, err := json.Marshal(map[string]interface{}{
+		"create_user_home_on_login":     true,
+		"create_user_home_skeleton_dir": skeletonDir,
+		"create_user_home_quota":        100,
+		"create_user_home_state_file":   filepath.Join(dir, "provisioning.json"),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+	log := logger.NewLogger("test", 0)
+	s.cfg, err = config.New(cfgFile, log)
+	c.Assert(err, IsNil)
+
+	s.storageMux, err = storagemux.NewStorageMux(s.cfg, log)
+	c.Assert(err, IsNil)
+	s.storage = storagetest.NewMemStorage("mem")
+	c.Assert(s.storageMux.AddStorageProvider(s.storage), IsNil)
+	s.provisioner, err = NewProvisioner(s.storageMux, s.cfg, log)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestProvision(c *C) {
+	ctx := context.Background()
+	// the home is provisioned as the user, whatever the credentials used to log in.
+	alice := &auth.AuthResource{AuthID: "json", Username: "alice", Scope: &auth.Scope{ReadOnly: true, Schemes: []string{"other"}}}
+	var hookCalls [][]string
+	s.provisioner.AddHook(func(ctx context.Context, authRes *auth.AuthResource, schemes []string) error {
+		hookCalls = append(hookCalls, schemes)
+		return nil
+	})
+
+	c.Assert(s.provisioner.Provision(ctx, alice), IsNil)
+	c.Assert(s.storage.Paths(alice), DeepEquals, []string{"/Documents/", "/Documents/welcome.txt"})
+	r, err := s.storage.GetFile(ctx, alice, &url.URL{Path: "/Documents/welcome.txt"})
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "welcome")
+	c.Assert(s.cfg.StorageQuotas()["json/alice"], Equals, int64(100))
+	c.Assert(hookCalls, DeepEquals, [][]string{{"mem"}})
+
+	// a second login does nothing.
+	c.Assert(s.provisioner.Provision(ctx, alice), IsNil)
+	c.Assert(hookCalls, HasLen, 1)
+}
+
+func (s *TestSuite) TestFailedSteps(c *C) {
+	ctx := context.Background()
+	bob := &auth.AuthResource{AuthID: "json", Username: "bob"}
+	var calls []string
+	s.provisioner.AddHook(func(ctx context.Context, authRes *auth.AuthResource, schemes []string) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	s.provisioner.AddHook(func(ctx context.Context, authRes *auth.AuthResource, schemes []string) error {
+		calls = append(calls, "second")
+		if len(calls) == 2 {
+			return errors.New("hook failed")
+		}
+		return nil
+	})
+	c.Assert(s.provisioner.Provision(ctx, bob), NotNil)
+	c.Assert(calls, DeepEquals, []string{"first", "second"})
+
+	// the steps done are saved, so after a restart only the failed hook is run again on the next login,
+	// even if the home now exists.
+	p, err := NewProvisioner(s.storageMux, s.cfg, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	p.hooks = s.provisioner.hooks
+	c.Assert(p.Provision(ctx, bob), IsNil)
+	c.Assert(calls, DeepEquals, []string{"first", "second", "second"})
+	c.Assert(s.storage.Paths(bob), DeepEquals, []string{"/Documents/", "/Documents/welcome.txt"})
+
+	c.Assert(p.Provision(ctx, bob), IsNil)
+	c.Assert(calls, HasLen, 3)
+}
+
+func (s *TestSuite) TestConcurrentLogins(c *C) {
+	ctx := context.Background()
+	users := []*auth.AuthResource{}
+	for _, username := range []string{"alice", "bob", "carol", "dave"} {
+		users = append(users, &auth.AuthResource{AuthID: "json", Username: username})
+	}
+	errs := make(chan error, len(users))
+	for _, u := range users {
+		go func(u *auth.AuthResource) { errs <- s.provisioner.Provision(ctx, u) }(u)
+	}
+	for range users {
+		c.Assert(<-errs, IsNil)
+	}
+	// the quota of every user is kept.
+	for _, u := range users {
+		c.Assert(s.cfg.StorageQuotas()["json/"+u.Username], Equals, int64(100))
+	}
+}
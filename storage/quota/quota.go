@@ -0,0 +1,143 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package quota defines the quota manager that limits the space used by the users in the storages.
+package quota
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/syncato/lib/atomicfile"
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/storage"
+)
+
+// Manager implements the storage.Quota interface with the quotas of the configuration.
+// The quotas are read from the configuration on every call, so changes apply without restarting the daemon.
+// The usage of the users is persisted to a JSON file after every change, the reservations of the operations
+// in progress are kept in memory only.
+type Manager struct {
+	sync.Mutex
+	filename string
+	usage    map[string]int64 // AuthID/Username => bytes used
+	reserved map[string]int64 // AuthID/Username => bytes reserved
+	cfg      *config.Config
+}
+
+// NewManager creates a Manager loading the usage of the users from the file.
+// If filename is empty the usage is kept in memory only.
+func NewManager(filename string, cfg *config.Config) (*Manager, error) {
+	m := &Manager{filename: filename, usage: map[string]int64{}, reserved: map[string]int64{}, cfg: cfg}
+	if filename == "" {
+		return m, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.usage); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Get returns the quota and the usage of the user in bytes. The quota is negative if the user has no limit.
+func (m *Manager) Get(authRes *auth.AuthResource) (int64, int64) {
+	quota := m.quota(authRes)
+	m.Lock()
+	defer m.Unlock()
+	return quota, m.usage[UserKey(authRes)]
+}
+
+// Add adds delta bytes to the usage of the user, a negative delta frees space.
+// The usage never goes below zero.
+func (m *Manager) Add(authRes *auth.AuthResource, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	used := m.usage[UserKey(authRes)] + delta
+	if used < 0 {
+		used = 0
+	}
+	m.usage[UserKey(authRes)] = used
+	return m.save()
+}
+
+// Reserve reserves delta bytes for an operation in progress, or returns a QuotaExceededError if the usage
+// and the bytes already reserved plus delta exceed the quota.
+func (m *Manager) Reserve(authRes *auth.AuthResource, delta int64) error {
+	if delta <= 0 {
+		return nil
+	}
+	quota := m.quota(authRes)
+	m.Lock()
+	defer m.Unlock()
+	key := UserKey(authRes)
+	used := m.usage[key] + m.reserved[key]
+	if quota >= 0 && used+delta > quota {
+		return &storage.QuotaExceededError{Username: authRes.Username, Quota: quota, Used: used}
+	}
+	m.reserved[key] += delta
+	return nil
+}
+
+// Release releases delta bytes reserved with Reserve.
+func (m *Manager) Release(authRes *auth.AuthResource, delta int64) {
+	if delta <= 0 {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	key := UserKey(authRes)
+	if m.reserved[key] -= delta; m.reserved[key] <= 0 {
+		delete(m.reserved, key)
+	}
+}
+
+// Set sets the usage of the user.
+func (m *Manager) Set(authRes *auth.AuthResource, used int64) error {
+	m.Lock()
+	defer m.Unlock()
+	m.usage[UserKey(authRes)] = used
+	return m.save()
+}
+
+// quota returns the quota of the user in bytes, negative if the user has no limit.
+func (m *Manager) quota(authRes *auth.AuthResource) int64 {
+	quota, ok := m.cfg.StorageQuotas()[UserKey(authRes)]
+	if !ok {
+		quota = m.cfg.StorageQuotaDefault()
+		if quota == 0 {
+			quota = -1
+		}
+	}
+	return quota
+}
+
+// save persists the usage. It must be called with the lock held.
+func (m *Manager) save() error {
+	if m.filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(m.usage)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(m.filename, data, 0600)
+}
+
+// UserKey returns the key of the user in StorageQuotas and in the usage, AuthID/Username, so users with
+// the same username in different auth providers are different users.
+func UserKey(authRes *auth.AuthResource) string {
+	return authRes.AuthID + "/" + authRes.Username
+}
@@ -0,0 +1,69 @@
+package quota
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/config"
+	"github.com/syncato/lib/logger"
+	"github.com/syncato/lib/storage"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	filename string
+	cfg      *config.Config
+	alice    *auth.AuthResource
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	cfgFile := filepath.Join(dir, "config.json")
+	data, err := json.Marshal(map[string]interface{}{"storage_quota_default": 10, "storage_quotas": map[string]int64{"json/root": -1}})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(cfgFile, data, 0600), IsNil)
+	s.cfg, err = config.New(cfgFile, logger.NewLogger("test", 0))
+	c.Assert(err, IsNil)
+	s.filename = filepath.Join(dir, "usage.json")
+	s.alice = &auth.AuthResource{AuthID: "json", Username: "alice"}
+}
+
+func (s *TestSuite) TestQuota(c *C) {
+	m, err := NewManager(s.filename, s.cfg)
+	c.Assert(err, IsNil)
+	quota, _ := m.Get(&auth.AuthResource{AuthID: "json", Username: "root"})
+	c.Assert(quota, Equals, int64(-1))
+	// the quotas are set by auth provider too.
+	quota, _ = m.Get(&auth.AuthResource{AuthID: "ldap", Username: "root"})
+	c.Assert(quota, Equals, int64(10))
+
+	c.Assert(m.Add(s.alice, 4), IsNil)
+	// users with the same username in other auth providers have their own usage.
+	_, used := m.Get(&auth.AuthResource{AuthID: "ldap", Username: "alice"})
+	c.Assert(used, Equals, int64(0))
+
+	m, err = NewManager(s.filename, s.cfg)
+	c.Assert(err, IsNil)
+	quota, used = m.Get(s.alice)
+	c.Assert(quota, Equals, int64(10))
+	c.Assert(used, Equals, int64(4))
+}
+
+func (s *TestSuite) TestReserve(c *C) {
+	m, err := NewManager("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(m.Add(s.alice, 4), IsNil)
+	c.Assert(m.Reserve(s.alice, 5), IsNil)
+	// the reserved bytes count against the quota until they are released.
+	c.Assert(storage.IsQuotaExceededError(m.Reserve(s.alice, 2)), Equals, true)
+	m.Release(s.alice, 5)
+	c.Assert(m.Reserve(s.alice, 6), IsNil)
+}
@@ -7,9 +7,12 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"github.com/syncato/lib/auth"
 	"io"
 	"net/url"
+	"strings"
 )
 
 // StorageProvider is the interface that all the storage providers must implement
@@ -17,38 +20,41 @@ import (
 // An storage provider is defined by an id called Scheme.
 //
 // A resource is uniquely identified by a URI http://en.wikipedia.org/wiki/Uniform_resource_identifier
+//
+// The context of the operations is the context of the request, so implementations should stop
+// and return the error of the context when it is cancelled, like when the client disconnects.
 type StorageProvider interface {
 	// GetScheme returns the scheme/id of this storage.
 	GetScheme() string
 
 	// CreateUserHome creates the user home directory in the storage.
-	CreateUserHome(authRes *auth.AuthResource) error
+	CreateUserHome(ctx context.Context, authRes *auth.AuthResource) error
 
 	// IsUserHomeCreated checks if the user home directory has been created or not.
-	IsUserHomeCreated(authRes *auth.AuthResource) (bool, error)
+	IsUserHomeCreated(ctx context.Context, authRes *auth.AuthResource) (bool, error)
 
 	// PutFile puts a file into the storage defined by the uri.
-	PutFile(authRes *auth.AuthResource, uri *url.URL, r io.Reader, size int64) error
+	PutFile(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, r io.Reader, size int64) error
 
 	// GetFile gets a file from the storage defined by the uri.
-	GetFile(authRes *auth.AuthResource, uri *url.URL) (io.Reader, error)
+	GetFile(ctx context.Context, authRes *auth.AuthResource, uri *url.URL) (io.Reader, error)
 
 	// Stat returns metadata information about the resources and its children.
-	Stat(authRes *auth.AuthResource, uri *url.URL, children bool) (*MetaData, error)
+	Stat(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, children bool) (*MetaData, error)
 
 	// Remove removes a resource from the storage defined by the uri.
-	Remove(authRes *auth.AuthResource, uri *url.URL, recursive bool) error
+	Remove(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, recursive bool) error
 
 	// CreateCol creates a collection in the storage defined by the uri.
-	CreateCol(authRes *auth.AuthResource, uri *url.URL, recursive bool) error
+	CreateCol(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, recursive bool) error
 
 	// Copy copies a resource from one uri to another.
 	// If uris belong to different storages this is a cross-storage copy.
-	Copy(authRes *auth.AuthResource, fromUri, toUri *url.URL) error
+	Copy(ctx context.Context, authRes *auth.AuthResource, fromUri, toUri *url.URL) error
 
 	// Rename renames/move a resource from one uri to another.
 	// If uris belong to different storages this is a cross-storage rename.
-	Rename(authRes *auth.AuthResource, fromUri, toUri *url.URL) error
+	Rename(ctx context.Context, authRes *auth.AuthResource, fromUri, toUri *url.URL) error
 
 	// ConvertError convert a storage provider implementation error to the ones defined in this package.
 	// This is needed to provide the same logic independently of the storage provider implementation.
@@ -60,11 +66,28 @@ type StorageProvider interface {
 	// GetCapabilities returns the capabilities of this storage.
 	GetCapabilities() *Capabilities
 
-	/*
-		// SHARE OPERATIONS
-		ShareCol(authRes *auth.AuthResource, uri *url.URL, username string, perm *Permissions) error
-		ShareByLink(authRes *auth.AuthResource, uri *url.URL, perm *Permissions) error
+	// GetProperty returns the value of a custom property of the resource, or a NotExistError if it is not set.
+	GetProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name string) (string, error)
+
+	// SetProperty sets a custom property of the resource, replacing its value if it is already set.
+	SetProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name, value string) error
+
+	// RemoveProperty removes a custom property of the resource. Removing a property that is not set is not an error.
+	RemoveProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name string) error
+
+	// ListProperties returns all the custom properties of the resource.
+	ListProperties(ctx context.Context, authRes *auth.AuthResource, uri *url.URL) (map[string]string, error)
 
+	// GetChanges returns at most limit changes of the resources of the user after the cursor, the oldest first.
+	// A cursor of 0 returns the changes since the beginning of the journal. It returns a CursorExpiredError
+	// if the changes after the cursor are no longer in the journal.
+	GetChanges(ctx context.Context, authRes *auth.AuthResource, cursor uint64, limit int) (*ChangeList, error)
+
+	// GetPathByID returns the URI of the resource of the user with the ID, the ID of the storage without the
+	// scheme, see SplitFileID. It returns a NotExistError if no resource has the ID.
+	GetPathByID(ctx context.Context, authRes *auth.AuthResource, id string) (string, error)
+
+	/*
 		// MISC
 
 			Install(v interface{}) error
@@ -87,19 +110,174 @@ type StorageProvider interface {
 
 }
 
+// Authorizer is the interface used by the storage multiplexer to decide if a user can
+// access a storage.
+type Authorizer interface {
+	CanAccessScheme(authRes *auth.AuthResource, scheme string) bool
+}
+
+// SharedScheme is the scheme of the resources shared with a user by other users, the "Shared with me"
+// collection. The URI shared://<share id>/<path> is the path inside the resource of the share.
+const SharedScheme = "shared"
+
+// ShareResolver is the interface used by the storage multiplexer to resolve the resources shared with a user,
+// mounted in the namespace of the user with the shared scheme.
+type ShareResolver interface {
+	// ResolveShare returns the resource with the id shared with the user, or a NotExistError.
+	ResolveShare(authRes *auth.AuthResource, id string) (*SharedResource, error)
+
+	// ListShares returns the resources shared with the user.
+	ListShares(authRes *auth.AuthResource) []*SharedResource
+}
+
+// SharedResource is a resource that a user shares with other users.
+type SharedResource struct {
+	ID          string             // the ID of the share.
+	Owner       *auth.AuthResource // the user sharing the resource, the operations are done as this user.
+	URI         string             // the URI of the resource in the storage of the owner.
+	IsCol       bool               // if the resource is a collection.
+	Permissions *Permissions       // what the users the resource is shared with can do.
+}
+
+// Quota is the interface used by the storage multiplexer to limit the space used by the users.
+// The usage is kept up to date by the multiplexer on every operation changing it.
+type Quota interface {
+	// Get returns the quota and the usage of the user in bytes. The quota is negative if the user has no limit.
+	Get(authRes *auth.AuthResource) (quota, used int64)
+
+	// Add adds delta bytes to the usage of the user, a negative delta frees space.
+	Add(authRes *auth.AuthResource, delta int64) error
+
+	// Reserve reserves delta bytes for an operation in progress, or returns a QuotaExceededError if the usage
+	// and the bytes already reserved plus delta exceed the quota. The check and the reservation are atomic,
+	// so concurrent operations cannot exceed the quota together.
+	// The operations add the bytes written with Add before releasing the reservation with Release.
+	Reserve(authRes *auth.AuthResource, delta int64) error
+
+	// Release releases delta bytes reserved with Reserve.
+	Release(authRes *auth.AuthResource, delta int64)
+
+	// Set sets the usage of the user, used when it is recalculated from the storages.
+	Set(authRes *auth.AuthResource, used int64) error
+}
+
+// CopyContext copies from src to dst like io.Copy but stops with the error of the context
+// when the context is cancelled.
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
 // MetaData represents the metadata information about a resource.
 type MetaData struct {
-	Id           string      `json:"id"`            // The id of this resource.
-	Path         string      `json:"path"`          // The path of this resource.
-	Size         uint64      `json:"size"`          // The size of this resource.
-	IsCol        bool        `json:"iscol"`         // Indicates if the resource is a collection.
-	MimeType     string      `json:"mime_type"`     // The mimetype of the resource.
-	Checksum     string      `json:"checksum"`      // The checksum of the resource.
-	ChecksumType string      `json:"checksum_type"` // The type of checksum used to calculate the checksum.
-	Modified     uint64      `json:"modified"`      // The latest time the resource has been modified.
-	ETag         string      `json:"etag"`          // The ETag http://en.wikipedia.org/wiki/HTTP_ETag.
-	Children     []*MetaData `json:"children"`      // If this resource is a collection contains all the children´s metadata.
-	Extra        interface{} `json:"extra"`         // Contains extra attributes defined by the storage provider implementation.
+	Id           string      `json:"id"`              // The id of this resource, see JoinFileID.
+	Path         string      `json:"path"`            // The path of this resource.
+	Size         uint64      `json:"size"`            // The size of this resource.
+	IsCol        bool        `json:"iscol"`           // Indicates if the resource is a collection.
+	MimeType     string      `json:"mime_type"`       // The mimetype of the resource.
+	Checksum     string      `json:"checksum"`        // The checksum of the resource.
+	ChecksumType string      `json:"checksum_type"`   // The type of checksum used to calculate the checksum.
+	Modified     uint64      `json:"modified"`        // The latest time the resource has been modified.
+	ETag         string      `json:"etag"`            // The ETag http://en.wikipedia.org/wiki/HTTP_ETag.
+	Children     []*MetaData `json:"children"`        // If this resource is a collection contains all the children´s metadata.
+	Extra        interface{} `json:"extra"`           // Contains extra attributes defined by the storage provider implementation.
+	Quota        *QuotaInfo  `json:"quota,omitempty"` // The quota of the user, only for the home collection.
+
+	// The custom properties of the resource, like the dead properties of WebDAV, tags or favourites.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// JoinFileID returns the ID of a resource, unique in all the storages, from the scheme of its storage and
+// its ID in the storage. The ID of a resource does not change when it is renamed, so clients can follow it.
+func JoinFileID(scheme, id string) string {
+	return scheme + ":" + id
+}
+
+// SplitFileID returns the scheme of the storage and the ID in the storage of the ID of a resource.
+func SplitFileID(fileID string) (scheme, id string, ok bool) {
+	parts := strings.SplitN(fileID, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// ChangeType is the type of a change of a resource.
+type ChangeType string
+
+const (
+	ChangeCreate ChangeType = "create"
+	ChangeModify ChangeType = "modify" // the content or the custom properties of the resource changed.
+	ChangeDelete ChangeType = "delete"
+	ChangeMove   ChangeType = "move"
+)
+
+// Change is a change of a resource recorded in the change journal of its owner.
+type Change struct {
+	Cursor  uint64     `json:"cursor"`             // The position of the change in the journal, increasing with every change.
+	Type    ChangeType `json:"type"`               // The type of the change.
+	Id      string     `json:"id"`                 // The id of the resource, see JoinFileID.
+	Path    string     `json:"path"`               // The path of the resource after the change.
+	OldPath string     `json:"old_path,omitempty"` // The path of the resource before a move.
+	IsCol   bool       `json:"iscol"`              // Indicates if the resource is a collection.
+	Time    uint64     `json:"time"`               // The time of the change.
+}
+
+// ChangeList is a page of the changes of the resources of a user.
+type ChangeList struct {
+	Changes []*Change `json:"changes"`
+	Cursor  uint64    `json:"cursor"`   // The cursor to get the next page, the cursor of the last change.
+	HasMore bool      `json:"has_more"` // Indicates if there are more changes after the cursor.
+}
+
+// MaxChangesPageSize is the maximum number of changes returned at once.
+const MaxChangesPageSize = 1000
+
+// MaxPropertyNameSize and MaxPropertyValueSize are the maximum sizes in bytes of the custom properties,
+// so they can be stored in the extended attributes of most filesystems.
+const (
+	MaxPropertyNameSize  = 200
+	MaxPropertyValueSize = 4000
+)
+
+// ValidateProperty checks the name and the value of a custom property. The name cannot be empty and
+// neither the name nor the value can contain null characters or be longer than the maximum sizes.
+func ValidateProperty(name, value string) error {
+	if name == "" || len(name) > MaxPropertyNameSize || strings.ContainsRune(name, 0) {
+		return &InvalidPropertyError{fmt.Sprintf("invalid property name '%s'", name)}
+	}
+	if len(value) > MaxPropertyValueSize || strings.ContainsRune(value, 0) {
+		return &InvalidPropertyError{fmt.Sprintf("invalid value for property '%s'", name)}
+	}
+	return nil
+}
+
+// QuotaInfo represents the quota and the space used by a user.
+type QuotaInfo struct {
+	Quota int64 `json:"quota"` // The quota in bytes, negative if the user has no limit.
+	Used  int64 `json:"used"`  // The space used in bytes.
 }
 
 // Capabilites reprents the capabilities of a storage
@@ -107,10 +285,11 @@ type MetaData struct {
 type Capabilities struct {
 }
 
+// Permissions represents what a user can do with a resource of another user.
 type Permissions struct {
-	Read   bool
-	Write  bool
-	Delete bool
+	Read   bool `json:"read"`   // get and stat the resources.
+	Write  bool `json:"write"`  // put files and create collections.
+	Delete bool `json:"delete"` // remove the resources.
 }
 
 type ExistError struct {
@@ -125,6 +304,42 @@ type NotExistError struct {
 
 func (e *NotExistError) Error() string { return e.Err }
 
+// PermissionDeniedError represents an operation the user is not allowed to do.
+type PermissionDeniedError struct {
+	Err string
+}
+
+func (e *PermissionDeniedError) Error() string { return e.Err }
+
+// QuotaExceededError is returned when an operation would use more space than the quota of the user.
+type QuotaExceededError struct {
+	Username string
+	Quota    int64
+	Used     int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota of user %s exceeded: %d bytes used of %d", e.Username, e.Used, e.Quota)
+}
+
+// InvalidPropertyError is returned when the name or the value of a custom property is not valid.
+type InvalidPropertyError struct {
+	Err string
+}
+
+func (e *InvalidPropertyError) Error() string { return e.Err }
+
+// CursorExpiredError is returned when the changes after a cursor are no longer in the change journal,
+// so the client must sync the whole tree and continue from the Latest cursor.
+type CursorExpiredError struct {
+	Cursor uint64
+	Latest uint64
+}
+
+func (e *CursorExpiredError) Error() string {
+	return fmt.Sprintf("cursor %d expired, the latest cursor is %d", e.Cursor, e.Latest)
+}
+
 type CrossStorageCopyNotImplemented struct {
 }
 
@@ -151,3 +366,26 @@ func IsNotExistError(err error) bool {
 	}
 	return false
 }
+
+func IsPermissionDeniedError(err error) bool {
+	_, ok := err.(*PermissionDeniedError)
+	return ok
+}
+
+// IsQuotaExceededError checks if the error is a QuotaExceededError.
+func IsQuotaExceededError(err error) bool {
+	_, ok := err.(*QuotaExceededError)
+	return ok
+}
+
+// IsInvalidPropertyError checks if the error is an InvalidPropertyError.
+func IsInvalidPropertyError(err error) bool {
+	_, ok := err.(*InvalidPropertyError)
+	return ok
+}
+
+// IsCursorExpiredError checks if the error is a CursorExpiredError.
+func IsCursorExpiredError(err error) bool {
+	_, ok := err.(*CursorExpiredError)
+	return ok
+}
@@ -0,0 +1,345 @@
+// Copyright 2015 The Syncato Authors.  All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// Package storagetest provides an in memory storage provider to test the code built on top of the storage
+// providers, like the storage multiplexer and the packages using it.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syncato/lib/auth"
+	"github.com/syncato/lib/storage"
+)
+
+// MemStorage is a storage.StorageProvider keeping the homes of the users in memory.
+// The homes are identified by the AuthID and the Username of the users and must be created with
+// CreateUserHome before using them. The file and collection operations are recorded in Ops.
+type MemStorage struct {
+	sync.Mutex
+	Ops    []string // the operations done, like "put alice /photos/beach.png".
+	scheme string
+	homes  map[string]map[string]*memResource // AuthID/Username => clean path => resource
+}
+
+type memResource struct {
+	isCol bool
+	data  []byte
+	props map[string]string
+}
+
+// NewMemStorage returns a MemStorage object for the storage scheme.
+func NewMemStorage(scheme string) *MemStorage {
+	return &MemStorage{scheme: scheme, homes: map[string]map[string]*memResource{}}
+}
+
+// Paths returns the sorted paths of the resources in the home of the user, the paths of the collections
+// end with a slash. The root of the home is not included.
+func (s *MemStorage) Paths(authRes *auth.AuthResource) []string {
+	s.Lock()
+	defer s.Unlock()
+	paths := []string{}
+	for p, res := range s.homes[homeKey(authRes)] {
+		if p == "/" {
+			continue
+		}
+		if res.isCol {
+			p += "/"
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *MemStorage) GetScheme() string { return s.scheme }
+
+func (s *MemStorage) CreateUserHome(ctx context.Context, authRes *auth.AuthResource) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.homes[homeKey(authRes)]; !ok {
+		s.homes[homeKey(authRes)] = map[string]*memResource{"/": {isCol: true}}
+	}
+	return nil
+}
+
+func (s *MemStorage) IsUserHomeCreated(ctx context.Context, authRes *auth.AuthResource) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.homes[homeKey(authRes)]
+	return ok, nil
+}
+
+func (s *MemStorage) PutFile(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, r io.Reader, size int64) error {
+	s.record("put", authRes, uri)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	home, p, err := s.home(authRes, uri)
+	if err != nil {
+		return err
+	}
+	if parent, ok := home[path.Dir(p)]; !ok || !parent.isCol {
+		return &storage.NotExistError{Err: fmt.Sprintf("parent of %s not found", p)}
+	}
+	if res, ok := home[p]; ok && res.isCol {
+		return &storage.ExistError{Err: fmt.Sprintf("%s is a collection", p)}
+	}
+	home[p] = &memResource{data: data}
+	return nil
+}
+
+func (s *MemStorage) GetFile(ctx context.Context, authRes *auth.AuthResource, uri *url.URL) (io.Reader, error) {
+	s.record("get", authRes, uri)
+	s.Lock()
+	defer s.Unlock()
+	res, _, err := s.resource(authRes, uri)
+	if err != nil {
+		return nil, err
+	}
+	if res.isCol {
+		return nil, &storage.ExistError{Err: fmt.Sprintf("%s is a collection", uri.Path)}
+	}
+	return bytes.NewReader(res.data), nil
+}
+
+func (s *MemStorage) Stat(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, children bool) (*storage.MetaData, error) {
+	s.record("stat", authRes, uri)
+	s.Lock()
+	defer s.Unlock()
+	res, p, err := s.resource(authRes, uri)
+	if err != nil {
+		return nil, err
+	}
+	meta := s.meta(p, res)
+	if !res.isCol || !children {
+		return meta, nil
+	}
+	home := s.homes[homeKey(authRes)]
+	for q, child := range home {
+		if q != "/" && path.Dir(q) == p {
+			meta.Children = append(meta.Children, s.meta(q, child))
+		}
+	}
+	return meta, nil
+}
+
+func (s *MemStorage) Remove(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, recursive bool) error {
+	s.record("remove", authRes, uri)
+	s.Lock()
+	defer s.Unlock()
+	_, p, err := s.resource(authRes, uri)
+	if err != nil {
+		return err
+	}
+	home := s.homes[homeKey(authRes)]
+	for q := range home {
+		// the root of the home is never removed.
+		if q != "/" && isInside(q, p) {
+			delete(home, q)
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) CreateCol(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, recursive bool) error {
+	s.record("mkcol", authRes, uri)
+	s.Lock()
+	defer s.Unlock()
+	home, p, err := s.home(authRes, uri)
+	if err != nil {
+		return err
+	}
+	if _, ok := home[p]; ok {
+		return &storage.ExistError{Err: fmt.Sprintf("%s already exists", p)}
+	}
+	for q := path.Dir(p); q != "/"; q = path.Dir(q) {
+		if parent, ok := home[q]; ok {
+			if !parent.isCol {
+				return &storage.ExistError{Err: fmt.Sprintf("%s is not a collection", q)}
+			}
+			break
+		}
+		if !recursive {
+			return &storage.NotExistError{Err: fmt.Sprintf("parent of %s not found", p)}
+		}
+		home[q] = &memResource{isCol: true}
+	}
+	home[p] = &memResource{isCol: true}
+	return nil
+}
+
+func (s *MemStorage) Copy(ctx context.Context, authRes *auth.AuthResource, fromUri, toUri *url.URL) error {
+	s.record("copy", authRes, fromUri)
+	return s.move(authRes, fromUri, toUri, false)
+}
+
+func (s *MemStorage) Rename(ctx context.Context, authRes *auth.AuthResource, fromUri, toUri *url.URL) error {
+	s.record("rename", authRes, fromUri)
+	return s.move(authRes, fromUri, toUri, true)
+}
+
+func (s *MemStorage) ConvertError(err error) error { return err }
+
+func (s *MemStorage) GetCapabilities() *storage.Capabilities { return &storage.Capabilities{} }
+
+func (s *MemStorage) GetProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	res, _, err := s.resource(authRes, uri)
+	if err != nil {
+		return "", err
+	}
+	value, ok := res.props[name]
+	if !ok {
+		return "", &storage.NotExistError{Err: fmt.Sprintf("property %s not found", name)}
+	}
+	return value, nil
+}
+
+func (s *MemStorage) SetProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name, value string) error {
+	s.Lock()
+	defer s.Unlock()
+	res, _, err := s.resource(authRes, uri)
+	if err != nil {
+		return err
+	}
+	if res.props == nil {
+		res.props = map[string]string{}
+	}
+	res.props[name] = value
+	return nil
+}
+
+func (s *MemStorage) RemoveProperty(ctx context.Context, authRes *auth.AuthResource, uri *url.URL, name string) error {
+	s.Lock()
+	defer s.Unlock()
+	res, _, err := s.resource(authRes, uri)
+	if err != nil {
+		return err
+	}
+	delete(res.props, name)
+	return nil
+}
+
+func (s *MemStorage) ListProperties(ctx context.Context, authRes *auth.AuthResource, uri *url.URL) (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	res, _, err := s.resource(authRes, uri)
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]string{}
+	for name, value := range res.props {
+		props[name] = value
+	}
+	return props, nil
+}
+
+// GetChanges does not keep a change journal, there are never changes after the cursor.
+func (s *MemStorage) GetChanges(ctx context.Context, authRes *auth.AuthResource, cursor uint64, limit int) (*storage.ChangeList, error) {
+	return &storage.ChangeList{Changes: []*storage.Change{}, Cursor: cursor}, nil
+}
+
+// GetPathByID does not support IDs, no resource is found.
+func (s *MemStorage) GetPathByID(ctx context.Context, authRes *auth.AuthResource, id string) (string, error) {
+	return "", &storage.NotExistError{Err: fmt.Sprintf("resource with id %s not found", id)}
+}
+
+// move copies or renames a resource and the resources inside it, replacing the resources at the target.
+func (s *MemStorage) move(authRes *auth.AuthResource, fromUri, toUri *url.URL, rename bool) error {
+	s.Lock()
+	defer s.Unlock()
+	_, from, err := s.resource(authRes, fromUri)
+	if err != nil {
+		return err
+	}
+	home, to, err := s.home(authRes, toUri)
+	if err != nil {
+		return err
+	}
+	if parent, ok := home[path.Dir(to)]; !ok || !parent.isCol {
+		return &storage.NotExistError{Err: fmt.Sprintf("parent of %s not found", to)}
+	}
+	if isInside(to, from) {
+		return &storage.ExistError{Err: fmt.Sprintf("%s cannot be moved inside itself", from)}
+	}
+	moved := map[string]*memResource{}
+	for q, res := range home {
+		if isInside(q, from) {
+			moved[to+strings.TrimPrefix(q, from)] = res
+			if rename {
+				delete(home, q)
+			}
+		}
+	}
+	for q := range home {
+		if isInside(q, to) {
+			delete(home, q)
+		}
+	}
+	for q, res := range moved {
+		cp := *res
+		home[q] = &cp
+	}
+	return nil
+}
+
+// home returns the home of the user and the clean path of the URI, or a NotExistError if the home
+// has not been created.
+func (s *MemStorage) home(authRes *auth.AuthResource, uri *url.URL) (map[string]*memResource, string, error) {
+	home, ok := s.homes[homeKey(authRes)]
+	if !ok {
+		return nil, "", &storage.NotExistError{Err: fmt.Sprintf("home of %s not found", authRes.Username)}
+	}
+	return home, path.Clean("/" + uri.Path), nil
+}
+
+// resource returns the resource of the URI and its clean path.
+func (s *MemStorage) resource(authRes *auth.AuthResource, uri *url.URL) (*memResource, string, error) {
+	home, p, err := s.home(authRes, uri)
+	if err != nil {
+		return nil, "", err
+	}
+	res, ok := home[p]
+	if !ok {
+		return nil, "", &storage.NotExistError{Err: fmt.Sprintf("%s not found", p)}
+	}
+	return res, p, nil
+}
+
+func (s *MemStorage) meta(p string, res *memResource) *storage.MetaData {
+	return &storage.MetaData{
+		Path:  (&url.URL{Scheme: s.scheme, Path: p}).String(),
+		IsCol: res.isCol,
+		Size:  uint64(len(res.data)),
+	}
+}
+
+func (s *MemStorage) record(op string, authRes *auth.AuthResource, uri *url.URL) {
+	s.Lock()
+	defer s.Unlock()
+	s.Ops = append(s.Ops, op+" "+authRes.Username+" "+path.Clean("/"+uri.Path))
+}
+
+func homeKey(authRes *auth.AuthResource) string {
+	return authRes.AuthID + "/" + authRes.Username
+}
+
+// isInside checks if the path p is the path parent or is inside it.
+func isInside(p, parent string) bool {
+	return p == parent || parent == "/" || strings.HasPrefix(p, parent+"/")
+}